@@ -0,0 +1,53 @@
+package api
+
+// Access represents the access level a user has to a specific repository
+type Access string
+
+const (
+	// AccessNone is used when the user is not allowed to access the repository at all
+	AccessNone Access = "none"
+
+	// AccessRead allows the user to clone and fetch from a repository
+	AccessRead Access = "read"
+
+	// AccessWrite allows the user to push to a repository
+	AccessWrite Access = "write"
+
+	// AccessAdmin allows the user to manage a repository
+	AccessAdmin Access = "admin"
+)
+
+func (a Access) level() int {
+	switch a {
+	case AccessRead:
+		return 1
+	case AccessWrite:
+		return 2
+	case AccessAdmin:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Allows checks if this access level includes the supplied access level
+func (a Access) Allows(required Access) bool {
+	return a.level() >= required.level()
+}
+
+// RepositoryAccess is the access level a specific user has to a specific repository
+type RepositoryAccess struct {
+	// Repository is the name of the repository
+	Repository string
+
+	// User is the name of the user
+	User string
+
+	// Access is the access level the user has to the repository
+	Access Access
+}
+
+// RepositoryAccesses contains the access explicitly granted to users for a specific repository
+type RepositoryAccesses struct {
+	Accesses []RepositoryAccess
+}
@@ -0,0 +1,88 @@
+package api
+
+import "time"
+
+// CertificateType is what a certificate issued by the api server's certificate authority is used for
+type CertificateType string
+
+const (
+	// CertificateTypeServer is used by servers, such as the api server, when serving https
+	CertificateTypeServer CertificateType = "server"
+
+	// CertificateTypeClient is a client-side certificate used when authenticating against the api server
+	CertificateTypeClient CertificateType = "client"
+)
+
+// IsValid checks if this is a known certificate type
+func (t CertificateType) IsValid() bool {
+	return t == CertificateTypeServer || t == CertificateTypeClient
+}
+
+// Certificate is a certificate issued by the api server's certificate authority. The private key is never part of
+// the certificate
+type Certificate struct {
+	// Serial is the hex encoded serial number of the certificate
+	Serial string
+
+	// CommonName is the common name of the certificate's subject. Client-side certificates are mapped to a user or
+	// a service using the common name
+	CommonName string
+
+	// Type is what the certificate is used for
+	Type CertificateType
+
+	// DNSNames contains the host names a server certificate is valid for
+	DNSNames []string
+
+	// IPAddresses contains the ip addresses a server certificate is valid for
+	IPAddresses []string
+
+	// IssuedBy is the name of the user that issued the certificate
+	IssuedBy string
+
+	// NotBefore is when the certificate starts to be valid
+	NotBefore time.Time
+
+	// NotAfter is when the certificate expires
+	NotAfter time.Time
+
+	// RevokedAt is when the certificate was revoked. Nil if it's not revoked
+	RevokedAt *time.Time
+
+	// RevokedBy is the name of the user that revoked the certificate
+	RevokedBy string
+}
+
+type Certificates struct {
+	Certificates []Certificate
+}
+
+// NewCertificate is the body sent when issuing a certificate
+type NewCertificate struct {
+	// CommonName is the common name of the certificate's subject
+	CommonName string
+
+	// Type is what the certificate is used for
+	Type CertificateType
+
+	// DNSNames contains the host names a server certificate is valid for. The common name is used if empty
+	DNSNames []string
+
+	// IPAddresses contains the ip addresses a server certificate is valid for
+	IPAddresses []string
+
+	// NotAfter is when the certificate expires. The server default is used if nil
+	NotAfter *time.Time
+}
+
+// IssuedCertificate is the response sent when a certificate is issued. This is the only time the private key is
+// available
+type IssuedCertificate struct {
+	Certificate
+
+	// CertificatePEM is the PEM encoded certificate
+	CertificatePEM string
+
+	// PrivateKeyPEM is the PEM encoded private key
+	PrivateKeyPEM string
+}
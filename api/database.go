@@ -0,0 +1,36 @@
+package api
+
+import "time"
+
+// DatabaseRevision is a change made to the api server's database
+type DatabaseRevision struct {
+	// ID identifies the revision
+	ID string
+
+	// Author is the name of the user that made the change
+	Author string
+
+	// Message describes the change
+	Message string
+
+	// CreatedAt is when the change was made
+	CreatedAt time.Time
+}
+
+// DatabaseRevisions is the response sent when fetching the history of a path in the database
+type DatabaseRevisions struct {
+	// Path is the path in the database, for example "/users.json"
+	Path string
+
+	// Revisions contains the changes made to the path, newest first
+	Revisions []DatabaseRevision
+}
+
+// DatabaseRevert is the body sent when changing the content of a path in the database back to an earlier revision
+type DatabaseRevert struct {
+	// Path is the path in the database, for example "/users.json"
+	Path string
+
+	// Revision is the revision to change back to
+	Revision string
+}
@@ -1,6 +1,46 @@
 package api
 
+// Machine-readable codes that describes why a request failed
+const (
+	ErrorCodeBadRequest       = "bad_request"
+	ErrorCodeValidation       = "validation_failed"
+	ErrorCodeUnauthorized     = "unauthorized"
+	ErrorCodeForbidden        = "forbidden"
+	ErrorCodeNotFound         = "not_found"
+	ErrorCodeMethodNotAllowed = "method_not_allowed"
+	ErrorCodeConflict         = "conflict"
+	ErrorCodeRateLimited      = "rate_limited"
+	ErrorCodeInternal         = "internal_error"
+)
+
 type Error struct {
-	URI    string
+	// Code is a machine-readable code that describes why the request failed, for example "not_found"
+	Code string
+
+	// URI is the uri of the request that failed
+	URI string
+
+	// Reason is the http status text
 	Reason string
+
+	// Message is a human-readable description of why the request failed
+	Message string
+
+	// RequestUUID identifies the request. It's also found in the api server logs
+	RequestUUID string
+
+	// Fields contains details about each field that failed validation
+	Fields []FieldError
+
+	// Permission is the permission that's missing, if the request was denied because of it
+	Permission Permission
+}
+
+// FieldError describes why a specific field in the request body is not valid
+type FieldError struct {
+	// Field is the name of the field
+	Field string
+
+	// Message is a human-readable description of why the field is not valid
+	Message string
 }
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// GitEventType is the kind of git operation that's reported by the git server
+type GitEventType string
+
+const (
+	// GitEventPush is reported when a push is accepted
+	GitEventPush GitEventType = "push"
+
+	// GitEventFetch is reported when a repository is cloned or fetched from
+	GitEventFetch GitEventType = "fetch"
+)
+
+// GitEvent is sent by the git server when something happens to a repository
+type GitEvent struct {
+	// Type is the kind of operation
+	Type GitEventType
+
+	// Repository is the name of the repository
+	Repository string
+
+	// User is the name of the user that executed the operation
+	User string
+
+	// Protocol is the protocol used by the client, "ssh" or "http"
+	Protocol string
+
+	// RefUpdates contains the references that are changed by a push
+	RefUpdates []RefUpdate
+}
+
+// EventRecord is an event stored in the api server's event log
+type EventRecord struct {
+	// Sequence is an increasing number that identifies the event. It never changes, not even if the api server
+	// is restarted
+	Sequence uint64
+
+	// CreatedAt is when the event was raised
+	CreatedAt time.Time
+
+	// Type is the kind of event, for example "EventUserAdded"
+	Type string
+
+	// Data contains the event itself. Secrets, such as password hashes, are never part of it
+	Data json.RawMessage
+}
+
+// EventRecords is the response sent when reading the event log
+type EventRecords struct {
+	// Sequence is the sequence number of the latest event that's returned. It's sent as "since" in the
+	// next request
+	Sequence uint64
+
+	// Records contains the events that occurred after the requested sequence number, oldest first
+	Records []EventRecord
+}
@@ -0,0 +1,49 @@
+package api
+
+// InvalidationType describes what kind of cached data that's no longer valid
+type InvalidationType string
+
+const (
+	// InvalidateUser means that everything cached about a user, including its public keys and access, is invalid
+	InvalidateUser InvalidationType = "user"
+
+	// InvalidatePublicKey means that the user cached for a specific fingerprint is invalid
+	InvalidatePublicKey InvalidationType = "public_key"
+
+	// InvalidateRepository means that the access cached for a specific repository is invalid
+	InvalidateRepository InvalidationType = "repository"
+
+	// InvalidateAll means that everything that's cached is invalid. This is sent if the api server doesn't know
+	// exactly what has changed, for example if it has been restarted
+	InvalidateAll InvalidationType = "all"
+)
+
+// Invalidation tells a client that some of its cached data is no longer valid
+type Invalidation struct {
+	// Sequence is an increasing number that identifies this invalidation
+	Sequence uint64
+
+	// Type is what kind of data that's invalid
+	Type InvalidationType
+
+	// User is the name of the user, if the type is InvalidateUser
+	User string
+
+	// Fingerprint is the fingerprint of the public key, if the type is InvalidatePublicKey
+	Fingerprint string
+
+	// Repository is the name of the repository, if the type is InvalidateRepository
+	Repository string
+}
+
+// Invalidations is the response sent when a client waits for invalidations
+type Invalidations struct {
+	// Epoch identifies the current api server process. The sequence numbers are only valid for a specific epoch
+	Epoch string
+
+	// Sequence is the sequence number of the latest invalidation. It's sent as "since" in the next request
+	Sequence uint64
+
+	// Invalidations contains all invalidations that occurred after the requested sequence number
+	Invalidations []Invalidation
+}
@@ -0,0 +1,103 @@
+package api
+
+import "time"
+
+// OrganizationRole is the role a member has in an organization
+type OrganizationRole string
+
+const (
+	// OrganizationRoleOwner allows the member to manage the organization, its teams and all its repositories
+	OrganizationRoleOwner OrganizationRole = "owner"
+
+	// OrganizationRoleMember is a member without any access of its own. Access is granted using teams
+	OrganizationRoleMember OrganizationRole = "member"
+)
+
+// IsValid checks if this is a known role
+func (r OrganizationRole) IsValid() bool {
+	return r == OrganizationRoleOwner || r == OrganizationRoleMember
+}
+
+// Organization groups users and owns repositories. Organizations and users share the same names, so that
+// repositories can be located at "{owner}/{name}" regardless of who owns them
+type Organization struct {
+	// Name is a unique name for the organization
+	Name string
+
+	// Description is a short description of the organization
+	Description string
+
+	// CreatedAt is when the organization was created
+	CreatedAt time.Time
+}
+
+type Organizations struct {
+	Organizations []Organization
+}
+
+// NewOrganization is the body sent when creating a new organization. The user creating the organization
+// becomes its first owner
+type NewOrganization struct {
+	// Name is a unique name for the organization
+	Name string
+
+	// Description is a short description of the organization
+	Description string
+}
+
+// OrganizationMember is a user that's a member of an organization
+type OrganizationMember struct {
+	// User is the name of the user
+	User string
+
+	// Role is the role the user has in the organization
+	Role OrganizationRole
+}
+
+type OrganizationMembers struct {
+	Members []OrganizationMember
+}
+
+// MembershipChange is the body sent when adding a member to an organization, or changing its role
+type MembershipChange struct {
+	// Role is the role the user has in the organization
+	Role OrganizationRole
+}
+
+// Team is a group of members in an organization. Access to the organization's repositories can be granted
+// to a team instead of to each member
+type Team struct {
+	// Organization is the name of the organization the team belongs to
+	Organization string
+
+	// Name is a unique name for the team within the organization
+	Name string
+
+	// Description is a short description of the team
+	Description string
+
+	// Members contains the names of the users in the team
+	Members []string
+
+	// Repositories contains the access level the team has been granted for each repository
+	Repositories map[string]Access
+}
+
+type Teams struct {
+	Teams []Team
+}
+
+// NewTeam is the body sent when creating a new team
+type NewTeam struct {
+	// Name is a unique name for the team within the organization
+	Name string
+
+	// Description is a short description of the team
+	Description string
+}
+
+// TeamAccess is the body sent when granting a team access to a repository
+type TeamAccess struct {
+	// Access is the access level the team is granted. The access is revoked if it's "none"
+	Access Access
+}
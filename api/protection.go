@@ -0,0 +1,30 @@
+package api
+
+// BranchProtection protects all branches matching a pattern
+type BranchProtection struct {
+	// Pattern is a glob pattern matched against the branch name, for example "main" or "release/*"
+	Pattern string
+
+	// AllowForcePushes allows matching branches to be rewritten by a force push
+	AllowForcePushes bool
+
+	// AllowDeletions allows matching branches to be deleted
+	AllowDeletions bool
+
+	// Users contains the users allowed to push to matching branches. All users with write access are
+	// allowed if both Users and Teams are empty
+	Users []string
+
+	// Teams contains the teams, in the organization owning the repository, whose members are allowed to push
+	// to matching branches
+	Teams []string
+}
+
+// RepositoryProtection contains the rules applied to every push to a repository
+type RepositoryProtection struct {
+	// Branches contains the protected branches
+	Branches []BranchProtection
+
+	// ImmutableTags prevents tags from being moved or deleted once they are pushed
+	ImmutableTags bool
+}
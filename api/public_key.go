@@ -10,3 +10,22 @@ type PublicKey struct {
 	// PublicKey is the public key
 	PublicKey string
 }
+
+type PublicKeys struct {
+	PublicKeys []PublicKey
+}
+
+// NewPublicKey is the body sent when registering a new public key
+type NewPublicKey struct {
+	// Name is a unique name of the public key for the user. The comment in the public key is used if empty
+	Name string
+
+	// PublicKey is the public key in the authorized_keys format, for example "ssh-ed25519 AAAA... user@host"
+	PublicKey string
+}
+
+// PublicKeyChanges is the body sent when updating a public key. Only fields that are set are changed
+type PublicKeyChanges struct {
+	// Name is set if the public key should be renamed
+	Name *string
+}
@@ -0,0 +1,14 @@
+package api
+
+// RefUpdate is a reference that is changed by a push. OldObject is all zeros if the reference is created and
+// NewObject is all zeros if the reference is deleted
+type RefUpdate struct {
+	// Ref is the full name of the reference, for example "refs/heads/main"
+	Ref string
+
+	// OldObject is the object the reference pointed to before the push
+	OldObject string
+
+	// NewObject is the object the reference points to after the push
+	NewObject string
+}
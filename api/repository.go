@@ -1,6 +1,55 @@
 package api
 
+import "time"
+
 type Repository struct {
-	// Name is a unique name for this repository
+	// Name is a unique name for this repository in the form "{owner}/{name}", where the owner is a user or an
+	// organization
+	Name string
+
+	// Description is a short description of the repository
+	Description string
+
+	// DefaultBranch is the branch HEAD points to
+	DefaultBranch string
+
+	// Size is the size of the repository on disk in bytes
+	Size int64
+
+	// CreatedAt is when the repository was created
+	CreatedAt time.Time
+
+	// LastPush is when something was last pushed to the repository. Nil if nothing has been pushed yet
+	LastPush *time.Time
+
+	// Deleted is set if the repository is moved to the trash
+	Deleted bool
+}
+
+type Repositories struct {
+	Repositories []Repository
+}
+
+// NewRepository is the body sent when creating a new repository
+type NewRepository struct {
+	// Name is a unique name for the new repository in the form "{owner}/{name}"
 	Name string
+
+	// Description is a short description of the repository
+	Description string
+
+	// DefaultBranch is the branch HEAD points to. The server default is used if empty
+	DefaultBranch string
+}
+
+// RepositoryChanges is the body sent when updating a repository. Only fields that are set are changed
+type RepositoryChanges struct {
+	// Name is set if the repository should be renamed. The repository is transferred if the owner is changed
+	Name *string
+
+	// Description is set if the description should be changed
+	Description *string
+
+	// DefaultBranch is set if HEAD should point to another branch
+	DefaultBranch *string
 }
@@ -0,0 +1,146 @@
+package api
+
+// Permission is a fine-grained action that a role allows
+type Permission string
+
+const (
+	// PermissionNone is used by routes that only require the user to be logged in
+	PermissionNone Permission = ""
+
+	// PermissionRepositoryRead allows reading a repository
+	PermissionRepositoryRead Permission = "repository:read"
+
+	// PermissionRepositoryWrite allows pushing to a repository
+	PermissionRepositoryWrite Permission = "repository:write"
+
+	// PermissionRepositoryAdmin allows changing, deleting and protecting a repository and managing its webhooks
+	PermissionRepositoryAdmin Permission = "repository:admin"
+
+	// PermissionRepositoryCreate allows creating repositories in a namespace
+	PermissionRepositoryCreate Permission = "repository:create"
+
+	// PermissionRepositoriesManage allows managing the webhooks registered for the whole server
+	PermissionRepositoriesManage Permission = "repositories:manage"
+
+	// PermissionOrganizationCreate allows creating organizations
+	PermissionOrganizationCreate Permission = "organization:create"
+
+	// PermissionOrganizationRead allows viewing an organization, its members and its teams
+	PermissionOrganizationRead Permission = "organization:read"
+
+	// PermissionOrganizationManage allows managing an organization, its members and its teams
+	PermissionOrganizationManage Permission = "organization:manage"
+
+	// PermissionAccountRead allows viewing a user account together with its public keys and tokens
+	PermissionAccountRead Permission = "account:read"
+
+	// PermissionAccountWrite allows managing the public keys, tokens and password of a user account
+	PermissionAccountWrite Permission = "account:write"
+
+	// PermissionUsersRead allows listing all users and finding the user that owns a public key
+	PermissionUsersRead Permission = "users:read"
+
+	// PermissionUsersManage allows creating, changing and removing users
+	PermissionUsersManage Permission = "users:manage"
+
+	// PermissionRolesManage allows creating, changing and removing custom roles
+	PermissionRolesManage Permission = "roles:manage"
+
+	// PermissionDatabaseManage allows viewing the history of, and reverting, the data stored by the api server
+	PermissionDatabaseManage Permission = "database:manage"
+
+	// PermissionCertificatesManage allows issuing and revoking certificates using the api server's certificate
+	// authority
+	PermissionCertificatesManage Permission = "certificates:manage"
+
+	// PermissionEventsRead allows reading the event log and waiting for cache invalidations
+	PermissionEventsRead Permission = "events:read"
+
+	// PermissionEventsReport allows reporting events that happened outside of the api server
+	PermissionEventsReport Permission = "events:report"
+
+	// PermissionTokensVerify allows verifying personal access tokens on behalf of users
+	PermissionTokensVerify Permission = "tokens:verify"
+)
+
+// Permissions contains all known permissions
+var Permissions = []Permission{PermissionRepositoryRead, PermissionRepositoryWrite, PermissionRepositoryAdmin,
+	PermissionRepositoryCreate, PermissionRepositoriesManage, PermissionOrganizationCreate,
+	PermissionOrganizationRead, PermissionOrganizationManage, PermissionAccountRead, PermissionAccountWrite,
+	PermissionUsersRead, PermissionUsersManage, PermissionRolesManage, PermissionDatabaseManage,
+	PermissionCertificatesManage, PermissionEventsRead, PermissionEventsReport, PermissionTokensVerify}
+
+// IsValid checks if this is a known permission
+func (p Permission) IsValid() bool {
+	for _, permission := range Permissions {
+		if permission == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Names of the built-in roles
+const (
+	// RoleSiteAdmin is granted to administrators and allows everything on the whole server
+	RoleSiteAdmin = "site-admin"
+
+	// RoleOrgOwner is granted to the owners of an organization, and to each user for their own namespace
+	RoleOrgOwner = "org-owner"
+
+	// RoleOrgMember is granted to the members of an organization
+	RoleOrgMember = "org-member"
+
+	// RoleRepoAdmin is granted to users with admin access to a repository
+	RoleRepoAdmin = "repo-admin"
+
+	// RoleWriter is granted to users with write access to a repository
+	RoleWriter = "writer"
+
+	// RoleReader is granted to users with read access to a repository
+	RoleReader = "reader"
+
+	// RoleService is granted to trusted services, such as the git server
+	RoleService = "service"
+)
+
+// Role is a named set of permissions. Built-in roles are granted through admin flags, memberships and
+// repository access, while custom roles are assigned to users and apply to the whole server
+type Role struct {
+	// Name is a unique name for the role
+	Name string
+
+	// Description is a short description of what the role is used for
+	Description string
+
+	// Permissions contains what the role allows
+	Permissions []Permission
+
+	// BuiltIn is set if the role is defined by the server and can't be changed
+	BuiltIn bool
+}
+
+type Roles struct {
+	Roles []Role
+}
+
+// NewRole is the body sent when creating a new custom role
+type NewRole struct {
+	// Name is a unique name for the role
+	Name string
+
+	// Description is a short description of what the role is used for
+	Description string
+
+	// Permissions contains what the role allows
+	Permissions []Permission
+}
+
+// RoleChanges is the body sent when updating a custom role. Only fields that are set are changed
+type RoleChanges struct {
+	// Description is set if the description should be changed
+	Description *string
+
+	// Permissions is set if the permissions should be replaced
+	Permissions []Permission
+}
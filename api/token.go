@@ -0,0 +1,125 @@
+package api
+
+import "time"
+
+// Scope limits what a personal access token is allowed to do
+type Scope string
+
+const (
+	// ScopeRepoRead allows the token to read repositories the user has access to
+	ScopeRepoRead Scope = "repo:read"
+
+	// ScopeRepoWrite allows the token to write to repositories the user has access to
+	ScopeRepoWrite Scope = "repo:write"
+
+	// ScopeRepoAdmin allows the token to manage repositories the user is an administrator of
+	ScopeRepoAdmin Scope = "repo:admin"
+
+	// ScopeUserWrite allows the token to manage the user's own public keys, tokens and password
+	ScopeUserWrite Scope = "user:write"
+
+	// ScopeOrgAdmin allows the token to create organizations, and to manage organizations the user is an owner of
+	ScopeOrgAdmin Scope = "org:admin"
+
+	// ScopeAdminUsers allows the token to manage all users, if the user is an administrator
+	ScopeAdminUsers Scope = "admin:users"
+
+	// ScopeAdminRepositories allows the token to create and manage all repositories, if the user is an administrator
+	ScopeAdminRepositories Scope = "admin:repos"
+)
+
+// Scopes contains all known scopes
+var Scopes = []Scope{ScopeRepoRead, ScopeRepoWrite, ScopeRepoAdmin, ScopeUserWrite, ScopeOrgAdmin,
+	ScopeAdminUsers, ScopeAdminRepositories}
+
+// IsValid checks if this is a known scope
+func (s Scope) IsValid() bool {
+	for _, scope := range Scopes {
+		if scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxRepositoryAccess returns the highest repository access level the supplied scopes allow
+func MaxRepositoryAccess(scopes []Scope) Access {
+	access := AccessNone
+	for _, scope := range scopes {
+		switch {
+		case scope == ScopeRepoAdmin:
+			return AccessAdmin
+		case scope == ScopeRepoWrite:
+			access = AccessWrite
+		case scope == ScopeRepoRead && access == AccessNone:
+			access = AccessRead
+		}
+	}
+	return access
+}
+
+// Token is a personal access token. The secret is never part of the token
+type Token struct {
+	// ID is a unique identifier for the token
+	ID string
+
+	// Name is a description of what the token is used for
+	Name string
+
+	// User is the name of the user that owns the token
+	User string
+
+	// Scopes is what the token is allowed to do
+	Scopes []Scope
+
+	// CreatedAt is when the token was created
+	CreatedAt time.Time
+
+	// ExpiresAt is when the token stops working
+	ExpiresAt time.Time
+
+	// LastUsedAt is when the token was last used. Nil if it has never been used
+	LastUsedAt *time.Time
+}
+
+type Tokens struct {
+	Tokens []Token
+}
+
+// NewToken is the body sent when creating a personal access token
+type NewToken struct {
+	// Name is a description of what the token is used for
+	Name string
+
+	// Scopes is what the token is allowed to do
+	Scopes []Scope
+
+	// ExpiresAt is when the token stops working. The server default is used if nil
+	ExpiresAt *time.Time
+}
+
+// CreatedToken is the response sent when a token is created. This is the only time the secret is available
+type CreatedToken struct {
+	Token
+
+	// Secret is sent in the "Authorization: Bearer" header when calling the API
+	Secret string
+}
+
+// TokenVerification is the body sent when verifying that a secret belongs to a specific user
+type TokenVerification struct {
+	// User is the name of the user that claims to own the token
+	User string
+
+	// Secret is the token's secret
+	Secret string
+}
+
+// VerifiedToken is the response sent when a token is verified
+type VerifiedToken struct {
+	// User is the user that owns the token
+	User User
+
+	// Scopes is what the token is allowed to do
+	Scopes []Scope
+}
@@ -4,9 +4,58 @@ type User struct {
 	// Name represents a unique name for a user
 	Name string
 
+	// PublicKeys is all public keys for this user
+	PublicKeys []PublicKey
+
+	// Admin is set if the user has full access to the server
+	Admin bool
+
+	// Repositories contains the access level this user has been granted for each repository
+	Repositories map[string]Access
+
+	// Teams contains the teams the user is a member of, in the form "{organization}/{team}"
+	Teams []string
+
+	// Roles contains the roles assigned to the user on the whole server
+	Roles []string
+}
+
+// NewUser is the body sent when creating a new user
+type NewUser struct {
+	// Name represents a unique name for a user
+	Name string
+
 	// Password is the user's password
 	Password string
 
-	// PublicKeys is all public keys for this user
-	PublicKeys []PublicKey
+	// Admin is set if the user has full access to the server
+	Admin bool
+
+	// Repositories contains the access level this user has been granted for each repository
+	Repositories map[string]Access
+
+	// Roles contains the roles assigned to the user on the whole server
+	Roles []string
+}
+
+// UserChanges is the body sent when updating a user. Only fields that are set are changed
+type UserChanges struct {
+	// Admin is set if the user should be granted, or revoked, full access to the server
+	Admin *bool
+
+	// Repositories is set if the repository access should be replaced
+	Repositories map[string]Access
+
+	// Roles is set if the roles assigned to the user should be replaced
+	Roles []string
+}
+
+// PasswordChange is the body sent when changing a user's password
+type PasswordChange struct {
+	// CurrentPassword is the user's current password. Administrators changing another user's password
+	// are allowed to leave this empty
+	CurrentPassword string
+
+	// NewPassword is the new password
+	NewPassword string
 }
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebhookEvent is the kind of event a webhook is told about
+type WebhookEvent string
+
+const (
+	WebhookEventPush              WebhookEvent = "push"
+	WebhookEventFetch             WebhookEvent = "fetch"
+	WebhookEventRepositoryCreated WebhookEvent = "repository_created"
+	WebhookEventRepositoryDeleted WebhookEvent = "repository_deleted"
+	WebhookEventUserAdded         WebhookEvent = "user_added"
+	WebhookEventUserRemoved       WebhookEvent = "user_removed"
+	WebhookEventUserChanged       WebhookEvent = "user_changed"
+	WebhookEventPublicKeyAdded    WebhookEvent = "public_key_added"
+	WebhookEventPublicKeyRemoved  WebhookEvent = "public_key_removed"
+
+	// WebhookEventPing is sent when a webhook is created, so that the receiver can verify the secret
+	WebhookEventPing WebhookEvent = "ping"
+)
+
+// WebhookEvents contains all events a webhook can subscribe to
+var WebhookEvents = []WebhookEvent{WebhookEventPush, WebhookEventFetch, WebhookEventRepositoryCreated,
+	WebhookEventRepositoryDeleted, WebhookEventUserAdded, WebhookEventUserRemoved, WebhookEventUserChanged,
+	WebhookEventPublicKeyAdded, WebhookEventPublicKeyRemoved}
+
+// IsValid checks if this is an event that webhooks can subscribe to
+func (e WebhookEvent) IsValid() bool {
+	for _, event := range WebhookEvents {
+		if event == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook is an http endpoint that's told about events. The secret is never part of the webhook
+type Webhook struct {
+	// ID is a unique identifier for the webhook
+	ID string
+
+	// Repository is the repository the webhook is registered for. Empty if it's registered for the whole server
+	Repository string
+
+	// URL is where the events are sent
+	URL string
+
+	// Events contains the events the webhook is told about. All events are sent if empty
+	Events []WebhookEvent
+
+	// CreatedAt is when the webhook was created
+	CreatedAt time.Time
+}
+
+type Webhooks struct {
+	Webhooks []Webhook
+}
+
+// NewWebhook is the body sent when registering a webhook
+type NewWebhook struct {
+	// URL is where the events are sent. Must be a http or https url
+	URL string
+
+	// Secret is used when signing the payloads. A random secret is generated if empty
+	Secret string
+
+	// Events contains the events the webhook is told about. All events are sent if empty
+	Events []WebhookEvent
+}
+
+// CreatedWebhook is the response sent when a webhook is created. This is the only time the secret is available
+type CreatedWebhook struct {
+	Webhook
+
+	// Secret is used when signing the payloads
+	Secret string
+}
+
+// WebhookPayload is the json body sent to a webhook. The body is signed using HMAC-SHA256 with the webhook's
+// secret, and the signature is sent in the "X-GitGo-Signature-256" header as "sha256=<hex>"
+type WebhookPayload struct {
+	// Event is the kind of event
+	Event WebhookEvent
+
+	// CreatedAt is when the event happened
+	CreatedAt time.Time
+
+	// Repository is the repository the event is about, if any
+	Repository string
+
+	// User is the user the event is about, or the user that pushed or fetched
+	User string
+
+	// Protocol is the protocol used when pushing or fetching
+	Protocol string
+
+	// RefUpdates contains the references changed by a push
+	RefUpdates []RefUpdate
+
+	// Fingerprint is the fingerprint of the public key the event is about
+	Fingerprint string
+}
+
+// DeliveryStatus is the state of a webhook delivery
+type DeliveryStatus string
+
+const (
+	// DeliveryPending is used while the delivery is waiting to be sent, or retried
+	DeliveryPending DeliveryStatus = "pending"
+
+	// DeliverySucceeded is used when the webhook responded with a 2xx status code
+	DeliverySucceeded DeliveryStatus = "succeeded"
+
+	// DeliveryFailed is used when all attempts have failed
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is an attempt to send an event to a webhook
+type WebhookDelivery struct {
+	// ID is a unique identifier for the delivery. It's sent in the "X-GitGo-Delivery" header
+	ID string
+
+	// Webhook is the identifier of the webhook the delivery is sent to
+	Webhook string
+
+	// Event is the kind of event
+	Event WebhookEvent
+
+	// Payload is the json body sent to the webhook
+	Payload json.RawMessage
+
+	// Status is the state of the delivery
+	Status DeliveryStatus
+
+	// Attempts is how many times the delivery has been sent
+	Attempts int
+
+	// StatusCode is the http status code from the latest attempt. Zero if no response was received
+	StatusCode int
+
+	// Error describes why the latest attempt failed
+	Error string
+
+	// CreatedAt is when the delivery was created
+	CreatedAt time.Time
+
+	// LastAttemptAt is when the delivery was last sent. Nil if it has never been sent
+	LastAttemptAt *time.Time
+
+	// NextAttemptAt is when the delivery is sent again. Nil if it's not pending
+	NextAttemptAt *time.Time
+}
+
+type WebhookDeliveries struct {
+	Deliveries []WebhookDelivery
+}
@@ -0,0 +1,492 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenTimeout is how long to wait for another process, such as a running api server, to close the database
+const OpenTimeout = time.Second
+
+var (
+	InvalidJsonError        = errors.New("file does not contain valid json")
+	CollectionNotFoundError = errors.New("path is not a collection")
+)
+
+var (
+	contentBucket  = []byte("content")
+	versionsBucket = []byte("versions")
+)
+
+type index struct {
+	name    string
+	indexer db.Indexer
+}
+
+// change is a value that's written when a transaction is stored
+type change struct {
+	path    string
+	data    []byte
+	version string
+	stored  func(version string)
+}
+
+// BoltContentDatabase stores the data in an embedded key-value store. Each entity of a collection is stored with
+// its own key, and the secondary indexes are updated in the same transaction as the entities, so they can never
+// be out of date. No history is kept, so the author and message of each change are ignored
+type BoltContentDatabase struct {
+	db *bolt.DB
+
+	// collections contains the paths where each entity is stored separately
+	collections map[string]db.Collection
+
+	// indexes contains the registered indexes for each collection
+	indexes map[string][]index
+	mutex   sync.RWMutex
+}
+
+func (d *BoltContentDatabase) Read(path string, i interface{}) error {
+	_, err := d.ReadVersion(path, i)
+	return err
+}
+
+func (d *BoltContentDatabase) Write(path string, i interface{}, author string, message string) error {
+	_, err := d.WriteVersion(path, i, db.AnyVersion, author, message)
+	return err
+}
+
+func (d *BoltContentDatabase) ReadVersion(path string, i interface{}) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	version := db.MissingVersion
+	err := d.db.View(func(tx *bolt.Tx) error {
+		var data []byte
+		var err error
+		if data, version, err = d.get(tx, path); err != nil {
+			return err
+		}
+		return json.Unmarshal(data, i)
+	})
+	return version, err
+}
+
+func (d *BoltContentDatabase) WriteVersion(path string, i interface{}, version string, author string,
+	message string) (string, error) {
+	var newVersion string
+	err := d.Update(author, message, func(tx db.Transaction) error {
+		return tx.WriteVersion(path, i, version, func(version string) {
+			newVersion = version
+		})
+	})
+	return newVersion, err
+}
+
+func (d *BoltContentDatabase) Update(author string, message string, fn func(tx db.Transaction) error) error {
+	tx := &transaction{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return d.store(tx.changes)
+}
+
+func (d *BoltContentDatabase) AddCollection(path string, collection db.Collection) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.collections[path] = collection
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(contentBucket).Get([]byte(path))
+		if data == nil {
+			return nil
+		}
+		log.Printf("INFO: Storing each entity in %s separately\n", path)
+		return d.putCollection(tx, path, collection, data)
+	})
+	if err != nil {
+		delete(d.collections, path)
+		return fmt.Errorf("could not split %s: %w", path, err)
+	}
+	return nil
+}
+
+func (d *BoltContentDatabase) AddIndex(name string, path string, indexer db.Indexer) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.collections[path]; !ok {
+		return fmt.Errorf("could not add index %s to %s: %w", name, path, CollectionNotFoundError)
+	}
+	i := index{name: name, indexer: indexer}
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		return buildIndex(tx, i, tx.Bucket(collectionBucket(path)))
+	})
+	if err != nil {
+		return err
+	}
+	d.indexes[path] = append(d.indexes[path], i)
+	return nil
+}
+
+func (d *BoltContentDatabase) Lookup(name string, key string) ([]string, error) {
+	var result []string
+	err := d.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(indexBucket(name))
+		if bucket == nil || len(key) == 0 {
+			return db.IndexEntryNotFoundError
+		}
+		entries := bucket.Bucket([]byte(key))
+		if entries == nil {
+			return db.IndexEntryNotFoundError
+		}
+		return entries.ForEach(func(k, _ []byte) error {
+			result = append(result, string(k))
+			return nil
+		})
+	})
+	return result, err
+}
+
+// Import stores all json files in the supplied directory, such as the files written by the json database, in
+// one transaction. Files that already exist in the database are replaced. The imported paths are returned
+func (d *BoltContentDatabase) Import(rootPath string, author string) ([]string, error) {
+	var paths []string
+	files := map[string][]byte{}
+	err := filepath.Walk(rootPath, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") && filename != rootPath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") || filepath.Ext(filename) != ".json" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		buffer := &bytes.Buffer{}
+		if err = json.Compact(buffer, data); err != nil {
+			return fmt.Errorf("%s: %w", filename, InvalidJsonError)
+		}
+		rel, err := filepath.Rel(rootPath, filename)
+		if err != nil {
+			return err
+		}
+		path := "/" + filepath.ToSlash(rel)
+		paths = append(paths, path)
+		files[path] = buffer.Bytes()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]change, len(paths))
+	for i, path := range paths {
+		log.Printf("INFO: Importing %s\n", path)
+		changes[i] = change{path: path, data: files[path], version: db.AnyVersion}
+	}
+	if err = d.store(changes); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// Close the database, so that it can be opened by another process
+func (d *BoltContentDatabase) Close() error {
+	return d.db.Close()
+}
+
+// store writes all changes in one transaction, and then tells each change its new version
+func (d *BoltContentDatabase) store(changes []change) error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	versions := make([]string, len(changes))
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		for i, c := range changes {
+			if c.version != db.AnyVersion {
+				current, err := d.version(tx, c.path)
+				if err != nil {
+					return err
+				}
+				if current != c.version {
+					return db.VersionConflictError
+				}
+			}
+			if err := d.put(tx, c.path, c.data); err != nil {
+				return err
+			}
+			versions[i] = db.Version(c.data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, c := range changes {
+		if c.stored != nil {
+			c.stored(versions[i])
+		}
+	}
+	return nil
+}
+
+// get reads the data stored in a path, together with its version. The mutex must be locked by the caller
+func (d *BoltContentDatabase) get(tx *bolt.Tx, path string) ([]byte, string, error) {
+	collection, ok := d.collections[path]
+	if !ok {
+		data := tx.Bucket(contentBucket).Get([]byte(path))
+		if data == nil {
+			return nil, db.MissingVersion, os.ErrNotExist
+		}
+		return data, db.Version(data), nil
+	}
+
+	bucket := tx.Bucket(collectionBucket(path))
+	if bucket == nil {
+		return nil, db.MissingVersion, os.ErrNotExist
+	}
+	var entities [][]byte
+	_ = bucket.ForEach(func(_, v []byte) error {
+		entities = append(entities, v)
+		return nil
+	})
+	data, err := collection.Join(entities)
+	if err != nil {
+		return nil, db.MissingVersion, err
+	}
+	// The entities are joined in a different order than they were written, so the version of the written document
+	// is kept
+	if version := tx.Bucket(versionsBucket).Get([]byte(path)); version != nil {
+		return data, string(version), nil
+	}
+	return data, db.Version(data), nil
+}
+
+// version returns the version of the data stored in a path. The mutex must be locked by the caller
+func (d *BoltContentDatabase) version(tx *bolt.Tx, path string) (string, error) {
+	if _, ok := d.collections[path]; ok && tx.Bucket(collectionBucket(path)) != nil {
+		if version := tx.Bucket(versionsBucket).Get([]byte(path)); version != nil {
+			return string(version), nil
+		}
+	}
+	_, version, err := d.get(tx, path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return version, nil
+}
+
+// put stores the supplied data. The mutex must be locked by the caller
+func (d *BoltContentDatabase) put(tx *bolt.Tx, path string, data []byte) error {
+	if collection, ok := d.collections[path]; ok {
+		return d.putCollection(tx, path, collection, data)
+	}
+	return tx.Bucket(contentBucket).Put([]byte(path), data)
+}
+
+// putCollection stores the entities that are added, changed or removed in the supplied document, and updates
+// their index entries. The mutex must be locked by the caller
+func (d *BoltContentDatabase) putCollection(tx *bolt.Tx, path string, collection db.Collection, data []byte) error {
+	entities, err := collection.Split(data)
+	if err != nil {
+		return err
+	}
+	bucket, err := tx.CreateBucketIfNotExists(collectionBucket(path))
+	if err != nil {
+		return err
+	}
+
+	var removed [][]byte
+	_ = bucket.ForEach(func(k, _ []byte) error {
+		if _, ok := entities[string(k)]; !ok {
+			removed = append(removed, append([]byte{}, k...))
+		}
+		return nil
+	})
+	for _, key := range removed {
+		if err = d.reindex(tx, path, key, bucket.Get(key), nil); err != nil {
+			return err
+		}
+		if err = bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	for key, entity := range entities {
+		existing := bucket.Get([]byte(key))
+		if bytes.Equal(existing, entity) {
+			continue
+		}
+		if err = d.reindex(tx, path, []byte(key), existing, entity); err != nil {
+			return err
+		}
+		if err = bucket.Put([]byte(key), entity); err != nil {
+			return err
+		}
+	}
+
+	// The document is only stored as entities, but the version of it is kept
+	if err = tx.Bucket(contentBucket).Delete([]byte(path)); err != nil {
+		return err
+	}
+	return tx.Bucket(versionsBucket).Put([]byte(path), []byte(db.Version(data)))
+}
+
+// reindex moves the index entries of an entity from the keys of its previous data to the keys of its new data.
+// The data is nil if the entity doesn't exist. The mutex must be locked by the caller
+func (d *BoltContentDatabase) reindex(tx *bolt.Tx, path string, key []byte, previous []byte, data []byte) error {
+	for _, i := range d.indexes[path] {
+		previousKeys, err := indexKeys(i, previous)
+		if err != nil {
+			return err
+		}
+		keys, err := indexKeys(i, data)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket(indexBucket(i.name))
+		for k := range previousKeys {
+			if keys[k] {
+				continue
+			}
+			if entries := bucket.Bucket([]byte(k)); entries != nil {
+				if err = entries.Delete(key); err != nil {
+					return err
+				}
+				if first, _ := entries.Cursor().First(); first == nil {
+					if err = bucket.DeleteBucket([]byte(k)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		for k := range keys {
+			if !previousKeys[k] {
+				if err = addIndexEntry(bucket, k, key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// buildIndex replaces all entries in an index with the entries of the entities in the supplied bucket
+func buildIndex(tx *bolt.Tx, i index, entities *bolt.Bucket) error {
+	name := indexBucket(i.name)
+	if tx.Bucket(name) != nil {
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	bucket, err := tx.CreateBucket(name)
+	if err != nil || entities == nil {
+		return err
+	}
+	return entities.ForEach(func(key, entity []byte) error {
+		keys, err := indexKeys(i, entity)
+		if err != nil {
+			return err
+		}
+		for k := range keys {
+			if err = addIndexEntry(bucket, k, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// indexKeys returns the keys that the supplied entity is indexed for. An entity without data has no keys
+func indexKeys(i index, entity []byte) (map[string]bool, error) {
+	result := map[string]bool{}
+	if entity == nil {
+		return result, nil
+	}
+	keys, err := i.indexer(entity)
+	if err != nil {
+		return nil, fmt.Errorf("could not update index %s: %w", i.name, err)
+	}
+	for _, k := range keys {
+		if len(k) > 0 {
+			result[k] = true
+		}
+	}
+	return result, nil
+}
+
+func addIndexEntry(bucket *bolt.Bucket, key string, entity []byte) error {
+	entries, err := bucket.CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return err
+	}
+	return entries.Put(entity, []byte{})
+}
+
+func indexBucket(name string) []byte {
+	return []byte("index/" + name)
+}
+
+func collectionBucket(path string) []byte {
+	return []byte("collection" + path)
+}
+
+// transaction collects the changes that are stored by Update
+type transaction struct {
+	changes []change
+}
+
+func (t *transaction) WriteVersion(path string, i interface{}, version string, stored func(version string)) error {
+	data, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	t.changes = append(t.changes, change{path: path, data: data, version: version, stored: stored})
+	return nil
+}
+
+// New opens, or creates, a database in the supplied file
+func New(filename string) (*BoltContentDatabase, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	store, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: OpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", filename, err)
+	}
+	err = store.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{contentBucket, versionsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return &BoltContentDatabase{
+		db:          store,
+		collections: map[string]db.Collection{},
+		indexes:     map[string][]index{},
+	}, nil
+}
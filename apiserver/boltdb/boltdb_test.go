@@ -0,0 +1,172 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testEntity struct {
+	Name string
+	Keys []string
+}
+
+type testDocument struct {
+	Entities []testEntity
+}
+
+func indexKeysOf(entity []byte) ([]string, error) {
+	var e testEntity
+	if err := json.Unmarshal(entity, &e); err != nil {
+		return nil, err
+	}
+	return e.Keys, nil
+}
+
+// newDatabase opens a database in a temporary directory
+func newDatabase(t *testing.T) *BoltContentDatabase {
+	dir, err := ioutil.TempDir("", "gitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	database, err := New(filepath.Join(dir, "gitgo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+	return database
+}
+
+// lookup returns the entities indexed for the supplied key joined by a comma, or an empty string if there are none
+func lookup(t *testing.T, database *BoltContentDatabase, key string) string {
+	result, err := database.Lookup("keys", key)
+	if errors.Is(err, db.IndexEntryNotFoundError) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(result, ",")
+}
+
+func TestIndex(t *testing.T) {
+	database := newDatabase(t)
+	tests := []struct {
+		name   string
+		key    string
+		before string
+		after  string
+	}{
+		{"removed key", "a", "per", ""},
+		{"removed entity", "b", "bob,per", "per"},
+		{"added key and entity", "c", "", "eve,per"},
+		{"empty key", "", "", ""},
+	}
+
+	// The index is built from the entities that are already stored
+	err := database.Write("/entities.json", &testDocument{Entities: []testEntity{
+		{Name: "per", Keys: []string{"a", "b"}},
+		{Name: "bob", Keys: []string{"b"}},
+	}}, "per", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = database.AddCollection("/entities.json", db.Collection{Field: "Entities", Key: "Name"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = database.AddIndex("keys", "/entities.json", indexKeysOf); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		if actual := lookup(t, database, test.key); actual != test.before {
+			t.Errorf("%s: expected %q before the change but was %q", test.name, test.before, actual)
+		}
+	}
+
+	// Entities that are changed or removed are moved to, or removed from, their new keys
+	err = database.Write("/entities.json", &testDocument{Entities: []testEntity{
+		{Name: "per", Keys: []string{"b", "c"}},
+		{Name: "eve", Keys: []string{"c"}},
+	}}, "per", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := lookup(t, database, test.key); actual != test.after {
+				t.Errorf("expected %q but was %q", test.after, actual)
+			}
+		})
+	}
+}
+
+func TestIndexNotChangedByFailedWrite(t *testing.T) {
+	database := newDatabase(t)
+	if err := database.AddCollection("/entities.json", db.Collection{Field: "Entities", Key: "Name"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.AddIndex("keys", "/entities.json", indexKeysOf); err != nil {
+		t.Fatal(err)
+	}
+	document := &testDocument{Entities: []testEntity{{Name: "per", Keys: []string{"a"}}}}
+	version, err := database.WriteVersion("/entities.json", document, db.AnyVersion, "per", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both documents are stored in the same transaction, so the index is not changed if one of them can't be stored
+	err = database.Update("per", "", func(tx db.Transaction) error {
+		document.Entities[0].Keys = []string{"b"}
+		if err := tx.WriteVersion("/entities.json", document, version, nil); err != nil {
+			return err
+		}
+		return tx.WriteVersion("/other.json", document, "wrong version", nil)
+	})
+	if !errors.Is(err, db.VersionConflictError) {
+		t.Fatalf("expected %v but was %v", db.VersionConflictError, err)
+	}
+	if actual := lookup(t, database, "a"); actual != "per" {
+		t.Errorf("expected per but was %q", actual)
+	}
+	if actual := lookup(t, database, "b"); actual != "" {
+		t.Errorf("expected nothing but was %q", actual)
+	}
+}
+
+func TestCollectionKeepsVersion(t *testing.T) {
+	database := newDatabase(t)
+	if err := database.AddCollection("/entities.json", db.Collection{Field: "Entities", Key: "Name"}); err != nil {
+		t.Fatal(err)
+	}
+	document := &testDocument{Entities: []testEntity{{Name: "per"}, {Name: "bob"}, {Name: "eve"}}}
+	version, err := database.WriteVersion("/entities.json", document, db.AnyVersion, "per", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual testDocument
+	actualVersion, err := database.ReadVersion("/entities.json", &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actualVersion != version {
+		t.Errorf("expected version %s but was %s", version, actualVersion)
+	}
+	if len(actual.Entities) != len(document.Entities) {
+		t.Errorf("expected %d entities but was %d", len(document.Entities), len(actual.Entities))
+	}
+}
+
+func TestAddIndexWithoutCollection(t *testing.T) {
+	database := newDatabase(t)
+	if err := database.AddIndex("keys", "/entities.json", indexKeysOf); !errors.Is(err, CollectionNotFoundError) {
+		t.Errorf("expected %v but was %v", CollectionNotFoundError, err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"github.com/westcoastcode-se/gitgo/apiserver/pki"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GitServerService is the name of the service the git server authenticates as
+const GitServerService = "gitserver"
+
+// openCertificates loads the certificate authority, or creates it if it doesn't exist. The api server's own
+// certificate and the git server's client-side certificate are issued if they are missing, so that a new
+// installation can be started without creating any certificates by hand
+func openCertificates(cfg server.Config, database db.ContentDatabase, processor *event.Processor) (pki.Database,
+	error) {
+	authority, err := pki.LoadAuthority(cfg.CAPath, cfg.CAKeyPath)
+	if os.IsNotExist(err) && !exists(cfg.CAKeyPath) {
+		log.Printf("INFO: Creating a certificate authority in %s\n", cfg.CAPath)
+		authority, err = pki.CreateAuthority(cfg.CAPath, cfg.CAKeyPath, "GitGo Root CA", cfg.CAValidity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	certificates, err := pki.New(database, authority, cfg.CRLPath, cfg.CertificateValidity, processor)
+	if err != nil {
+		return nil, err
+	}
+	if !authority.CanSign() {
+		return certificates, nil
+	}
+
+	err = issueMissing(certificates, cfg.CertPath, cfg.PrivateKey, &api.NewCertificate{
+		CommonName: "apiserver",
+		Type:       api.CertificateTypeServer,
+		DNSNames:   cfg.ServerNames,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if commonName := serviceCommonName(cfg, GitServerService); len(commonName) > 0 {
+		err = issueMissing(certificates, cfg.ServiceCertPath, cfg.ServiceKeyPath, &api.NewCertificate{
+			CommonName: commonName,
+			Type:       api.CertificateTypeClient,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return certificates, nil
+}
+
+// issueMissing issues a certificate and writes it to the supplied paths, unless the certificate already exists
+func issueMissing(certificates pki.Database, certPath string, keyPath string, request *api.NewCertificate) error {
+	if exists(certPath) {
+		return nil
+	}
+	log.Printf("INFO: Issuing a %s certificate for %s in %s\n", request.Type, request.CommonName, certPath)
+	_, keyPair, err := certificates.Issue(db.SystemAuthor, request)
+	if err != nil {
+		return err
+	}
+	return keyPair.WriteFiles(certPath, keyPath)
+}
+
+// serviceCommonName returns the common name mapped to the supplied service. The first common name, in
+// alphabetical order, is used if more than one is mapped to the service
+func serviceCommonName(cfg server.Config, service string) string {
+	var names []string
+	for commonName, principal := range cfg.Principals {
+		if principal.Service == service {
+			names = append(names, commonName)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// runPKI manages the certificate authority from the command line. The database is used directly, so the api
+// server should be stopped if the database type is bolt
+//
+//	apiserver pki init
+//	apiserver pki list
+//	apiserver pki issue -type client -cn per -out data/per
+//	apiserver pki revoke {serial}
+func runPKI(cfg server.Config, args []string) int {
+	if len(args) == 0 {
+		log.Println("ERROR: Expected one of the commands init, list, issue or revoke")
+		return 2
+	}
+
+	database, err := openDatabase(cfg, nil)
+	if err != nil {
+		log.Printf("ERROR: Could not open the database: %v\n", err)
+		return 1
+	}
+	certificates, err := openCertificates(cfg, database, nil)
+	if err != nil {
+		log.Printf("ERROR: Could not load the certificate authority: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "init":
+		return 0
+	case "list":
+		for _, c := range certificates.GetCertificates() {
+			status := "valid"
+			if c.IsRevoked() {
+				status = "revoked"
+			} else if time.Now().After(c.NotAfter) {
+				status = "expired"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", c.Serial, c.Type, c.CommonName, c.NotAfter.Format(time.RFC3339),
+				status)
+		}
+		return 0
+	case "issue":
+		return issueCertificate(certificates, args[1:])
+	case "revoke":
+		if len(args) != 2 {
+			log.Println("ERROR: Expected the serial number of the certificate to revoke")
+			return 2
+		}
+		if err = certificates.Revoke(db.SystemAuthor, args[1]); err != nil {
+			log.Printf("ERROR: Could not revoke %s: %v\n", args[1], err)
+			return 1
+		}
+		log.Printf("INFO: Revoked %s. The revocation list is written to %s\n", args[1], cfg.CRLPath)
+		return 0
+	}
+	log.Printf("ERROR: Unknown command %s\n", args[0])
+	return 2
+}
+
+// issueCertificate issues a certificate and writes it, together with its private key, to {out}.crt and {out}.key
+func issueCertificate(certificates pki.Database, args []string) int {
+	flags := flag.NewFlagSet("issue", flag.ContinueOnError)
+	certificateType := flags.String("type", string(api.CertificateTypeClient), "either server or client")
+	commonName := flags.String("cn", "", "the common name of the certificate")
+	dnsNames := flags.String("dns", "", "comma separated host names of a server certificate")
+	ipAddresses := flags.String("ip", "", "comma separated ip addresses of a server certificate")
+	days := flags.Int("days", 0, "how many days the certificate is valid. The server default is used if 0")
+	out := flags.String("out", "", "where the certificate and the private key are written, without extension")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if len(*out) == 0 {
+		*out = *commonName
+	}
+
+	request := &api.NewCertificate{
+		CommonName:  *commonName,
+		Type:        api.CertificateType(*certificateType),
+		DNSNames:    split(*dnsNames),
+		IPAddresses: split(*ipAddresses),
+	}
+	if *days > 0 {
+		notAfter := time.Now().Add(time.Duration(*days) * 24 * time.Hour)
+		request.NotAfter = &notAfter
+	}
+	c, keyPair, err := certificates.Issue(db.SystemAuthor, request)
+	if err != nil {
+		log.Printf("ERROR: Could not issue the certificate: %v\n", err)
+		return 1
+	}
+	if err = keyPair.WriteFiles(*out+".crt", *out+".key"); err != nil {
+		log.Printf("ERROR: Could not write the certificate: %v\n", err)
+		return 1
+	}
+	log.Printf("INFO: Issued %s with serial number %s\n", *out+".crt", c.Serial)
+	return 0
+}
+
+// split a comma separated list, ignoring empty values
+func split(value string) []string {
+	var result []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); len(part) > 0 {
+			result = append(result, part)
+		}
+	}
+	return result
+}
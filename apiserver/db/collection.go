@@ -0,0 +1,71 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// InvalidCollectionError is returned when a document can't be split into the entities of a collection
+var InvalidCollectionError = errors.New("document is not a valid collection")
+
+// Collection describes a document that contains a list of entities, such as {"Users":[...]}, so that each entity
+// can be stored separately
+type Collection struct {
+	// Field is the name of the list in the document
+	Field string
+
+	// Key is the name of the field that identifies each entity, such as "Name"
+	Key string
+}
+
+// Split returns each entity in the supplied document by its key
+func (c Collection) Split(data []byte) (map[string][]byte, error) {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	for field := range document {
+		if field != c.Field {
+			return nil, fmt.Errorf("unknown field %s: %w", field, InvalidCollectionError)
+		}
+	}
+
+	var entities []json.RawMessage
+	if raw, ok := document[c.Field]; ok {
+		if err := json.Unmarshal(raw, &entities); err != nil {
+			return nil, err
+		}
+	}
+
+	result := map[string][]byte{}
+	for _, entity := range entities {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(entity, &fields); err != nil || fields == nil {
+			return nil, fmt.Errorf("%s contains an entity that's not an object: %w", c.Field, InvalidCollectionError)
+		}
+		var key string
+		if err := json.Unmarshal(fields[c.Key], &key); err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("%s contains an entity without %s: %w", c.Field, c.Key, InvalidCollectionError)
+		}
+		if _, ok := result[key]; ok {
+			return nil, fmt.Errorf("%s contains %s more than once: %w", c.Field, key, InvalidCollectionError)
+		}
+		buffer := &bytes.Buffer{}
+		if err := json.Compact(buffer, entity); err != nil {
+			return nil, err
+		}
+		result[key] = buffer.Bytes()
+	}
+	return result, nil
+}
+
+// Join builds a document from the supplied entities
+func (c Collection) Join(entities [][]byte) ([]byte, error) {
+	list := make([]json.RawMessage, len(entities))
+	for i, entity := range entities {
+		list[i] = entity
+	}
+	return json.Marshal(map[string][]json.RawMessage{c.Field: list})
+}
@@ -1,6 +1,105 @@
 package db
 
+import (
+	"errors"
+	"time"
+)
+
+// IndexEntryNotFoundError is returned when a key doesn't exist in an index
+var IndexEntryNotFoundError = errors.New("index entry not found")
+
+// SystemAuthor is the author of changes made by the server itself, such as migrations
+const SystemAuthor = "gitgo"
+
 type ContentDatabase interface {
 	Read(path string, i interface{}) error
-	Write(path string, i interface{}, message string) error
+
+	// Write stores the supplied value. The author is the name of the user making the change, and the message
+	// describes the change
+	Write(path string, i interface{}, author string, message string) error
+
+	// ReadVersion reads the supplied value in the same way as Read, and returns its version. MissingVersion is
+	// returned if the value doesn't exist
+	ReadVersion(path string, i interface{}) (string, error)
+
+	// WriteVersion stores the supplied value in the same way as Write, but only if the stored version is the
+	// supplied version. VersionConflictError is returned if it has been changed by someone else. The new version
+	// is returned
+	WriteVersion(path string, i interface{}, version string, author string, message string) (string, error)
+}
+
+// Revision is a change stored in a versioned database
+type Revision struct {
+	ID        string
+	Author    string
+	Message   string
+	CreatedAt time.Time
+}
+
+// VersionedDatabase is a content database that keeps the history of every change
+type VersionedDatabase interface {
+	ContentDatabase
+
+	// History returns at most limit revisions of the supplied path, newest first
+	History(path string, limit int) ([]Revision, error)
+
+	// Revert changes the content of the supplied path back to what it was in a revision. The revert is
+	// recorded as a new revision, and EventDataChanged is raised so that the data is reloaded
+	Revert(path string, revision string, author string) error
+}
+
+// WatchedDatabase is a content database that detects changes made outside of the api server
+type WatchedDatabase interface {
+	ContentDatabase
+
+	// Watch checks for changes with the supplied interval, and raises EventDataChanged for each changed path,
+	// until the supplied channel is closed
+	Watch(interval time.Duration, stop <-chan struct{})
+}
+
+// Indexer returns the keys that an entity in a collection is indexed for, such as the fingerprints of a user's
+// public keys
+type Indexer func(entity []byte) ([]string, error)
+
+// IndexedDatabase is a content database that stores each entity of a collection separately and keeps secondary
+// indexes of them. Only the entities that are changed, and their index entries, are written when a collection is
+// stored
+type IndexedDatabase interface {
+	ContentDatabase
+
+	// AddCollection stores each entity of the document in the supplied path separately. A document that's already
+	// stored is split into its entities
+	AddCollection(path string, collection Collection) error
+
+	// AddIndex registers an index of the entities in the collection stored in the supplied path. The index is built
+	// from the entities that are already stored
+	AddIndex(name string, path string, indexer Indexer) error
+
+	// Lookup returns the keys of the entities that are indexed for the supplied key, sorted. IndexEntryNotFoundError
+	// is returned if no entity is indexed for the key
+	Lookup(name string, key string) ([]string, error)
+}
+
+// AddCollection stores each entity of the document in the supplied path separately, if the database supports it
+func AddCollection(database ContentDatabase, path string, collection Collection) error {
+	if indexes, ok := database.(IndexedDatabase); ok {
+		return indexes.AddCollection(path, collection)
+	}
+	return nil
+}
+
+// Transaction collects changes that are stored together
+type Transaction interface {
+	// WriteVersion stores the supplied value if the stored version is the supplied version. The supplied function,
+	// if any, is called with the new version once the value is stored
+	WriteVersion(path string, i interface{}, version string, stored func(version string)) error
+}
+
+// TransactionalDatabase is a content database that can change several paths in one transaction
+type TransactionalDatabase interface {
+	ContentDatabase
+
+	// Update calls the supplied function and then stores the changes it made in one transaction. All changes are
+	// stored if the function returns nil and no version conflicts, otherwise none of them are
+	Update(author string, message string, fn func(tx Transaction) error) error
 }
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VersionConflictError is returned when data is written using a version that's no longer the latest
+var VersionConflictError = errors.New("data was changed by someone else")
+
+const (
+	// AnyVersion is used when data is written regardless of the current version
+	AnyVersion = ""
+
+	// MissingVersion is the version of data that doesn't exist yet
+	MissingVersion = "missing"
+)
+
+// Version returns the version of the supplied data. It changes every time the data is changed
+func Version(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// WriteFile replaces the content of a file atomically, so that the file is never left half-written if the server
+// crashes. The data is written to a temporary file that's synced to disk before it replaces the original.
+// Parent directories are created if needed
+func WriteFile(filename string, data []byte) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The rename is only durable once the directory is synced. Not all platforms support it
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	version string
+}
+
+// FileWatcher keeps track of the json files in a directory, so that changes made by someone else than the
+// server are detected. The server writes files using the watcher, so that its own changes are not reported
+type FileWatcher struct {
+	rootPath string
+
+	mutex sync.Mutex
+	files map[string]fileState
+}
+
+// Write replaces the content of a file atomically, if its current version is the supplied version. The new
+// version is returned
+func (w *FileWatcher) Write(path string, data []byte, version string) (string, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	filename := w.filename(path)
+	if version != AnyVersion {
+		current := MissingVersion
+		if existing, err := ioutil.ReadFile(filename); err == nil {
+			current = Version(existing)
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		if current != version {
+			return "", VersionConflictError
+		}
+	}
+
+	if err := WriteFile(filename, data); err != nil {
+		return "", err
+	}
+	w.remember(path, filename, Version(data))
+	return Version(data), nil
+}
+
+// Read the content of a file together with its version
+func (w *FileWatcher) Read(path string) ([]byte, string, error) {
+	data, err := ioutil.ReadFile(w.filename(path))
+	if err != nil {
+		return nil, MissingVersion, err
+	}
+	return data, Version(data), nil
+}
+
+// Watch polls the directory for changes until the supplied channel is closed. The supplied function is called
+// with the path of each file that's changed, added or removed by someone else than the server
+func (w *FileWatcher) Watch(interval time.Duration, stop <-chan struct{}, changed func(path string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, path := range w.Scan() {
+				changed(path)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Scan the directory and return the paths of all files that are changed since the last scan
+func (w *FileWatcher) Scan() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var changed []string
+	seen := map[string]bool{}
+	_ = filepath.Walk(w.rootPath, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") && filename != w.rootPath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") || filepath.Ext(filename) != ".json" {
+			return nil
+		}
+		rel, err := filepath.Rel(w.rootPath, filename)
+		if err != nil {
+			return nil
+		}
+		path := "/" + filepath.ToSlash(rel)
+		seen[path] = true
+
+		known, ok := w.files[path]
+		if ok && known.modTime.Equal(info.ModTime()) && known.size == info.Size() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil
+		}
+		version := Version(data)
+		w.files[path] = fileState{modTime: info.ModTime(), size: info.Size(), version: version}
+		if !ok || known.version != version {
+			changed = append(changed, path)
+		}
+		return nil
+	})
+
+	for path := range w.files {
+		if !seen[path] {
+			delete(w.files, path)
+			changed = append(changed, path)
+		}
+	}
+	return changed
+}
+
+// remember the state of a file written by the server. The mutex must be locked by the caller
+func (w *FileWatcher) remember(path string, filename string, version string) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+	w.files[path] = fileState{modTime: info.ModTime(), size: info.Size(), version: version}
+}
+
+func (w *FileWatcher) filename(path string) string {
+	return filepath.Join(w.rootPath, filepath.FromSlash(path))
+}
+
+// NewFileWatcher creates a watcher for the supplied directory. The files that already exist are not reported
+// as changed
+func NewFileWatcher(rootPath string) *FileWatcher {
+	w := &FileWatcher{rootPath: rootPath, files: map[string]fileState{}}
+	w.Scan()
+	return w
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "nested", "users.json")
+	for _, content := range []string{`{"Users":[]}`, `{"Users":[{"Name":"per"}]}`} {
+		if err = WriteFile(filename, []byte(content)); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("expected %s but was %s", content, data)
+		}
+	}
+
+	// The temporary files are removed once the file is replaced
+	entries, err := ioutil.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only users.json but found %d files", len(entries))
+	}
+}
+
+func TestFileWatcherWrite(t *testing.T) {
+	current := Version([]byte(`{"Users":[]}`))
+	tests := []struct {
+		name     string
+		existing bool
+		version  string
+		expected error
+	}{
+		{"create missing file", false, MissingVersion, nil},
+		{"create existing file", true, MissingVersion, VersionConflictError},
+		{"write current version", true, current, nil},
+		{"write old version", true, Version([]byte(`{}`)), VersionConflictError},
+		{"write version of missing file", false, current, VersionConflictError},
+		{"write any version", true, AnyVersion, nil},
+		{"write any version of missing file", false, AnyVersion, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "gitgo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if test.existing {
+				if err = WriteFile(filepath.Join(dir, "users.json"), []byte(`{"Users":[]}`)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w := NewFileWatcher(dir)
+			data := []byte(`{"Users":[{"Name":"per"}]}`)
+			version, err := w.Write("/users.json", data, test.version)
+			if err != test.expected {
+				t.Fatalf("expected %v but was %v", test.expected, err)
+			}
+			if err == nil && version != Version(data) {
+				t.Errorf("expected version %s but was %s", Version(data), version)
+			}
+			if changed := w.Scan(); len(changed) != 0 {
+				t.Errorf("expected the server's own changes to be ignored but was %v", changed)
+			}
+		})
+	}
+}
+
+func TestFileWatcherScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = WriteFile(filepath.Join(dir, "users.json"), []byte(`{"Users":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+	w := NewFileWatcher(dir)
+
+	// Files written by someone else than the server are reported, except hidden files and files that aren't json
+	write := func(name string, content string) {
+		if err := WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("users.json", `{"Users":[{"Name":"per"}]}`)
+	write("webhooks/deliveries.json", `{}`)
+	write(".hidden.json", `{}`)
+	write("notes.txt", `notes`)
+	changed := w.Scan()
+	sort.Strings(changed)
+	if strings.Join(changed, ",") != "/users.json,/webhooks/deliveries.json" {
+		t.Errorf("unexpected changes %v", changed)
+	}
+
+	if changed = w.Scan(); len(changed) != 0 {
+		t.Errorf("expected no changes but was %v", changed)
+	}
+
+	if err = os.Remove(filepath.Join(dir, "users.json")); err != nil {
+		t.Fatal(err)
+	}
+	if changed = w.Scan(); strings.Join(changed, ",") != "/users.json" {
+		t.Errorf("expected the removed file to be reported but was %v", changed)
+	}
+}
@@ -0,0 +1,44 @@
+package db
+
+// change is a value that's written when a transaction is stored
+type change struct {
+	path    string
+	value   interface{}
+	version string
+	stored  func(version string)
+}
+
+// sequentialTransaction collects changes for databases that can't store several paths at once
+type sequentialTransaction struct {
+	changes []change
+}
+
+func (t *sequentialTransaction) WriteVersion(path string, i interface{}, version string,
+	stored func(version string)) error {
+	t.changes = append(t.changes, change{path: path, value: i, version: version, stored: stored})
+	return nil
+}
+
+// Update calls the supplied function and stores the changes it made in one transaction, if the database is a
+// TransactionalDatabase. Other databases store the changes one at a time, in the order they were made, and stop
+// at the first change that fails
+func Update(database ContentDatabase, author string, message string, fn func(tx Transaction) error) error {
+	if transactional, ok := database.(TransactionalDatabase); ok {
+		return transactional.Update(author, message, fn)
+	}
+
+	tx := &sequentialTransaction{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for _, c := range tx.changes {
+		version, err := database.WriteVersion(c.path, c.value, c.version, author, message)
+		if err != nil {
+			return err
+		}
+		if c.stored != nil {
+			c.stored(version)
+		}
+	}
+	return nil
+}
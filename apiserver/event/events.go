@@ -1,44 +1,66 @@
 package event
 
-import "log"
+import (
+	"reflect"
+	"time"
+)
 
 type Event interface{}
 
-type Failure struct {
-	// Original event
-	Original Event
+type Listener interface {
+	// OnEvent is called when a new event is raised. Returning an error means that the event is sent again,
+	// and the event is put in the dead-letter queue if it keeps failing
+	OnEvent(e Event) error
+}
 
-	// Listener points to whom failed
-	Listener Listener
+// DropListener can be implemented by listeners that must know when an event they are subscribed to is dropped
+// because the queue is full, such as listeners keeping caches in sync, since the event is never sent to them
+type DropListener interface {
+	// OnDropped is called by the goroutine raising the event, so it must not block
+	OnDropped(e Event)
 }
 
-type Listener interface {
-	// OnEvent is called when a new event is raised. Returning an error will raise a new event
-	OnEvent(e Event) error
+// Topic can be implemented by events that should be processed in order with other events than the ones
+// in the same package
+type Topic interface {
+	Topic() string
 }
 
-type Processor struct {
-	listeners []Listener
+// Journal persists events before they are processed, so that they can be replayed later
+type Journal interface {
+	// Append persists the supplied event and returns its sequence number
+	Append(e Event) (uint64, error)
+
+	// Sequence returns the sequence number of the latest persisted event
+	Sequence() uint64
+
+	// Replay calls the supplied function for each event after the since sequence number, up to and including
+	// the until sequence number, in the order they were appended
+	Replay(since uint64, until uint64, fn func(e Event) error) error
 }
 
-func (p *Processor) AddListener(listener Listener) {
-	p.listeners = append(p.listeners, listener)
+// DeadLetter is an event that a listener has failed to process too many times
+type DeadLetter struct {
+	Event    Event
+	Listener Listener
+	Error    error
+	Attempts int
+	FailedAt time.Time
 }
 
-// RaiseEvent to send a generic server event to various parts of the server, such as if
-// a new user is added.
-//
-// The processing is done in a separate goroutine
-func (p *Processor) RaiseEvent(evt Event) {
-	go func() {
-		for _, listener := range p.listeners {
-			if err := listener.OnEvent(evt); err != nil {
-				log.Printf("WARN: could not process event: %v\n", err)
-				p.RaiseEvent(&Failure{
-					Original: evt,
-					Listener: listener,
-				})
-			}
-		}
-	}()
+// topicOf returns the topic of the supplied event. Events are processed in the order they are raised within the
+// same topic. Unless the event implements Topic, it's the package the event is declared in, so that all events
+// about users are processed in order
+func topicOf(e Event) string {
+	if t, ok := e.(Topic); ok {
+		return t.Topic()
+	}
+	t := reflect.TypeOf(e)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if len(t.PkgPath()) == 0 {
+		return t.String()
+	}
+	return t.PkgPath()
 }
@@ -0,0 +1,364 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MaxAttempts is how many times a listener is called with the same event before it's put in the
+	// dead-letter queue
+	MaxAttempts = 3
+
+	// RetryDelay is how long to wait before calling a listener again. The delay is multiplied with the number
+	// of attempts
+	RetryDelay = 100 * time.Millisecond
+
+	// MaxDeadLetters is how many failed events are kept in the dead-letter queue
+	MaxDeadLetters = 100
+)
+
+var (
+	ClosedError    = errors.New("event processor is shut down")
+	QueueFullError = errors.New("event queue is full")
+	NilEventError  = errors.New("event is nil")
+	NoJournalError = errors.New("event processor has no journal to replay events from")
+)
+
+// queued is an event waiting to be processed. The sequence number is zero if the event isn't persisted
+type queued struct {
+	sequence uint64
+	evt      Event
+}
+
+// Subscription is a listener registered in a processor
+type Subscription struct {
+	processor *Processor
+	listener  Listener
+
+	// types contains the event types the listener is called for. It's called for all events if empty
+	types map[reflect.Type]bool
+
+	// active is set to zero when the listener is unsubscribed
+	active int32
+
+	// after is the sequence number of the latest event replayed to the listener. Newer events are sent by
+	// the workers
+	after uint64
+
+	// Events processed by the workers while older events are replayed are buffered, so that they are received
+	// in order
+	mutex     sync.Mutex
+	replaying bool
+	buffer    []queued
+}
+
+// Unsubscribe stops the listener from being called. Events that are already being processed might still be
+// sent to the listener
+func (s *Subscription) Unsubscribe() {
+	if atomic.CompareAndSwapInt32(&s.active, 1, 0) {
+		s.processor.unsubscribe(s)
+	}
+}
+
+func (s *Subscription) matches(t reflect.Type) bool {
+	return len(s.types) == 0 || s.types[t]
+}
+
+// hold buffers the supplied event if older events are still being replayed. Returns true if it's buffered
+func (s *Subscription) hold(item queued) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.replaying {
+		s.buffer = append(s.buffer, item)
+	}
+	return s.replaying
+}
+
+// Processor sends events to the listeners subscribed to them. Events are put in a bounded queue and are
+// processed by a fixed number of workers. All events in the same topic are processed by the same worker, so
+// they are received by the listeners in the order they were raised
+type Processor struct {
+	queues  []chan queued
+	journal Journal
+	wg      sync.WaitGroup
+
+	// mutex protects the subscriptions and the queues. Events are persisted and queued while it's locked, so that
+	// they are processed in the same order as they are persisted
+	mutex         sync.RWMutex
+	subscriptions []*Subscription
+	closed        bool
+
+	deadLettersMutex sync.Mutex
+	deadLetters      []DeadLetter
+}
+
+// AddListener subscribes the supplied listener to all events
+func (p *Processor) AddListener(listener Listener) *Subscription {
+	return p.Subscribe(listener)
+}
+
+// Subscribe the supplied listener to events of the same types as the supplied events, for example
+// Subscribe(l, &db.EventDataChanged{}). The listener is subscribed to all events if no events are supplied
+func (p *Processor) Subscribe(listener Listener, events ...Event) *Subscription {
+	s := newSubscription(p, listener, events)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.subscriptions = append(append([]*Subscription{}, p.subscriptions...), s)
+	return s
+}
+
+// SubscribeFrom subscribes the supplied listener in the same way as Subscribe, but first replays all persisted
+// events after the supplied sequence number. The listener receives the replayed events before any new events.
+// It blocks until all events are replayed
+func (p *Processor) SubscribeFrom(since uint64, listener Listener, events ...Event) (*Subscription, error) {
+	if p.journal == nil {
+		return nil, NoJournalError
+	}
+	s := newSubscription(p, listener, events)
+	s.replaying = true
+
+	// No events can be raised while the lock is held, so all events with a greater sequence number are
+	// sent by the workers
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, ClosedError
+	}
+	s.after = p.journal.Sequence()
+	p.subscriptions = append(append([]*Subscription{}, p.subscriptions...), s)
+	p.mutex.Unlock()
+
+	err := p.journal.Replay(since, s.after, func(e Event) error {
+		if s.matches(reflect.TypeOf(e)) {
+			p.deliver(s, e)
+		}
+		return nil
+	})
+	if err != nil {
+		s.Unsubscribe()
+		return nil, fmt.Errorf("could not replay events: %w", err)
+	}
+
+	for {
+		s.mutex.Lock()
+		buffered := s.buffer
+		s.buffer = nil
+		s.replaying = len(buffered) > 0
+		s.mutex.Unlock()
+		if len(buffered) == 0 {
+			return s, nil
+		}
+		for _, item := range buffered {
+			p.deliver(s, item.evt)
+		}
+	}
+}
+
+func newSubscription(p *Processor, listener Listener, events []Event) *Subscription {
+	s := &Subscription{processor: p, listener: listener, active: 1}
+	if len(events) > 0 {
+		s.types = map[reflect.Type]bool{}
+		for _, e := range events {
+			s.types[reflect.TypeOf(e)] = true
+		}
+	}
+	return s
+}
+
+func (p *Processor) unsubscribe(s *Subscription) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i, existing := range p.subscriptions {
+		if existing == s {
+			p.subscriptions = append(append([]*Subscription{}, p.subscriptions[:i]...), p.subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+// RaiseEvent to send a generic server event to various parts of the server, such as if
+// a new user is added.
+//
+// The event is persisted in the journal, if there is one, and is then processed by a separate goroutine. It never
+// blocks, so callers can raise events while holding their own locks and listeners can raise events without
+// waiting for their own queue. If the queue is full then QueueFullError is returned, and the event is neither
+// persisted nor processed. Listeners implementing DropListener are told about it instead
+func (p *Processor) RaiseEvent(evt Event) error {
+	if evt == nil {
+		return NilEventError
+	}
+	queue := p.queues[hash(topicOf(evt))%uint32(len(p.queues))]
+
+	p.mutex.Lock()
+	err := p.enqueue(queue, evt)
+	subscriptions := p.subscriptions
+	p.mutex.Unlock()
+
+	if errors.Is(err, QueueFullError) {
+		p.drop(subscriptions, evt)
+	}
+	return err
+}
+
+// enqueue persists the supplied event and puts it in the queue. The mutex must be locked by the caller
+func (p *Processor) enqueue(queue chan queued, evt Event) error {
+	if p.closed {
+		return ClosedError
+	}
+
+	// Only RaiseEvent sends events to the queues, so the event can always be queued if there's room while the
+	// lock is held
+	if len(queue) == cap(queue) {
+		return fmt.Errorf("could not raise %T: %w", evt, QueueFullError)
+	}
+
+	item := queued{evt: evt}
+	if p.journal != nil {
+		sequence, err := p.journal.Append(evt)
+		if err != nil {
+			// The event is still processed, because the rest of the server depends on it
+			log.Printf("ERROR: could not persist event %T: %v\n", evt, err)
+		}
+		item.sequence = sequence
+	}
+	queue <- item
+	return nil
+}
+
+// drop tells the listeners implementing DropListener that the supplied event is dropped
+func (p *Processor) drop(subscriptions []*Subscription, evt Event) {
+	t := reflect.TypeOf(evt)
+	for _, s := range subscriptions {
+		if l, ok := s.listener.(DropListener); ok && s.matches(t) && atomic.LoadInt32(&s.active) == 1 {
+			l.OnDropped(evt)
+		}
+	}
+}
+
+// DeadLetters returns the events that listeners have failed to process, oldest first
+func (p *Processor) DeadLetters() []DeadLetter {
+	p.deadLettersMutex.Lock()
+	defer p.deadLettersMutex.Unlock()
+	return append([]DeadLetter{}, p.deadLetters...)
+}
+
+// Shutdown stops accepting new events and waits for the queued events to be processed. An error is returned
+// if the context is done before all events are processed
+func (p *Processor) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Processor) work(queue chan queued) {
+	defer p.wg.Done()
+	for item := range queue {
+		p.dispatch(item)
+	}
+}
+
+// dispatch sends the event to all listeners subscribed to it. Events that have already been replayed to a
+// listener are skipped
+func (p *Processor) dispatch(item queued) {
+	t := reflect.TypeOf(item.evt)
+	p.mutex.RLock()
+	subscriptions := p.subscriptions
+	p.mutex.RUnlock()
+
+	for _, s := range subscriptions {
+		if !s.matches(t) || (item.sequence != 0 && item.sequence <= s.after) || s.hold(item) {
+			continue
+		}
+		p.deliver(s, item.evt)
+	}
+}
+
+// deliver the event to a listener. The listener is called again if it fails, and the event is put in the
+// dead-letter queue if it keeps failing
+func (p *Processor) deliver(s *Subscription, evt Event) {
+	var err error
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		if atomic.LoadInt32(&s.active) == 0 {
+			return
+		}
+		if err = call(s.listener, evt); err == nil {
+			return
+		}
+		log.Printf("WARN: could not process event %T (attempt %d of %d): %v\n", evt, attempt, MaxAttempts, err)
+		if attempt < MaxAttempts {
+			time.Sleep(time.Duration(attempt) * RetryDelay)
+		}
+	}
+
+	log.Printf("ERROR: giving up on event %T for listener %T: %v\n", evt, s.listener, err)
+	p.deadLettersMutex.Lock()
+	defer p.deadLettersMutex.Unlock()
+	p.deadLetters = append(p.deadLetters, DeadLetter{
+		Event:    evt,
+		Listener: s.listener,
+		Error:    err,
+		Attempts: MaxAttempts,
+		FailedAt: time.Now(),
+	})
+	if len(p.deadLetters) > MaxDeadLetters {
+		p.deadLetters = p.deadLetters[len(p.deadLetters)-MaxDeadLetters:]
+	}
+}
+
+// call the listener, treating a panic as an error so that a broken listener can't stop the worker
+func call(listener Listener, evt Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("listener panicked: %v", r)
+		}
+	}()
+	return listener.OnEvent(evt)
+}
+
+func hash(topic string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(topic))
+	return h.Sum32()
+}
+
+// NewProcessor creates a processor with the supplied number of workers. Each worker has its own queue,
+// which holds at most queueSize events. Events are persisted in the supplied journal, unless it's nil
+func NewProcessor(workers int, queueSize int, journal Journal) *Processor {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Processor{queues: make([]chan queued, workers), journal: journal}
+	for i := range p.queues {
+		p.queues[i] = make(chan queued, queueSize)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
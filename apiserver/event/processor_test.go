@@ -0,0 +1,275 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type numbered struct {
+	topic  string
+	number int
+}
+
+func (e *numbered) Topic() string {
+	return e.topic
+}
+
+type other struct{}
+
+// recorder remembers the events it receives
+type recorder struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (r *recorder) OnEvent(e Event) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) received() []Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Event{}, r.events...)
+}
+
+// failing fails the first failures times it's called
+type failing struct {
+	failures int
+	calls    int
+	panics   bool
+}
+
+func (f *failing) OnEvent(Event) error {
+	f.calls++
+	if f.calls > f.failures {
+		return nil
+	}
+	if f.panics {
+		panic("broken listener")
+	}
+	return errors.New("could not process event")
+}
+
+// memoryJournal keeps the persisted events in memory
+type memoryJournal struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (j *memoryJournal) Append(e Event) (uint64, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.events = append(j.events, e)
+	return uint64(len(j.events)), nil
+}
+
+func (j *memoryJournal) Sequence() uint64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return uint64(len(j.events))
+}
+
+func (j *memoryJournal) Replay(since uint64, until uint64, fn func(e Event) error) error {
+	j.mutex.Lock()
+	events := append([]Event{}, j.events[since:until]...)
+	j.mutex.Unlock()
+	for _, e := range events {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func shutdown(t *testing.T, p *Processor) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatalf("could not shut down the processor: %v", err)
+	}
+}
+
+func TestProcessorOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		topics  []string
+	}{
+		{"one worker", 1, []string{"users"}},
+		{"many workers", 4, []string{"users"}},
+		{"many topics", 4, []string{"users", "organizations", "repositories", "tokens"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewProcessor(test.workers, 1000, nil)
+			r := &recorder{}
+			p.AddListener(r)
+			for i := 0; i < 100; i++ {
+				for _, topic := range test.topics {
+					if err := p.RaiseEvent(&numbered{topic: topic, number: i}); err != nil {
+						t.Fatalf("could not raise event: %v", err)
+					}
+				}
+			}
+			shutdown(t, p)
+
+			next := map[string]int{}
+			for _, e := range r.received() {
+				n := e.(*numbered)
+				if n.number != next[n.topic] {
+					t.Fatalf("expected event %d in %s but was %d", next[n.topic], n.topic, n.number)
+				}
+				next[n.topic]++
+			}
+			for _, topic := range test.topics {
+				if next[topic] != 100 {
+					t.Errorf("expected 100 events in %s but was %d", topic, next[topic])
+				}
+			}
+		})
+	}
+}
+
+func TestProcessorSubscribe(t *testing.T) {
+	p := NewProcessor(2, 10, nil)
+	all, numbers := &recorder{}, &recorder{}
+	p.AddListener(all)
+	p.Subscribe(numbers, &numbered{})
+	_ = p.RaiseEvent(&numbered{topic: "a"})
+	_ = p.RaiseEvent(&other{})
+	shutdown(t, p)
+
+	if len(all.received()) != 2 {
+		t.Errorf("expected 2 events but was %d", len(all.received()))
+	}
+	if len(numbers.received()) != 1 {
+		t.Errorf("expected 1 event but was %d", len(numbers.received()))
+	}
+}
+
+func TestProcessorDeadLetters(t *testing.T) {
+	tests := []struct {
+		name        string
+		listener    *failing
+		deadLetters int
+	}{
+		{"succeeds", &failing{}, 0},
+		{"succeeds when retried", &failing{failures: MaxAttempts - 1}, 0},
+		{"keeps failing", &failing{failures: MaxAttempts}, 1},
+		{"keeps panicking", &failing{failures: MaxAttempts, panics: true}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewProcessor(1, 10, nil)
+			p.AddListener(test.listener)
+			if err := p.RaiseEvent(&other{}); err != nil {
+				t.Fatalf("could not raise event: %v", err)
+			}
+			shutdown(t, p)
+
+			deadLetters := p.DeadLetters()
+			if len(deadLetters) != test.deadLetters {
+				t.Fatalf("expected %d dead letters but was %d", test.deadLetters, len(deadLetters))
+			}
+			for _, d := range deadLetters {
+				if d.Attempts != MaxAttempts || d.Error == nil || d.Listener != test.listener {
+					t.Errorf("unexpected dead letter %+v", d)
+				}
+			}
+		})
+	}
+}
+
+// blocking blocks until the release channel is closed
+type blocking struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blocking) OnEvent(Event) error {
+	b.once.Do(func() { close(b.started) })
+	<-b.release
+	return nil
+}
+
+// dropping remembers the events that are dropped
+type dropping struct {
+	recorder
+	dropped []Event
+}
+
+func (d *dropping) OnDropped(e Event) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.dropped = append(d.dropped, e)
+}
+
+func TestProcessorQueueFull(t *testing.T) {
+	p := NewProcessor(1, 1, nil)
+	b := &blocking{started: make(chan struct{}), release: make(chan struct{})}
+	p.AddListener(b)
+	all, numbers := &dropping{}, &dropping{}
+	p.AddListener(all)
+	p.Subscribe(numbers, &numbered{})
+
+	// The first event is processed by the worker, and the second one waits in the queue
+	if err := p.RaiseEvent(&other{}); err != nil {
+		t.Fatalf("could not raise event: %v", err)
+	}
+	<-b.started
+	if err := p.RaiseEvent(&other{}); err != nil {
+		t.Fatalf("could not raise event: %v", err)
+	}
+	if err := p.RaiseEvent(&other{}); !errors.Is(err, QueueFullError) {
+		t.Errorf("expected %v but was %v", QueueFullError, err)
+	}
+	close(b.release)
+	shutdown(t, p)
+
+	// Only the listeners subscribed to the dropped event are told about it
+	if len(all.dropped) != 1 || len(all.received()) != 2 {
+		t.Errorf("expected 1 dropped and 2 received events but was %d and %d", len(all.dropped),
+			len(all.received()))
+	}
+	if len(numbers.dropped) != 0 {
+		t.Errorf("expected no dropped events but was %d", len(numbers.dropped))
+	}
+
+	if err := p.RaiseEvent(&other{}); err != ClosedError {
+		t.Errorf("expected %v but was %v", ClosedError, err)
+	}
+}
+
+func TestProcessorSubscribeFrom(t *testing.T) {
+	journal := &memoryJournal{}
+	p := NewProcessor(1, 10, journal)
+	for i := 0; i < 5; i++ {
+		_ = p.RaiseEvent(&numbered{topic: "a", number: i})
+	}
+
+	r := &recorder{}
+	if _, err := p.SubscribeFrom(2, r, &numbered{}); err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	for i := 5; i < 8; i++ {
+		_ = p.RaiseEvent(&numbered{topic: "a", number: i})
+	}
+	shutdown(t, p)
+
+	received := r.received()
+	if len(received) != 6 {
+		t.Fatalf("expected 6 events but was %d", len(received))
+	}
+	for i, e := range received {
+		if n := e.(*numbered); n.number != i+2 {
+			t.Errorf("expected event %d but was %d", i+2, n.number)
+		}
+	}
+}
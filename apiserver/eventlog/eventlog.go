@@ -0,0 +1,420 @@
+package eventlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"github.com/westcoastcode-se/gitgo/apiserver/organization"
+	"github.com/westcoastcode-se/gitgo/apiserver/pki"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/role"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultMemorySize is how many of the latest events are kept in memory. Older events are read from disk
+const DefaultMemorySize = 1024
+
+// record is a single line in the log file. Events are stored in a form that can be replayed, but without secrets
+// such as password hashes
+type record struct {
+	Sequence  uint64
+	CreatedAt time.Time
+	Type      string
+	Event     json.RawMessage
+}
+
+// Log is an append-only event log stored on disk. Each event is stored as a json encoded line, together with
+// an increasing sequence number and when it was raised. It implements event.Journal
+type Log struct {
+	path       string
+	memorySize int
+
+	mutex    sync.RWMutex
+	file     *os.File
+	sequence uint64
+	latest   []record
+	types    map[string]reflect.Type
+
+	// changed is closed, and replaced, every time a new event is appended
+	changed chan struct{}
+}
+
+// Register the event types that can be replayed. The supplied events are only used to find their types
+func (l *Log) Register(events ...event.Event) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, e := range events {
+		t := reflect.TypeOf(e)
+		l.types[typeName(t)] = t
+	}
+}
+
+func (l *Log) Append(e event.Event) (uint64, error) {
+	data, err := json.Marshal(sanitize(e))
+	if err != nil {
+		return 0, err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	r := record{
+		Sequence:  l.sequence + 1,
+		CreatedAt: time.Now(),
+		Type:      typeName(reflect.TypeOf(e)),
+		Event:     data,
+	}
+	line, err := json.Marshal(&r)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = l.file.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	if err = l.file.Sync(); err != nil {
+		return 0, err
+	}
+
+	l.sequence = r.Sequence
+	l.remember(r)
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return r.Sequence, nil
+}
+
+func (l *Log) Sequence() uint64 {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.sequence
+}
+
+func (l *Log) Replay(since uint64, until uint64, fn func(e event.Event) error) error {
+	return l.scan(since, func(r record) (bool, error) {
+		if r.Sequence > until {
+			return false, nil
+		}
+		e, err := l.decode(r)
+		if err != nil {
+			log.Printf("WARN: could not replay event %d: %v\n", r.Sequence, err)
+			return true, nil
+		}
+		return true, fn(e)
+	})
+}
+
+// Since returns at most limit events after the supplied sequence number
+func (l *Log) Since(since uint64, limit int) (api.EventRecords, error) {
+	result := api.EventRecords{Sequence: since, Records: []api.EventRecord{}}
+	add := func(r record) (bool, error) {
+		if len(result.Records) >= limit {
+			return false, nil
+		}
+		result.Records = append(result.Records, l.toApi(r))
+		result.Sequence = r.Sequence
+		return true, nil
+	}
+
+	l.mutex.RLock()
+	inMemory := len(l.latest) > 0 && since+1 >= l.latest[0].Sequence
+	if inMemory || since >= l.sequence {
+		for _, r := range l.latest {
+			if r.Sequence > since {
+				if more, _ := add(r); !more {
+					break
+				}
+			}
+		}
+		l.mutex.RUnlock()
+		return result, nil
+	}
+	l.mutex.RUnlock()
+
+	return result, l.scan(since, add)
+}
+
+// Wait returns the events after the supplied sequence number. If there are none, then it waits until a new
+// event is appended or the supplied time has passed
+func (l *Log) Wait(ctx context.Context, since uint64, limit int, wait time.Duration) (api.EventRecords, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		l.mutex.RLock()
+		changed := l.changed
+		l.mutex.RUnlock()
+
+		result, err := l.Since(since, limit)
+		if err != nil || len(result.Records) > 0 {
+			return result, err
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return result, nil
+		case <-ctx.Done():
+			return result, nil
+		}
+	}
+}
+
+// Close the log file
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+// remember keeps the latest records in memory. Between memorySize and twice as many records are kept, so
+// that the records don't have to be copied every time. The mutex must be locked by the caller
+func (l *Log) remember(r record) {
+	l.latest = append(l.latest, r)
+	if len(l.latest) > 2*l.memorySize {
+		l.latest = append([]record{}, l.latest[len(l.latest)-l.memorySize:]...)
+	}
+}
+
+// scan reads the log file from the beginning and calls the supplied function for every record after the
+// supplied sequence number, until it returns false or an error
+func (l *Log) scan(since uint64, fn func(r record) (bool, error)) error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// The last line is ignored if it's not complete, because it's still being written
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var r record
+		if err = json.Unmarshal(line, &r); err != nil || r.Sequence <= since {
+			continue
+		}
+		if more, err := fn(r); err != nil || !more {
+			return err
+		}
+	}
+}
+
+// decode the event stored in the supplied record
+func (l *Log) decode(r record) (event.Event, error) {
+	l.mutex.RLock()
+	t, ok := l.types[r.Type]
+	l.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %s", r.Type)
+	}
+
+	value := reflect.New(t.Elem())
+	if err := json.Unmarshal(r.Event, value.Interface()); err != nil {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+// toApi converts a record into its api representation. Events containing users are converted, so that
+// password hashes are never exposed. Events of unknown types have no data
+func (l *Log) toApi(r record) api.EventRecord {
+	result := api.EventRecord{Sequence: r.Sequence, CreatedAt: r.CreatedAt, Type: r.Type, Data: []byte("null")}
+	e, err := l.decode(r)
+	if err != nil {
+		return result
+	}
+
+	var data interface{} = e
+	switch e := e.(type) {
+	case *user.EventUserAdded:
+		data = struct{ User *api.User }{e.User.ToApi()}
+	case *user.EventUserRemoved:
+		data = struct{ User *api.User }{e.User.ToApi()}
+	case *user.EventUserChanged:
+		data = struct{ User *api.User }{e.User.ToApi()}
+	case *user.EventPublicKeyAdded:
+		data = struct {
+			User string
+			Key  api.PublicKey
+		}{e.User.Name, e.Key}
+	case *user.EventPublicKeyRemoved:
+		data = struct {
+			User string
+			Key  api.PublicKey
+		}{e.User.Name, e.Key}
+	case *repository.EventRepositoryCreated:
+		data = struct{ Repository string }{e.Repository.Name}
+	case *repository.EventRepositoryDeleted:
+		data = struct{ Repository string }{e.Repository.Name}
+	case *repository.EventRepositoryRestored:
+		data = struct{ Repository string }{e.Repository.Name}
+	case *repository.EventRepositoryPurged:
+		data = struct{ Repository string }{e.Repository.Name}
+	case *repository.EventRepositoryRenamed:
+		data = struct {
+			Repository string
+			OldName    string
+		}{e.Repository.Name, e.OldName}
+	}
+	if encoded, err := json.Marshal(data); err == nil {
+		result.Data = encoded
+	}
+	return result
+}
+
+// sanitize converts events containing users into a form without password hashes before they are stored. Users
+// are stored in their api form, which is read back into a user without a password hash when the event is replayed
+func sanitize(e event.Event) interface{} {
+	switch e := e.(type) {
+	case *user.EventUserAdded:
+		return struct{ User *api.User }{e.User.ToApi()}
+	case *user.EventUserRemoved:
+		return struct{ User *api.User }{e.User.ToApi()}
+	case *user.EventUserChanged:
+		return struct{ User *api.User }{e.User.ToApi()}
+	case *user.EventPublicKeyAdded:
+		return struct {
+			User *api.User
+			Key  api.PublicKey
+		}{e.User.ToApi(), e.Key}
+	case *user.EventPublicKeyRemoved:
+		return struct {
+			User *api.User
+			Key  api.PublicKey
+		}{e.User.ToApi(), e.Key}
+	}
+	return e
+}
+
+// load reads the sequence number and the latest events from the log file. An incomplete last line, written
+// when the server crashed, is removed. Events written by earlier versions, that might contain password hashes,
+// are sanitized and the log file is rewritten
+func (l *Log) load() error {
+	data, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+	if end := bytes.LastIndexByte(data, '\n') + 1; end < len(data) {
+		log.Printf("WARN: removing incomplete event at the end of %s\n", l.path)
+		if err = os.Truncate(l.path, int64(end)); err != nil {
+			return err
+		}
+		data = data[:end]
+	}
+
+	var lines [][]byte
+	sanitized := false
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		lines = append(lines, line)
+		var r record
+		if err = json.Unmarshal(line, &r); err != nil {
+			log.Printf("WARN: skipping invalid event in %s: %v\n", l.path, err)
+			continue
+		}
+		if e, err := l.decode(r); err == nil && sanitize(e) != e {
+			if clean, err := json.Marshal(sanitize(e)); err == nil && !bytes.Equal(clean, r.Event) {
+				r.Event = clean
+				if line, err = json.Marshal(&r); err == nil {
+					lines[len(lines)-1] = line
+					sanitized = true
+				}
+			}
+		}
+		if r.Sequence > l.sequence {
+			l.sequence = r.Sequence
+		}
+		l.remember(r)
+	}
+	if sanitized {
+		log.Printf("INFO: removing secrets from the events in %s\n", l.path)
+		return rewrite(l.path, lines)
+	}
+	return nil
+}
+
+// rewrite replaces the content of the log file with the supplied lines. The lines are written to a temporary
+// file that replaces the log file, so that it's never left half-written
+func rewrite(path string, lines [][]byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err = file.Write(append(line, '\n')); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// typeName returns the name used when storing events of the supplied type, for example "EventUserAdded"
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// New opens, or creates, the event log located at the supplied path. All events raised by the api server are
+// registered, so that they can be replayed
+func New(path string) (*Log, error) {
+	l := &Log{
+		path:       path,
+		memorySize: DefaultMemorySize,
+		types:      map[string]reflect.Type{},
+		changed:    make(chan struct{}),
+	}
+	l.Register(&db.EventDataChanged{}, &user.EventUserAdded{}, &user.EventUserRemoved{}, &user.EventUserChanged{},
+		&user.EventPublicKeyAdded{}, &user.EventPublicKeyRemoved{},
+		&repository.EventRepositoryCreated{}, &repository.EventRepositoryDeleted{},
+		&repository.EventRepositoryRestored{}, &repository.EventRepositoryPurged{}, &repository.EventRepositoryRenamed{},
+		&repository.EventRepositoryPushed{}, &repository.EventRepositoryFetched{},
+		&organization.EventOrganizationCreated{}, &organization.EventOrganizationRemoved{},
+		&organization.EventOrganizationChanged{}, &role.EventRoleCreated{}, &role.EventRoleChanged{},
+		&role.EventRoleRemoved{}, &pki.EventCertificateIssued{}, &pki.EventCertificateRevoked{})
+	if err := l.load(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+
+	// Events contain user names and repository names, so only the api server is allowed to read them
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = file.Chmod(0600); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	l.file = file
+	return l, nil
+}
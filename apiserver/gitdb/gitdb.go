@@ -0,0 +1,256 @@
+package gitdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	InvalidPathError      = errors.New("path is not valid")
+	InvalidRevisionError  = errors.New("revision is not valid")
+	RevisionNotFoundError = errors.New("revision not found")
+)
+
+var validRevisionRegex = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+
+// GitContentDatabase stores json files in a git repository. Every write is committed, authored by the user
+// that made the change, so that the history of all data is kept and changes can be reverted
+type GitContentDatabase struct {
+	rootPath string
+	gitPath  string
+	watcher  *db.FileWatcher
+
+	// processor is used when telling the rest of the server that data is reverted
+	processor *event.Processor
+
+	// mutex serializes all changes, because git only allows one change to the index at a time
+	mutex sync.Mutex
+}
+
+func (d *GitContentDatabase) Read(path string, i interface{}) error {
+	_, err := d.ReadVersion(path, i)
+	return err
+}
+
+func (d *GitContentDatabase) Write(path string, i interface{}, author string, message string) error {
+	_, err := d.WriteVersion(path, i, db.AnyVersion, author, message)
+	return err
+}
+
+func (d *GitContentDatabase) ReadVersion(path string, i interface{}) (string, error) {
+	name, err := d.filename(path)
+	if err != nil {
+		return db.MissingVersion, err
+	}
+	data, version, err := d.watcher.Read("/" + name)
+	if err != nil {
+		return version, err
+	}
+	return version, json.Unmarshal(data, i)
+}
+
+func (d *GitContentDatabase) WriteVersion(path string, i interface{}, version string, author string,
+	message string) (string, error) {
+	name, err := d.filename(path)
+	if err != nil {
+		return "", err
+	}
+	// The json is indented, so that the changes are easy to read in the history
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.commit(name, append(data, '\n'), version, author, message)
+}
+
+func (d *GitContentDatabase) History(path string, limit int) ([]db.Revision, error) {
+	name, err := d.filename(path)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := d.git("log", fmt.Sprintf("--max-count=%d", limit), "--format=%H%x00%an%x00%aI%x00%s",
+		"--", name)
+	if err != nil {
+		// A repository without commits has no history
+		if strings.Contains(err.Error(), "does not have any commits") {
+			return []db.Revision{}, nil
+		}
+		return nil, err
+	}
+
+	result := []db.Revision{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.SplitN(line, "\x00", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		createdAt, _ := time.Parse(time.RFC3339, fields[2])
+		result = append(result, db.Revision{
+			ID:        fields[0],
+			Author:    fields[1],
+			Message:   fields[3],
+			CreatedAt: createdAt,
+		})
+	}
+	return result, nil
+}
+
+func (d *GitContentDatabase) Revert(path string, revision string, author string) error {
+	name, err := d.filename(path)
+	if err != nil {
+		return err
+	}
+	if !validRevisionRegex.MatchString(revision) {
+		return InvalidRevisionError
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	data, err := d.git("show", fmt.Sprintf("%s:%s", revision, name))
+	if err != nil {
+		return RevisionNotFoundError
+	}
+	_, err = d.commit(name, []byte(data), db.AnyVersion, author, fmt.Sprintf("reverting %s to %s", path, revision))
+	if err != nil {
+		return err
+	}
+	if d.processor != nil {
+		if err = d.processor.RaiseEvent(&db.EventDataChanged{Path: path}); err != nil {
+			log.Printf("WARN: could not raise event: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// commit writes the supplied data, if the file has the supplied version, and commits it. The file is restored if
+// the commit fails. The mutex must be locked by the caller
+func (d *GitContentDatabase) commit(name string, data []byte, version string, author string,
+	message string) (string, error) {
+	path := "/" + name
+	previous, previousVersion, readErr := d.watcher.Read(path)
+	newVersion, err := d.watcher.Write(path, data, version)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = d.git("add", "--", name)
+	if err == nil {
+		_, err = d.git("diff", "--cached", "--quiet", "--", name)
+		if err == nil {
+			// Nothing is changed
+			return newVersion, nil
+		}
+		_, err = d.git("commit", "--quiet", "--no-verify", "--author", fmt.Sprintf("%s <>", author),
+			"--message", message, "--", name)
+	}
+	if err != nil {
+		if readErr == nil {
+			_, _ = d.watcher.Write(path, previous, newVersion)
+		} else {
+			_ = os.Remove(filepath.Join(d.rootPath, name))
+		}
+		_, _ = d.git("reset", "--quiet", "--", name)
+		log.Printf("WARN: restored %s to version %s after failing to commit it\n", path, previousVersion)
+		return "", err
+	}
+	return newVersion, nil
+}
+
+// Watch commits the files that are changed outside of the api server and raises db.EventDataChanged, so that the
+// data is reloaded. It blocks until the supplied channel is closed
+func (d *GitContentDatabase) Watch(interval time.Duration, stop <-chan struct{}) {
+	d.watcher.Watch(interval, stop, func(path string) {
+		log.Printf("INFO: %s is changed outside of the api server\n", path)
+		if err := d.commitAll(fmt.Sprintf("recording changes made to %s outside of the api server", path)); err != nil {
+			log.Printf("WARN: could not commit %s: %v\n", path, err)
+		}
+		if d.processor != nil {
+			if err := d.processor.RaiseEvent(&db.EventDataChanged{Path: path}); err != nil {
+				log.Printf("WARN: could not raise event: %v\n", err)
+			}
+		}
+	})
+}
+
+// commitAll commits all files that are changed outside of the api server, for example when the data is imported
+// from a json database
+func (d *GitContentDatabase) commitAll(message string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, err := d.git("add", "--all"); err != nil {
+		return err
+	}
+	if _, err := d.git("diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	log.Printf("INFO: %s in %s\n", message, d.rootPath)
+	_, err := d.git("commit", "--quiet", "--no-verify", "--author", fmt.Sprintf("%s <>", db.SystemAuthor),
+		"--message", message)
+	return err
+}
+
+// filename converts a database path, such as "/users.json", into a file name relative to the repository
+func (d *GitContentDatabase) filename(p string) (string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if len(name) == 0 || strings.HasPrefix(name, ".") || strings.Contains(name, "/.") {
+		return "", InvalidPathError
+	}
+	return name, nil
+}
+
+// git executes a git command in the repository and returns what it writes to stdout
+func (d *GitContentDatabase) git(args ...string) (string, error) {
+	cmd := exec.Command(d.gitPath, args...)
+	cmd.Dir = d.rootPath
+	cmd.Env = append(os.Environ(), "GIT_COMMITTER_NAME="+db.SystemAuthor, "GIT_COMMITTER_EMAIL=")
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// New opens, or creates, a git repository in the supplied root path. Files that already exist, for example
+// when the data was previously stored in a json database, are committed
+func New(rootPath string, gitPath string, processor *event.Processor) (*GitContentDatabase, error) {
+	result := &GitContentDatabase{
+		rootPath:  rootPath,
+		gitPath:   gitPath,
+		processor: processor,
+	}
+	if err := os.MkdirAll(rootPath, 0755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(rootPath, ".git")); os.IsNotExist(err) {
+		log.Printf("INFO: Creating a git repository for the database in %s\n", rootPath)
+		if _, err = result.git("init", "--quiet"); err != nil {
+			return nil, err
+		}
+	}
+	if err := result.commitAll("importing changes made outside of the api server"); err != nil {
+		return nil, err
+	}
+	result.watcher = db.NewFileWatcher(rootPath)
+	return result, nil
+}
@@ -0,0 +1,157 @@
+package invalidation
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"github.com/westcoastcode-se/gitgo/apiserver/organization"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/role"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultBufferSize is how many invalidations the broker remembers. Clients that are further behind than
+// this are told to invalidate everything
+const DefaultBufferSize = 1024
+
+// Broker converts events about changed users, public keys, organizations and repositories into invalidations.
+// Clients, such as the git server, wait for invalidations so that they can remove data from their caches as soon
+// as it's changed
+type Broker struct {
+	epoch      string
+	bufferSize int
+
+	mutex         sync.Mutex
+	sequence      uint64
+	invalidations []api.Invalidation
+
+	// changed is closed, and replaced, every time a new invalidation is published
+	changed chan struct{}
+}
+
+func (b *Broker) OnEvent(e event.Event) error {
+	switch evt := e.(type) {
+	case *db.EventDataChanged:
+		// Users, organizations, roles and repositories are reloaded when they are changed outside of the api
+		// server. The broker is subscribed after the databases, so they are reloaded before the clients are told
+		// about it
+		switch evt.Path {
+		case user.DatabasePath, organization.DatabasePath, role.DatabasePath, repository.DatabasePath:
+			b.Publish(api.Invalidation{Type: api.InvalidateAll})
+		}
+	case *user.EventUserAdded:
+		b.Publish(api.Invalidation{Type: api.InvalidateUser, User: evt.User.Name})
+	case *user.EventUserRemoved:
+		b.Publish(api.Invalidation{Type: api.InvalidateUser, User: evt.User.Name})
+	case *user.EventUserChanged:
+		b.Publish(api.Invalidation{Type: api.InvalidateUser, User: evt.User.Name})
+	case *user.EventPublicKeyAdded:
+		b.Publish(api.Invalidation{Type: api.InvalidatePublicKey, Fingerprint: evt.Key.Fingerprint})
+	case *user.EventPublicKeyRemoved:
+		b.Publish(api.Invalidation{Type: api.InvalidatePublicKey, Fingerprint: evt.Key.Fingerprint})
+	case *organization.EventOrganizationChanged:
+		// The teams, and the access granted through them, might have changed for every affected user
+		for _, name := range evt.Users {
+			b.Publish(api.Invalidation{Type: api.InvalidateUser, User: name})
+		}
+	case *repository.EventRepositoryDeleted:
+		b.Publish(api.Invalidation{Type: api.InvalidateRepository, Repository: evt.Repository.Name})
+	case *repository.EventRepositoryRestored:
+		b.Publish(api.Invalidation{Type: api.InvalidateRepository, Repository: evt.Repository.Name})
+	case *repository.EventRepositoryPurged:
+		b.Publish(api.Invalidation{Type: api.InvalidateRepository, Repository: evt.Repository.Name})
+	case *repository.EventRepositoryRenamed:
+		// The access is moved to the new name, and nothing is granted through the old name any more
+		b.Publish(api.Invalidation{Type: api.InvalidateRepository, Repository: evt.OldName})
+		b.Publish(api.Invalidation{Type: api.InvalidateRepository, Repository: evt.Repository.Name})
+	case *role.EventRoleChanged, *role.EventRoleRemoved:
+		// Roles might grant access to repositories for any number of users
+		b.Publish(api.Invalidation{Type: api.InvalidateAll})
+	}
+	return nil
+}
+
+// OnDropped tells the clients to invalidate everything, since they would otherwise keep data that's changed by
+// an event the broker never receives
+func (b *Broker) OnDropped(e event.Event) {
+	log.Printf("WARN: invalidating everything since %T was dropped\n", e)
+	b.Publish(api.Invalidation{Type: api.InvalidateAll})
+}
+
+// Publish sends the supplied invalidation to all clients
+func (b *Broker) Publish(invalidation api.Invalidation) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.sequence++
+	invalidation.Sequence = b.sequence
+	b.invalidations = append(b.invalidations, invalidation)
+	if len(b.invalidations) > b.bufferSize {
+		b.invalidations = b.invalidations[len(b.invalidations)-b.bufferSize:]
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Wait returns all invalidations that occurred after the supplied sequence number. If there are none, then
+// it waits until a new invalidation is published or the supplied time has passed. Clients that supply an
+// unknown epoch, or are too far behind, are told to invalidate everything
+func (b *Broker) Wait(ctx context.Context, epoch string, since uint64, wait time.Duration) api.Invalidations {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		b.mutex.Lock()
+		result, found := b.collect(epoch, since)
+		changed := b.changed
+		b.mutex.Unlock()
+		if found {
+			return result
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return result
+		case <-ctx.Done():
+			return result
+		}
+	}
+}
+
+// collect the invalidations after the supplied sequence number. Returns true if anything is found
+func (b *Broker) collect(epoch string, since uint64) (api.Invalidations, bool) {
+	result := api.Invalidations{
+		Epoch:         b.epoch,
+		Sequence:      b.sequence,
+		Invalidations: []api.Invalidation{},
+	}
+
+	oldest := b.sequence - uint64(len(b.invalidations))
+	if epoch != b.epoch || since < oldest || since > b.sequence {
+		result.Invalidations = append(result.Invalidations,
+			api.Invalidation{Sequence: b.sequence, Type: api.InvalidateAll})
+		return result, true
+	}
+
+	for _, invalidation := range b.invalidations {
+		if invalidation.Sequence > since {
+			result.Invalidations = append(result.Invalidations, invalidation)
+		}
+	}
+	return result, len(result.Invalidations) > 0
+}
+
+// NewBroker creates a new broker. Each broker has a unique epoch
+func NewBroker() *Broker {
+	return &Broker{
+		epoch:      uuid.New().String(),
+		bufferSize: DefaultBufferSize,
+		changed:    make(chan struct{}),
+	}
+}
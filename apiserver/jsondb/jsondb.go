@@ -2,36 +2,63 @@ package jsondb
 
 import (
 	"encoding/json"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"log"
+	"time"
 )
 
 type JsonContentDatabase struct {
 	rootPath string
+	watcher  *db.FileWatcher
+
+	// processor is used when telling the rest of the server that data is changed
+	processor *event.Processor
 }
 
 func (d *JsonContentDatabase) Read(path string, i interface{}) error {
-	filename := filepath.Join(d.rootPath, path)
-	if _, err := os.Stat(filename); err != nil {
-		return err
-	}
-	data, err := ioutil.ReadFile(filename)
+	_, err := d.ReadVersion(path, i)
+	return err
+}
+
+func (d *JsonContentDatabase) Write(path string, i interface{}, author string, message string) error {
+	_, err := d.WriteVersion(path, i, db.AnyVersion, author, message)
+	return err
+}
+
+func (d *JsonContentDatabase) ReadVersion(path string, i interface{}) (string, error) {
+	data, version, err := d.watcher.Read(path)
 	if err != nil {
-		return err
+		return version, err
 	}
-	return json.Unmarshal(data, i)
+	return version, json.Unmarshal(data, i)
 }
 
-func (d *JsonContentDatabase) Write(path string, i interface{}, message string) error {
-	filename := filepath.Join(d.rootPath, path)
+func (d *JsonContentDatabase) WriteVersion(path string, i interface{}, version string, author string,
+	message string) (string, error) {
 	bytes, err := json.Marshal(i)
 	if err != nil {
-		return err
+		return "", err
 	}
-	err = ioutil.WriteFile(filename, bytes, 0644)
-	if err != nil {
-		return err
+	return d.watcher.Write(path, bytes, version)
+}
+
+// Watch raises db.EventDataChanged when a file is changed outside of the api server, so that the data is
+// reloaded. It blocks until the supplied channel is closed
+func (d *JsonContentDatabase) Watch(interval time.Duration, stop <-chan struct{}) {
+	d.watcher.Watch(interval, stop, func(path string) {
+		log.Printf("INFO: %s is changed outside of the api server\n", path)
+		if err := d.processor.RaiseEvent(&db.EventDataChanged{Path: path}); err != nil {
+			log.Printf("WARN: could not raise event: %v\n", err)
+		}
+	})
+}
+
+// New creates a new json database where all files are located in the supplied root path
+func New(rootPath string, processor *event.Processor) *JsonContentDatabase {
+	return &JsonContentDatabase{
+		rootPath:  rootPath,
+		watcher:   db.NewFileWatcher(rootPath),
+		processor: processor,
 	}
-	return nil
 }
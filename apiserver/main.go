@@ -1,31 +1,223 @@
 package main
 
 import (
-	"github.com/westcoastcode-se/gitgo/api"
+	"context"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/apiserver/boltdb"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"github.com/westcoastcode-se/gitgo/apiserver/eventlog"
+	"github.com/westcoastcode-se/gitgo/apiserver/gitdb"
+	"github.com/westcoastcode-se/gitgo/apiserver/invalidation"
+	"github.com/westcoastcode-se/gitgo/apiserver/jsondb"
+	"github.com/westcoastcode-se/gitgo/apiserver/organization"
+	"github.com/westcoastcode-se/gitgo/apiserver/pki"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/role"
 	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/token"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
 	"github.com/westcoastcode-se/gitgo/apiserver/web"
+	"github.com/westcoastcode-se/gitgo/apiserver/webhook"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	_ = api.User{
-		Name:       "",
-		Password:   "",
-		PublicKeys: nil,
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(server.LoadConfig(), os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "pki" {
+		os.Exit(runPKI(server.LoadConfig(), os.Args[2:]))
 	}
 
 	log.Println("INFO: Starting GitGo")
 	cfg := server.LoadConfig()
 	var err error
 
-	webServer, err := web.NewServer(cfg)
+	eventLog, err := eventlog.New(cfg.EventLogPath)
+	if err != nil {
+		log.Fatalf("ERROR: Could not open the event log: %v", err)
+	}
+	processor := event.NewProcessor(cfg.EventWorkers, cfg.EventQueueSize, eventLog)
+	contentDatabase, err := openDatabase(cfg, processor)
+	if err != nil {
+		log.Fatalf("ERROR: Could not open the database: %v", err)
+	}
+	users, err := user.New(contentDatabase, processor)
+	if err != nil {
+		log.Fatalf("ERROR: Could not load users: %v", err)
+	}
+	password, err := users.Bootstrap(cfg.BootstrapUser)
+	if err != nil {
+		log.Fatalf("ERROR: Could not create the initial administrator: %v", err)
+	}
+	if len(password) > 0 {
+		log.Printf("INFO: Created administrator %s with password %s\n", cfg.BootstrapUser, password)
+	}
+
+	repositories, err := repository.New(contentDatabase, cfg.RepositoryPath, cfg.GitPath, cfg.DefaultBranch,
+		processor)
+	if err != nil {
+		log.Fatalf("ERROR: Could not load repositories: %v", err)
+	}
+
+	tokens, err := token.New(contentDatabase)
+	if err != nil {
+		log.Fatalf("ERROR: Could not load tokens: %v", err)
+	}
+
+	addresses, err := webhook.NewAddressPolicy(cfg.WebhookAllowedNetworks)
+	if err != nil {
+		log.Fatalf("ERROR: Could not read the networks webhooks are allowed to use: %v", err)
+	}
+	webhooks, err := webhook.New(contentDatabase, addresses)
+	if err != nil {
+		log.Fatalf("ERROR: Could not load webhooks: %v", err)
+	}
+	go webhook.NewSender(webhooks, cfg.WebhookTimeout, addresses).Run()
+
+	organizations, err := organization.New(contentDatabase, processor, users)
+	if err != nil {
+		log.Fatalf("ERROR: Could not load organizations: %v", err)
+	}
+
+	roles, err := role.New(contentDatabase, processor)
+	if err != nil {
+		log.Fatalf("ERROR: Could not load roles: %v", err)
+	}
+
+	certificates, err := openCertificates(cfg, contentDatabase, processor)
+	if err != nil {
+		log.Fatalf("ERROR: Could not load the certificate authority: %v", err)
+	}
+	go pki.NewMonitor(certificates, cfg.CertificateWarning, cfg.CertPath).Run()
+
+	// Repositories created before repositories were namespaced are moved into the administrator's namespace
+	err = repositories.MigrateNamespace(db.SystemAuthor, cfg.BootstrapUser,
+		func(tx db.Transaction, oldName string, newName string) error {
+			if err := users.RenameRepository(tx, oldName, newName); err != nil {
+				return err
+			}
+			return webhooks.RenameRepository(tx, oldName, newName)
+		})
+	if err != nil {
+		log.Fatalf("ERROR: Could not move repositories into the namespace of %s: %v", cfg.BootstrapUser, err)
+	}
+
+	invalidations := invalidation.NewBroker()
+	processor.Subscribe(users, &db.EventDataChanged{})
+	processor.Subscribe(repositories, &db.EventDataChanged{})
+	processor.Subscribe(tokens, &db.EventDataChanged{})
+	processor.Subscribe(organizations, &db.EventDataChanged{})
+	processor.Subscribe(roles, &db.EventDataChanged{})
+	processor.Subscribe(certificates, &db.EventDataChanged{})
+	processor.AddListener(webhooks)
+	processor.Subscribe(invalidations, &db.EventDataChanged{}, &user.EventUserAdded{}, &user.EventUserRemoved{},
+		&user.EventUserChanged{}, &user.EventPublicKeyAdded{}, &user.EventPublicKeyRemoved{},
+		&organization.EventOrganizationChanged{}, &role.EventRoleChanged{}, &role.EventRoleRemoved{},
+		&repository.EventRepositoryDeleted{}, &repository.EventRepositoryRestored{},
+		&repository.EventRepositoryPurged{}, &repository.EventRepositoryRenamed{})
+
+	stop := make(chan struct{})
+	if watched, ok := contentDatabase.(db.WatchedDatabase); ok {
+		go watched.Watch(cfg.DatabaseWatchInterval, stop)
+	}
+
+	webServer, err := web.NewServer(cfg, processor, eventLog, contentDatabase, users, organizations,
+		roles, repositories, tokens, webhooks, certificates, invalidations)
 	if err != nil {
 		log.Fatalf("ERROR: Could not create web server: %v", err)
 	}
+	go webServer.WatchCertificates(cfg.CertificateWatchInterval, stop)
+
+	// Certificates are renewed without restarting the server by sending SIGHUP
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		for range signals {
+			log.Println("INFO: Reloading the certificates")
+			if err := webServer.ReloadCertificates(); err != nil {
+				log.Printf("WARN: Could not reload the certificates: %v\n", err)
+			}
+			if err := certificates.UpdateCRL(); err != nil {
+				log.Printf("WARN: Could not update the certificate revocation list: %v\n", err)
+			}
+		}
+	}()
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Println("INFO: Stopping the web server")
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := webServer.Shutdown(ctx); err != nil {
+			log.Printf("WARN: Could not stop the web server gracefully: %v\n", err)
+		}
+	}()
 
 	err = webServer.ServeTLS()
 	if err != nil {
 		log.Fatalf("ERROR: Could not start web server. %e\n", err)
 	}
+
+	close(stop)
+	log.Println("INFO: Processing the remaining events")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err = processor.Shutdown(ctx); err != nil {
+		log.Printf("WARN: Could not process all events: %v\n", err)
+	}
+	if err = eventLog.Close(); err != nil {
+		log.Printf("WARN: Could not close the event log: %v\n", err)
+	}
+	if closer, ok := contentDatabase.(io.Closer); ok {
+		if err = closer.Close(); err != nil {
+			log.Printf("WARN: Could not close the database: %v\n", err)
+		}
+	}
 	log.Println("INFO: Shutting the server down")
 }
+
+// openDatabase opens the database configured by the supplied config
+func openDatabase(cfg server.Config, processor *event.Processor) (db.ContentDatabase, error) {
+	switch cfg.DatabaseType {
+	case server.DatabaseTypeGit:
+		return gitdb.New(cfg.DatabasePath, cfg.GitPath, processor)
+	case server.DatabaseTypeJson:
+		return jsondb.New(cfg.DatabasePath, processor), nil
+	case server.DatabaseTypeBolt:
+		return boltdb.New(cfg.BoltPath)
+	}
+	return nil, fmt.Errorf("unknown database type %s", cfg.DatabaseType)
+}
+
+// migrate imports the json files in the supplied directory, or the database path if no directory is supplied,
+// into the embedded key-value store. The api server must not be running while the data is migrated
+func migrate(cfg server.Config, args []string) int {
+	rootPath := cfg.DatabasePath
+	if len(args) > 0 {
+		rootPath = args[0]
+	}
+
+	database, err := boltdb.New(cfg.BoltPath)
+	if err != nil {
+		log.Printf("ERROR: Could not open the database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	paths, err := database.Import(rootPath, db.SystemAuthor)
+	if err != nil {
+		log.Printf("ERROR: Could not import %s: %v\n", rootPath, err)
+		return 1
+	}
+	log.Printf("INFO: Imported %d files from %s into %s. Set DatabaseType to %s to use them\n", len(paths), rootPath,
+		cfg.BoltPath, server.DatabaseTypeBolt)
+	return 0
+}
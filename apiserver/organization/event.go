@@ -0,0 +1,18 @@
+package organization
+
+// EventOrganizationCreated raised when a new organization is created
+type EventOrganizationCreated struct {
+	Organization *Organization
+}
+
+// EventOrganizationRemoved raised when an organization is removed
+type EventOrganizationRemoved struct {
+	Organization *Organization
+}
+
+// EventOrganizationChanged raised when the members, teams or team access of an organization is changed. Users
+// contains the users whose access might have changed
+type EventOrganizationChanged struct {
+	Organization *Organization
+	Users        []string
+}
@@ -0,0 +1,113 @@
+package organization
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"sort"
+	"time"
+)
+
+type Organizations struct {
+	Organizations []*Organization
+}
+
+type Organization struct {
+	Name        string
+	Description string
+	CreatedAt   time.Time
+
+	// Members contains the role of each member of the organization
+	Members map[string]api.OrganizationRole
+
+	// Teams contains the teams in the organization
+	Teams []*Team
+}
+
+type Team struct {
+	Name        string
+	Description string
+
+	// Members contains the names of the users in the team. Only members of the organization can be in a team
+	Members []string
+
+	// Repositories contains the access level the team has been granted for each repository. Only
+	// repositories owned by the organization can be granted
+	Repositories map[string]api.Access
+}
+
+func (o *Organization) ToApi() *api.Organization {
+	return &api.Organization{
+		Name:        o.Name,
+		Description: o.Description,
+		CreatedAt:   o.CreatedAt,
+	}
+}
+
+// MembersToApi converts the members into an api representation, sorted by name
+func (o *Organization) MembersToApi() *api.OrganizationMembers {
+	result := &api.OrganizationMembers{Members: []api.OrganizationMember{}}
+	for name, role := range o.Members {
+		result.Members = append(result.Members, api.OrganizationMember{User: name, Role: role})
+	}
+	sort.Slice(result.Members, func(i, j int) bool {
+		return result.Members[i].User < result.Members[j].User
+	})
+	return result
+}
+
+// IsOwner checks if the supplied user is an owner of the organization
+func (o *Organization) IsOwner(user string) bool {
+	return o.Members[user] == api.OrganizationRoleOwner
+}
+
+// IsMember checks if the supplied user is a member, or an owner, of the organization
+func (o *Organization) IsMember(user string) bool {
+	_, ok := o.Members[user]
+	return ok
+}
+
+// FindTeam finds a team in the organization. Returns nil if no team is found
+func (o *Organization) FindTeam(name string) *Team {
+	for _, team := range o.Teams {
+		if team.Name == name {
+			return team
+		}
+	}
+	return nil
+}
+
+// countOwners counts how many owners the organization has
+func (o *Organization) countOwners() int {
+	count := 0
+	for _, role := range o.Members {
+		if role == api.OrganizationRoleOwner {
+			count++
+		}
+	}
+	return count
+}
+
+func (t *Team) ToApi(organization string) *api.Team {
+	members := append([]string{}, t.Members...)
+	sort.Strings(members)
+	repositories := map[string]api.Access{}
+	for repository, access := range t.Repositories {
+		repositories[repository] = access
+	}
+	return &api.Team{
+		Organization: organization,
+		Name:         t.Name,
+		Description:  t.Description,
+		Members:      members,
+		Repositories: repositories,
+	}
+}
+
+// HasMember checks if the supplied user is a member of the team
+func (t *Team) HasMember(user string) bool {
+	for _, member := range t.Members {
+		if member == user {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,595 @@
+package organization
+
+import (
+	"errors"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DatabasePath = "/organizations.json"
+
+var (
+	OrganizationNotFoundError      = errors.New("organization not found")
+	OrganizationAlreadyExistsError = errors.New("organization already exists")
+	InvalidNameError               = errors.New("name is not valid")
+	InvalidRoleError               = errors.New("unknown role")
+	InvalidAccessError             = errors.New("unknown access level")
+	LastOwnerError                 = errors.New("at least one owner is required")
+	NotMemberError                 = errors.New("user is not a member of the organization")
+	TeamNotFoundError              = errors.New("team not found")
+	TeamAlreadyExistsError         = errors.New("team already exists")
+	RepositoryNotOwnedError        = errors.New("repository is not owned by the organization")
+)
+
+type Database interface {
+	// Database reloads itself when the underlying data is changed
+	event.Listener
+
+	// Organizations share the namespace with users, since both can own repositories
+	user.NamespaceOwner
+
+	// CreateOrganization creates a new organization where the supplied user is the only owner.
+	// user.UserAlreadyExistsError is returned if a user has the same name
+	CreateOrganization(author string, organization *Organization, owner string) error
+
+	// GetOrganizations fetches all organizations
+	GetOrganizations() []*Organization
+
+	// GetOrganization fetches an organization with the supplied name. Returns nil if no organization is found
+	GetOrganization(name string) *Organization
+
+	// RemoveOrganization removes an organization together with its teams
+	RemoveOrganization(author string, name string) error
+
+	// SetMember adds a user to an organization, or changes the role of an existing member
+	SetMember(author string, name string, member string, role api.OrganizationRole) error
+
+	// RemoveMember removes a user from an organization and all its teams
+	RemoveMember(author string, name string, member string) error
+
+	// RemoveUser removes a user that no longer exists from all organizations. Organizations where the user was the
+	// only owner are kept, so that an administrator can assign a new owner
+	RemoveUser(author string, name string) error
+
+	// CreateTeam creates a new team in an organization
+	CreateTeam(author string, name string, team *Team) error
+
+	// RemoveTeam removes a team from an organization
+	RemoveTeam(author string, name string, team string) error
+
+	// AddTeamMember adds a member of the organization to one of its teams
+	AddTeamMember(author string, name string, team string, member string) error
+
+	// RemoveTeamMember removes a user from a team
+	RemoveTeamMember(author string, name string, team string, member string) error
+
+	// SetTeamAccess grants a team access to a repository owned by the organization. The access is revoked
+	// if it's api.AccessNone
+	SetTeamAccess(author string, name string, team string, repository string, access api.Access) error
+
+	// RenameRepository moves all access granted to a repository so that it's granted to the new repository name.
+	// The access is revoked if the repository is transferred to another owner. The organizations are written in the
+	// supplied transaction, and are changed once it's stored
+	RenameRepository(tx db.Transaction, oldName string, newName string) error
+
+	// RemoveRepository revokes all access granted to a removed repository. The organizations are written in the
+	// supplied transaction, and are changed once it's stored
+	RemoveRepository(tx db.Transaction, name string) error
+
+	// GetTeams returns the teams the supplied user is a member of, in the form "{organization}/{team}"
+	GetTeams(user string) []string
+
+	// AddPermissions adds the roles and access the supplied user is granted as a member of organizations, and as
+	// a member of teams, to the supplied permissions
+	AddPermissions(user string, permissions *server.Permissions)
+}
+
+type DatabaseImpl struct {
+	// Database is a generic json database
+	contentDatabase db.ContentDatabase
+
+	// processor is used when raising events about changed organizations
+	processor *event.Processor
+
+	// users is used to make sure that a name is never used by both a user and an organization
+	users user.Database
+
+	organizations []*Organization
+	mutex         *sync.RWMutex
+
+	// version of the organizations file when it was last read or written
+	version string
+}
+
+func (d *DatabaseImpl) CreateOrganization(author string, organization *Organization, owner string) error {
+	if !user.IsValidName(organization.Name) {
+		return InvalidNameError
+	}
+	return d.users.ClaimName(organization.Name, func() error {
+		return d.createOrganization(author, organization, owner)
+	})
+}
+
+func (d *DatabaseImpl) OwnsName(name string) bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.findOrganization(name) != nil
+}
+
+func (d *DatabaseImpl) createOrganization(author string, organization *Organization, owner string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.findOrganization(organization.Name) != nil {
+		return OrganizationAlreadyExistsError
+	}
+	organization.CreatedAt = time.Now()
+	organization.Members = map[string]api.OrganizationRole{owner: api.OrganizationRoleOwner}
+	organization.Teams = []*Team{}
+	d.organizations = append(d.organizations, organization)
+	err := d.write(author, fmt.Sprintf("creating organization %s", organization.Name))
+	if err != nil {
+		d.organizations = d.organizations[:len(d.organizations)-1]
+		return err
+	}
+	d.raiseEvent(&EventOrganizationCreated{Organization: copyOrganization(organization)})
+	d.raiseEvent(&EventOrganizationChanged{Organization: copyOrganization(organization), Users: []string{owner}})
+	return nil
+}
+
+func (d *DatabaseImpl) GetOrganizations() []*Organization {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	result := make([]*Organization, len(d.organizations))
+	for i, organization := range d.organizations {
+		result[i] = copyOrganization(organization)
+	}
+	return result
+}
+
+func (d *DatabaseImpl) GetOrganization(name string) *Organization {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if organization := d.findOrganization(name); organization != nil {
+		return copyOrganization(organization)
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) RemoveOrganization(author string, name string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i, organization := range d.organizations {
+		if organization.Name != name {
+			continue
+		}
+		previous := d.organizations
+		d.organizations = append(append([]*Organization{}, d.organizations[:i]...), d.organizations[i+1:]...)
+		if err := d.write(author, fmt.Sprintf("removing organization %s", name)); err != nil {
+			d.organizations = previous
+			return err
+		}
+		d.raiseEvent(&EventOrganizationRemoved{Organization: copyOrganization(organization)})
+		d.raiseEvent(&EventOrganizationChanged{Organization: copyOrganization(organization),
+			Users: members(organization)})
+		return nil
+	}
+	return OrganizationNotFoundError
+}
+
+func (d *DatabaseImpl) SetMember(author string, name string, member string, role api.OrganizationRole) error {
+	if !role.IsValid() {
+		return InvalidRoleError
+	}
+	return d.change(author, name, []string{member}, fmt.Sprintf("adding %s to organization %s as %s", member,
+		name, role), func(organization *Organization) error {
+		if organization.IsOwner(member) && role != api.OrganizationRoleOwner && organization.countOwners() == 1 {
+			return LastOwnerError
+		}
+		organization.Members[member] = role
+		return nil
+	})
+}
+
+func (d *DatabaseImpl) RemoveMember(author string, name string, member string) error {
+	return d.change(author, name, []string{member}, fmt.Sprintf("removing %s from organization %s", member, name),
+		func(organization *Organization) error {
+			if !organization.IsMember(member) {
+				return NotMemberError
+			}
+			if organization.IsOwner(member) && organization.countOwners() == 1 {
+				return LastOwnerError
+			}
+			removeMember(organization, member)
+			return nil
+		})
+}
+
+func (d *DatabaseImpl) CreateTeam(author string, name string, team *Team) error {
+	if !user.IsValidName(team.Name) {
+		return InvalidNameError
+	}
+	return d.change(author, name, nil, fmt.Sprintf("creating team %s in organization %s", team.Name, name),
+		func(organization *Organization) error {
+			if organization.FindTeam(team.Name) != nil {
+				return TeamAlreadyExistsError
+			}
+			team.Members = []string{}
+			team.Repositories = map[string]api.Access{}
+			organization.Teams = append(organization.Teams, team)
+			return nil
+		})
+}
+
+func (d *DatabaseImpl) RemoveTeam(author string, name string, team string) error {
+	return d.change(author, name, nil, fmt.Sprintf("removing team %s from organization %s", team, name),
+		func(organization *Organization) error {
+			for i, t := range organization.Teams {
+				if t.Name == team {
+					organization.Teams = append(organization.Teams[:i:i], organization.Teams[i+1:]...)
+					return nil
+				}
+			}
+			return TeamNotFoundError
+		})
+}
+
+func (d *DatabaseImpl) AddTeamMember(author string, name string, team string, member string) error {
+	return d.change(author, name, []string{member}, fmt.Sprintf("adding %s to team %s in organization %s", member,
+		team, name), func(organization *Organization) error {
+		t := organization.FindTeam(team)
+		if t == nil {
+			return TeamNotFoundError
+		}
+		if !organization.IsMember(member) {
+			return NotMemberError
+		}
+		if !t.HasMember(member) {
+			t.Members = append(t.Members, member)
+		}
+		return nil
+	})
+}
+
+func (d *DatabaseImpl) RemoveTeamMember(author string, name string, team string, member string) error {
+	return d.change(author, name, []string{member}, fmt.Sprintf("removing %s from team %s in organization %s",
+		member, team, name), func(organization *Organization) error {
+		t := organization.FindTeam(team)
+		if t == nil {
+			return TeamNotFoundError
+		}
+		if !t.HasMember(member) {
+			return NotMemberError
+		}
+		t.Members = without(t.Members, member)
+		return nil
+	})
+}
+
+func (d *DatabaseImpl) SetTeamAccess(author string, name string, team string, repository string,
+	access api.Access) error {
+	switch access {
+	case api.AccessNone, api.AccessRead, api.AccessWrite, api.AccessAdmin:
+	default:
+		return InvalidAccessError
+	}
+	if !strings.HasPrefix(repository, name+"/") {
+		return RepositoryNotOwnedError
+	}
+	return d.change(author, name, nil, fmt.Sprintf("granting team %s in organization %s %s access to %s", team,
+		name, access, repository), func(organization *Organization) error {
+		t := organization.FindTeam(team)
+		if t == nil {
+			return TeamNotFoundError
+		}
+		if access == api.AccessNone {
+			delete(t.Repositories, repository)
+		} else {
+			t.Repositories[repository] = access
+		}
+		return nil
+	})
+}
+
+func (d *DatabaseImpl) RenameRepository(tx db.Transaction, oldName string, newName string) error {
+	return d.moveAccess(tx, oldName, newName)
+}
+
+func (d *DatabaseImpl) RemoveRepository(tx db.Transaction, name string) error {
+	return d.moveAccess(tx, name, "")
+}
+
+// moveAccess moves all access granted to a repository to its new name. The access is revoked if the new name is
+// empty, or if it's owned by someone else than the organization
+func (d *DatabaseImpl) moveAccess(tx db.Transaction, oldName string, newName string) error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var changed []*Organization
+	organizations := make([]*Organization, len(d.organizations))
+	for i, organization := range d.organizations {
+		organizations[i] = organization
+		if !hasTeamAccess(organization, oldName) {
+			continue
+		}
+		renamed := copyOrganization(organization)
+		for _, team := range renamed.Teams {
+			if access, ok := team.Repositories[oldName]; ok {
+				delete(team.Repositories, oldName)
+				if strings.HasPrefix(newName, organization.Name+"/") {
+					team.Repositories[newName] = access
+				}
+			}
+		}
+		organizations[i] = renamed
+		changed = append(changed, renamed)
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return tx.WriteVersion(DatabasePath, &Organizations{organizations}, d.version, func(version string) {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		d.organizations = organizations
+		d.version = version
+		for _, organization := range changed {
+			d.raiseEvent(&EventOrganizationChanged{Organization: copyOrganization(organization),
+				Users: members(organization)})
+		}
+	})
+}
+
+func (d *DatabaseImpl) GetTeams(user string) []string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	result := []string{}
+	for _, organization := range d.organizations {
+		for _, team := range organization.Teams {
+			if team.HasMember(user) {
+				result = append(result, organization.Name+"/"+team.Name)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (d *DatabaseImpl) AddPermissions(user string, permissions *server.Permissions) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	// The maps are copied, since they might be shared with the user
+	repositories := map[string]api.Access{}
+	for repository, access := range permissions.Repositories {
+		repositories[repository] = access
+	}
+	namespaces := map[string]api.Access{}
+	for namespace, access := range permissions.Namespaces {
+		namespaces[namespace] = access
+	}
+
+	organizations := map[string]api.OrganizationRole{}
+	for _, organization := range d.organizations {
+		if role, ok := organization.Members[user]; ok {
+			organizations[organization.Name] = role
+		}
+		if organization.IsOwner(user) {
+			namespaces[organization.Name] = api.AccessAdmin
+		}
+		for _, team := range organization.Teams {
+			if !team.HasMember(user) {
+				continue
+			}
+			for repository, access := range team.Repositories {
+				if !repositories[repository].Allows(access) {
+					repositories[repository] = access
+				}
+			}
+		}
+	}
+	permissions.Repositories = repositories
+	permissions.Namespaces = namespaces
+	permissions.Organizations = organizations
+}
+
+func (d *DatabaseImpl) OnEvent(e event.Event) error {
+	switch evt := e.(type) {
+	case *db.EventDataChanged:
+		if evt.Path == DatabasePath {
+			return d.reload()
+		}
+	}
+	return nil
+}
+
+// change applies the supplied function to an organization and saves it. The changes are reverted if the function,
+// or the save, fails
+func (d *DatabaseImpl) change(author string, name string, affected []string, message string,
+	fn func(organization *Organization) error) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	organization := d.findOrganization(name)
+	if organization == nil {
+		return OrganizationNotFoundError
+	}
+	previous := copyOrganization(organization)
+	if err := fn(organization); err != nil {
+		*organization = *previous
+		return err
+	}
+	if err := d.write(author, message); err != nil {
+		*organization = *previous
+		return err
+	}
+	if affected == nil {
+		affected = members(organization)
+	}
+	d.raiseEvent(&EventOrganizationChanged{Organization: copyOrganization(organization), Users: affected})
+	return nil
+}
+
+func (d *DatabaseImpl) RemoveUser(author string, name string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var changed []*Organization
+	for _, organization := range d.organizations {
+		if organization.IsMember(name) {
+			removeMember(organization, name)
+			changed = append(changed, organization)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := d.write(author, fmt.Sprintf("removing user %s from all organizations", name)); err != nil {
+		return err
+	}
+	for _, organization := range changed {
+		if organization.countOwners() == 0 {
+			log.Printf("WARN: organization %s has no owners left\n", organization.Name)
+		}
+		d.raiseEvent(&EventOrganizationChanged{Organization: copyOrganization(organization), Users: []string{name}})
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) findOrganization(name string) *Organization {
+	for _, organization := range d.organizations {
+		if organization.Name == name {
+			return organization
+		}
+	}
+	return nil
+}
+
+// write the organizations, if nobody else has changed the file since it was read. The mutex must be locked
+// by the caller
+func (d *DatabaseImpl) write(author string, message string) error {
+	version, err := d.contentDatabase.WriteVersion(DatabasePath, &Organizations{d.organizations}, d.version, author,
+		message)
+	if err != nil {
+		return err
+	}
+	d.version = version
+	return nil
+}
+
+func (d *DatabaseImpl) reload() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var organizations Organizations
+	version, err := d.contentDatabase.ReadVersion(DatabasePath, &organizations)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.version = version
+		}
+		return err
+	}
+	d.version = version
+	d.organizations = organizations.Organizations
+	return nil
+}
+
+func (d *DatabaseImpl) raiseEvent(e event.Event) {
+	if d.processor != nil {
+		if err := d.processor.RaiseEvent(e); err != nil {
+			log.Printf("WARN: could not raise event: %v\n", err)
+		}
+	}
+}
+
+// removeMember removes a user from an organization and all its teams
+func removeMember(organization *Organization, member string) {
+	delete(organization.Members, member)
+	for _, team := range organization.Teams {
+		team.Members = without(team.Members, member)
+	}
+}
+
+// members returns the names of all members of an organization
+func members(organization *Organization) []string {
+	result := make([]string, 0, len(organization.Members))
+	for name := range organization.Members {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func without(values []string, value string) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// hasTeamAccess checks if any team in the organization is granted access to the supplied repository
+func hasTeamAccess(organization *Organization, repository string) bool {
+	for _, team := range organization.Teams {
+		if _, ok := team.Repositories[repository]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// copyOrganization creates a deep copy of the supplied organization, so that it can be returned, or sent in an
+// event, without being changed afterwards
+func copyOrganization(organization *Organization) *Organization {
+	result := *organization
+	result.Members = map[string]api.OrganizationRole{}
+	for name, role := range organization.Members {
+		result.Members[name] = role
+	}
+	result.Teams = make([]*Team, len(organization.Teams))
+	for i, team := range organization.Teams {
+		t := *team
+		t.Members = append([]string{}, team.Members...)
+		t.Repositories = map[string]api.Access{}
+		for repository, access := range team.Repositories {
+			t.Repositories[repository] = access
+		}
+		result.Teams[i] = &t
+	}
+	return &result
+}
+
+func New(database db.ContentDatabase, processor *event.Processor, users user.Database) (Database, error) {
+	result := &DatabaseImpl{
+		contentDatabase: database,
+		processor:       processor,
+		users:           users,
+		organizations:   []*Organization{},
+		mutex:           &sync.RWMutex{},
+		version:         db.MissingVersion,
+	}
+
+	if err := db.AddCollection(database, DatabasePath, db.Collection{Field: "Organizations", Key: "Name"}); err != nil {
+		return nil, err
+	}
+	if err := result.reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	users.AddNamespaceOwner(result)
+	return result, nil
+}
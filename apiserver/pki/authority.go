@@ -0,0 +1,204 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Authority is the certificate authority used when issuing certificates and signing revocation lists
+type Authority struct {
+	Certificate *x509.Certificate
+
+	// key is nil if the private key is not available, in which case nothing can be signed
+	key crypto.Signer
+}
+
+// CanSign checks if the private key of the certificate authority is available
+func (a *Authority) CanSign() bool {
+	return a.key != nil
+}
+
+// PEM returns the PEM encoded certificate of the certificate authority
+func (a *Authority) PEM() []byte {
+	return encodeCertificate(a.Certificate.Raw)
+}
+
+// sign creates a certificate from the supplied template, signed by the certificate authority
+func (a *Authority) sign(template *x509.Certificate, publicKey crypto.PublicKey) (*x509.Certificate, error) {
+	if a.key == nil {
+		return nil, AuthorityKeyMissingError
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.Certificate, publicKey, a.key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// signCRL creates a revocation list, signed by the certificate authority
+func (a *Authority) signCRL(template *x509.RevocationList) ([]byte, error) {
+	if a.key == nil {
+		return nil, AuthorityKeyMissingError
+	}
+	return x509.CreateRevocationList(rand.Reader, template, a.Certificate, a.key)
+}
+
+// LoadAuthority reads the certificate authority from the supplied paths. The private key is optional, so that a
+// certificate authority managed outside of the api server can be used to verify certificates
+func LoadAuthority(certPath string, keyPath string) (*Authority, error) {
+	cert, err := readCertificate(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Authority{Certificate: cert}
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	if result.key, err = parsePrivateKey(data); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", keyPath, err)
+	}
+	return result, nil
+}
+
+// CreateAuthority creates a new self-signed certificate authority and writes it to the supplied paths
+func CreateAuthority(certPath string, keyPath string, commonName string, validity time.Duration) (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := subjectKeyID(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"GitGo"}},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          keyID,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err = writeFile(certPath, encodeCertificate(der), 0644); err != nil {
+		return nil, err
+	}
+	return &Authority{Certificate: cert, key: key}, nil
+}
+
+// readCertificate reads a PEM encoded certificate from the supplied path
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// newSerial generates a random 128 bit serial number
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// subjectKeyID calculates the identifier of the supplied public key, as described in RFC 5280
+func subjectKeyID(publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum(der)
+	return hash[:], nil
+}
+
+func encodeCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encodePrivateKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// parsePrivateKey parses a PEM encoded private key. PKCS #1, PKCS #8 and EC keys are supported, so that keys
+// created using openssl can be used
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key can't be used for signing")
+	}
+	return signer, nil
+}
+
+// writeFile writes the supplied data to a temporary file, which is then renamed, so that a crash never leaves a
+// half-written file behind
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,59 @@
+package pki
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"time"
+)
+
+type Certificates struct {
+	Certificates []*Certificate
+}
+
+// Certificate is a certificate issued by the certificate authority. The private key is never stored
+type Certificate struct {
+	Serial      string
+	CommonName  string
+	Type        api.CertificateType
+	DNSNames    []string
+	IPAddresses []string
+	IssuedBy    string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	RevokedAt   *time.Time
+	RevokedBy   string
+}
+
+// IsRevoked checks if the certificate is revoked
+func (c *Certificate) IsRevoked() bool {
+	return c.RevokedAt != nil
+}
+
+func (c *Certificate) ToApi() *api.Certificate {
+	return &api.Certificate{
+		Serial:      c.Serial,
+		CommonName:  c.CommonName,
+		Type:        c.Type,
+		DNSNames:    append([]string{}, c.DNSNames...),
+		IPAddresses: append([]string{}, c.IPAddresses...),
+		IssuedBy:    c.IssuedBy,
+		NotBefore:   c.NotBefore,
+		NotAfter:    c.NotAfter,
+		RevokedAt:   c.RevokedAt,
+		RevokedBy:   c.RevokedBy,
+	}
+}
+
+// KeyPair is a PEM encoded certificate together with its private key
+type KeyPair struct {
+	Certificate []byte
+	PrivateKey  []byte
+}
+
+// WriteFiles writes the certificate and the private key to the supplied paths. Only the owner is allowed to read
+// the private key
+func (k *KeyPair) WriteFiles(certPath string, keyPath string) error {
+	if err := writeFile(keyPath, k.PrivateKey, 0600); err != nil {
+		return err
+	}
+	return writeFile(certPath, k.Certificate, 0644)
+}
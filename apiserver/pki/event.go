@@ -0,0 +1,11 @@
+package pki
+
+// EventCertificateIssued raised when the certificate authority issues a certificate
+type EventCertificateIssued struct {
+	Certificate *Certificate
+}
+
+// EventCertificateRevoked raised when a certificate is revoked
+type EventCertificateRevoked struct {
+	Certificate *Certificate
+}
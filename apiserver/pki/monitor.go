@@ -0,0 +1,80 @@
+package pki
+
+import (
+	"log"
+	"time"
+)
+
+// Monitor warns about certificates that are about to expire and signs a new certificate revocation list before
+// the current one expires
+type Monitor struct {
+	Certificates Database
+
+	// Warning is how long before a certificate expires that warnings are logged
+	Warning time.Duration
+
+	// Interval is how often the certificates are checked
+	Interval time.Duration
+
+	// Paths contains certificates that are not issued using the database, such as the api server's own certificate
+	// if it's created outside of the api server
+	Paths []string
+
+	// crlUpdatedAt is when the certificate revocation list was last signed by the monitor
+	crlUpdatedAt time.Time
+}
+
+// Run checks the certificates until the application is stopped. It never returns
+func (m *Monitor) Run() {
+	m.check()
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.check()
+	}
+}
+
+func (m *Monitor) check() {
+	authority := m.Certificates.Authority().Certificate
+	m.warn("the certificate authority", authority.NotAfter)
+	for _, path := range m.Paths {
+		if cert, err := readCertificate(path); err != nil {
+			log.Printf("WARN: could not read %s: %v\n", path, err)
+		} else if cert.SerialNumber.Cmp(authority.SerialNumber) != 0 {
+			m.warn(path, cert.NotAfter)
+		}
+	}
+	for _, c := range m.Certificates.GetCertificates() {
+		if !c.IsRevoked() {
+			m.warn("certificate "+c.Serial+" for "+c.CommonName, c.NotAfter)
+		}
+	}
+
+	// A new list is signed long before the current one expires, so that a failure can be fixed in time
+	if time.Since(m.crlUpdatedAt) >= CRLValidity/7 {
+		if err := m.Certificates.UpdateCRL(); err != nil {
+			log.Printf("WARN: could not update the certificate revocation list: %v\n", err)
+		} else {
+			m.crlUpdatedAt = time.Now()
+		}
+	}
+}
+
+// warn logs a warning if something expires within the warning period
+func (m *Monitor) warn(name string, notAfter time.Time) {
+	if time.Now().After(notAfter) {
+		log.Printf("WARN: %s expired at %v\n", name, notAfter)
+	} else if time.Now().Add(m.Warning).After(notAfter) {
+		log.Printf("WARN: %s expires at %v\n", name, notAfter)
+	}
+}
+
+// NewMonitor creates a monitor that checks the certificates every hour
+func NewMonitor(certificates Database, warning time.Duration, paths ...string) *Monitor {
+	return &Monitor{
+		Certificates: certificates,
+		Warning:      warning,
+		Interval:     time.Hour,
+		Paths:        paths,
+	}
+}
@@ -0,0 +1,441 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const DatabasePath = "/certificates.json"
+
+// CRLValidity is how long a certificate revocation list is valid. A new list is signed long before it expires
+const CRLValidity = 7 * 24 * time.Hour
+
+var (
+	CertificateNotFoundError = errors.New("certificate not found")
+	AlreadyRevokedError      = errors.New("certificate is already revoked")
+	InvalidCommonNameError   = errors.New("common name is not valid")
+	InvalidTypeError         = errors.New("certificate type is not valid")
+	InvalidIPAddressError    = errors.New("ip address is not valid")
+	InvalidNotAfterError     = errors.New("certificate must expire in the future, but before the certificate authority")
+	AuthorityKeyMissingError = errors.New("the private key of the certificate authority is not available")
+)
+
+var validCommonNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-.]{1,64}$`)
+
+type Database interface {
+	// Database reloads itself when the underlying data is changed
+	event.Listener
+
+	// Authority returns the certificate authority used when issuing certificates
+	Authority() *Authority
+
+	// Issue creates a new certificate, and private key, signed by the certificate authority. The private key is
+	// never stored
+	Issue(author string, request *api.NewCertificate) (*Certificate, *KeyPair, error)
+
+	// GetCertificates fetches all certificates issued by the certificate authority
+	GetCertificates() []*Certificate
+
+	// GetCertificate fetches a certificate using its hex encoded serial number. Returns nil if no certificate
+	// is found
+	GetCertificate(serial string) *Certificate
+
+	// Revoke revokes a certificate and adds it to the certificate revocation list
+	Revoke(author string, serial string) error
+
+	// CRL returns the PEM encoded certificate revocation list. Returns nil if there's no list
+	CRL() []byte
+
+	// UpdateCRL signs a new certificate revocation list, so that it never expires. The list is read from the disk
+	// if the certificate authority can't sign it
+	UpdateCRL() error
+
+	// IsRevoked checks if the supplied certificate is found in the certificate revocation list
+	IsRevoked(certificate *x509.Certificate) bool
+}
+
+type DatabaseImpl struct {
+	// Database is a generic json database
+	contentDatabase db.ContentDatabase
+
+	// processor is used when raising events about issued and revoked certificates
+	processor *event.Processor
+
+	authority *Authority
+
+	// validity is how long an issued certificate is valid, unless something else is requested
+	validity time.Duration
+
+	// crlPath is where the certificate revocation list is written
+	crlPath string
+
+	certificates []*Certificate
+	crl          []byte
+	revocations  *pkix.CertificateList
+	mutex        *sync.RWMutex
+
+	// version of the certificates file when it was last read or written
+	version string
+}
+
+func (d *DatabaseImpl) Authority() *Authority {
+	return d.authority
+}
+
+func (d *DatabaseImpl) Issue(author string, request *api.NewCertificate) (*Certificate, *KeyPair, error) {
+	template, err := d.newTemplate(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := d.authority.sign(template, &key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &Certificate{
+		Serial:      fmt.Sprintf("%x", cert.SerialNumber),
+		CommonName:  request.CommonName,
+		Type:        request.Type,
+		DNSNames:    cert.DNSNames,
+		IPAddresses: append([]string{}, request.IPAddresses...),
+		IssuedBy:    author,
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.certificates = append(d.certificates, result)
+	err = d.write(author, fmt.Sprintf("issuing %s certificate %s for %s", result.Type, result.Serial,
+		result.CommonName))
+	if err != nil {
+		d.certificates = d.certificates[:len(d.certificates)-1]
+		return nil, nil, err
+	}
+	d.raiseEvent(&EventCertificateIssued{Certificate: copyCertificate(result)})
+	return copyCertificate(result), &KeyPair{Certificate: encodeCertificate(cert.Raw), PrivateKey: keyPEM}, nil
+}
+
+// newTemplate validates the supplied request and creates the template of the certificate
+func (d *DatabaseImpl) newTemplate(request *api.NewCertificate) (*x509.Certificate, error) {
+	if !validCommonNameRegex.MatchString(request.CommonName) {
+		return nil, InvalidCommonNameError
+	}
+	if !request.Type.IsValid() {
+		return nil, InvalidTypeError
+	}
+	var ips []net.IP
+	for _, value := range request.IPAddresses {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, InvalidIPAddressError
+		}
+		ips = append(ips, ip)
+	}
+
+	now := time.Now()
+	notAfter := now.Add(d.validity)
+	if request.NotAfter != nil {
+		notAfter = *request.NotAfter
+	}
+	if !notAfter.After(now) || notAfter.After(d.authority.Certificate.NotAfter) {
+		return nil, InvalidNotAfterError
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: request.CommonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if request.Type == api.CertificateTypeServer {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.DNSNames = request.DNSNames
+		template.IPAddresses = ips
+		if len(template.DNSNames) == 0 && len(ips) == 0 {
+			template.DNSNames = []string{request.CommonName}
+		}
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	return template, nil
+}
+
+func (d *DatabaseImpl) GetCertificates() []*Certificate {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	result := make([]*Certificate, len(d.certificates))
+	for i, c := range d.certificates {
+		result[i] = copyCertificate(c)
+	}
+	return result
+}
+
+func (d *DatabaseImpl) GetCertificate(serial string) *Certificate {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if c := d.findCertificate(serial); c != nil {
+		return copyCertificate(c)
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) Revoke(author string, serial string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	c := d.findCertificate(serial)
+	if c == nil {
+		return CertificateNotFoundError
+	}
+	if c.IsRevoked() {
+		return AlreadyRevokedError
+	}
+
+	// The revocation list is signed before anything is saved, so that a certificate is never considered revoked
+	// without being found in the list
+	now := time.Now()
+	c.RevokedAt, c.RevokedBy = &now, author
+	crl, err := d.createCRL()
+	if err == nil {
+		err = d.write(author, fmt.Sprintf("revoking certificate %s for %s", c.Serial, c.CommonName))
+	}
+	if err != nil {
+		c.RevokedAt, c.RevokedBy = nil, ""
+		return err
+	}
+	if err = d.applyCRL(crl); err != nil {
+		return err
+	}
+	d.raiseEvent(&EventCertificateRevoked{Certificate: copyCertificate(c)})
+	return nil
+}
+
+func (d *DatabaseImpl) CRL() []byte {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return append([]byte(nil), d.crl...)
+}
+
+func (d *DatabaseImpl) UpdateCRL() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.updateCRL()
+}
+
+// updateCRL signs a new certificate revocation list, or reads it from the disk if the certificate authority can't
+// sign it. The mutex must be locked by the caller
+func (d *DatabaseImpl) updateCRL() error {
+	if !d.authority.CanSign() {
+		data, err := ioutil.ReadFile(d.crlPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return d.setCRL(data)
+	}
+	crl, err := d.createCRL()
+	if err != nil {
+		return err
+	}
+	return d.applyCRL(crl)
+}
+
+// createCRL signs a PEM encoded revocation list containing all revoked certificates. The mutex must be locked
+// by the caller
+func (d *DatabaseImpl) createCRL() ([]byte, error) {
+	var revoked []pkix.RevokedCertificate
+	for _, c := range d.certificates {
+		if !c.IsRevoked() {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(c.Serial, 16)
+		if !ok {
+			log.Printf("WARN: certificate %s has an invalid serial number\n", c.Serial)
+			continue
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: *c.RevokedAt})
+	}
+
+	// The current time is used as the CRL number, since it must increase every time a new list is signed
+	now := time.Now()
+	der, err := d.authority.signCRL(&x509.RevocationList{
+		Number:              big.NewInt(now.UnixNano()),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(CRLValidity),
+		RevokedCertificates: revoked,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+// applyCRL writes the supplied revocation list to the disk and starts using it. The mutex must be locked by
+// the caller
+func (d *DatabaseImpl) applyCRL(crl []byte) error {
+	if len(d.crlPath) > 0 {
+		if err := writeFile(d.crlPath, crl, 0644); err != nil {
+			return err
+		}
+	}
+	return d.setCRL(crl)
+}
+
+// setCRL parses the supplied revocation list and starts using it. The mutex must be locked by the caller
+func (d *DatabaseImpl) setCRL(crl []byte) error {
+	list, err := x509.ParseCRL(crl)
+	if err != nil {
+		return err
+	}
+	d.crl = crl
+	d.revocations = list
+	return nil
+}
+
+func (d *DatabaseImpl) IsRevoked(certificate *x509.Certificate) bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.revocations == nil {
+		return false
+	}
+	for _, revoked := range d.revocations.TBSCertList.RevokedCertificates {
+		if revoked.SerialNumber.Cmp(certificate.SerialNumber) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DatabaseImpl) OnEvent(e event.Event) error {
+	switch evt := e.(type) {
+	case *db.EventDataChanged:
+		if evt.Path == DatabasePath {
+			if err := d.reload(); err != nil {
+				return err
+			}
+			// Certificates might have been revoked
+			if err := d.UpdateCRL(); err != nil {
+				log.Printf("WARN: could not update the certificate revocation list: %v\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) findCertificate(serial string) *Certificate {
+	for _, c := range d.certificates {
+		if c.Serial == serial {
+			return c
+		}
+	}
+	return nil
+}
+
+// write the certificates, if nobody else has changed the file since it was read. The mutex must be locked by
+// the caller
+func (d *DatabaseImpl) write(author string, message string) error {
+	version, err := d.contentDatabase.WriteVersion(DatabasePath, &Certificates{d.certificates}, d.version, author,
+		message)
+	if err != nil {
+		return err
+	}
+	d.version = version
+	return nil
+}
+
+func (d *DatabaseImpl) reload() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var certificates Certificates
+	version, err := d.contentDatabase.ReadVersion(DatabasePath, &certificates)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.version = version
+		}
+		return err
+	}
+	d.version = version
+	d.certificates = certificates.Certificates
+	return nil
+}
+
+func (d *DatabaseImpl) raiseEvent(e event.Event) {
+	if d.processor != nil {
+		if err := d.processor.RaiseEvent(e); err != nil {
+			log.Printf("WARN: could not raise event: %v\n", err)
+		}
+	}
+}
+
+func copyCertificate(c *Certificate) *Certificate {
+	result := *c
+	result.DNSNames = append([]string{}, c.DNSNames...)
+	result.IPAddresses = append([]string{}, c.IPAddresses...)
+	return &result
+}
+
+// New creates a database containing the certificates issued by the supplied certificate authority. Issued
+// certificates are valid for the supplied duration, unless something else is requested
+func New(database db.ContentDatabase, authority *Authority, crlPath string, validity time.Duration,
+	processor *event.Processor) (Database, error) {
+	result := &DatabaseImpl{
+		contentDatabase: database,
+		processor:       processor,
+		authority:       authority,
+		validity:        validity,
+		crlPath:         crlPath,
+		certificates:    []*Certificate{},
+		mutex:           &sync.RWMutex{},
+		version:         db.MissingVersion,
+	}
+
+	if err := db.AddCollection(database, DatabasePath, db.Collection{Field: "Certificates", Key: "Serial"}); err != nil {
+		return nil, err
+	}
+	if err := result.reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// Certificate authorities created outside of the api server might not be allowed to sign revocation lists
+	if err := result.UpdateCRL(); err != nil {
+		log.Printf("WARN: could not update the certificate revocation list: %v\n", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,44 @@
+package repository
+
+import "github.com/westcoastcode-se/gitgo/api"
+
+// EventRepositoryCreated raised when a new repository is created
+type EventRepositoryCreated struct {
+	Repository *Repository
+}
+
+// EventRepositoryDeleted raised when a repository is moved to the trash
+type EventRepositoryDeleted struct {
+	Repository *Repository
+}
+
+// EventRepositoryRestored raised when a repository is restored from the trash
+type EventRepositoryRestored struct {
+	Repository *Repository
+}
+
+// EventRepositoryPurged raised when a repository is permanently removed from the trash
+type EventRepositoryPurged struct {
+	Repository *Repository
+}
+
+// EventRepositoryRenamed raised when a repository is given a new name
+type EventRepositoryRenamed struct {
+	OldName    string
+	Repository *Repository
+}
+
+// EventRepositoryPushed raised when the git server has accepted a push to a repository
+type EventRepositoryPushed struct {
+	Repository string
+	User       string
+	Protocol   string
+	RefUpdates []api.RefUpdate
+}
+
+// EventRepositoryFetched raised when the git server has served a clone or fetch from a repository
+type EventRepositoryFetched struct {
+	Repository string
+	User       string
+	Protocol   string
+}
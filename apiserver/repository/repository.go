@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Repositories struct {
+	Repositories []*Repository
+}
+
+type Repository struct {
+	Name          string
+	Description   string
+	DefaultBranch string
+	CreatedAt     time.Time
+
+	// Deleted is set if the repository is moved to the trash
+	Deleted bool
+
+	// DeletedAt is when the repository was moved to the trash
+	DeletedAt time.Time
+
+	// TrashPath is the directory name, relative to the trash directory, where the repository is located
+	// when it's deleted
+	TrashPath string
+
+	// Protection contains the rules applied to every push to the repository
+	Protection api.RepositoryProtection
+}
+
+// ToApi converts this repository into an api representation. The path is where the repository is located on disk
+func (r *Repository) ToApi(path string) *api.Repository {
+	return &api.Repository{
+		Name:          r.Name,
+		Description:   r.Description,
+		DefaultBranch: r.DefaultBranch,
+		Size:          directorySize(path),
+		CreatedAt:     r.CreatedAt,
+		LastPush:      lastPush(path),
+		Deleted:       r.Deleted,
+	}
+}
+
+// validNameRegex doesn't allow names starting with a dash, so that a name is never mistaken for an option
+var validNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_\-.]*$`)
+
+// IsValidName checks if the supplied name can be used as a repository name. Repository names are in the form
+// "{owner}/{name}", where the owner is a user or an organization
+func IsValidName(name string) bool {
+	owner, repository, ok := SplitName(name)
+	return ok && isValidSegment(owner) && isValidSegment(repository)
+}
+
+// SplitName splits a repository name into its owner and its name within the owner's namespace
+func SplitName(name string) (string, string, bool) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// Owner returns the user or organization owning the supplied repository
+func Owner(name string) string {
+	owner, _, _ := SplitName(name)
+	return owner
+}
+
+// isValidSegment checks if the supplied part of a repository name is valid. Repositories created before
+// repositories were namespaced have names consisting of a single segment
+func isValidSegment(segment string) bool {
+	return validNameRegex.MatchString(segment) && !strings.Contains(segment, "..")
+}
+
+var validBranchRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-][a-zA-Z0-9_\-./]*$`)
+
+// IsValidBranch checks if the supplied name can be used as a default branch
+func IsValidBranch(name string) bool {
+	return validBranchRegex.MatchString(name) && !strings.Contains(name, "..") &&
+		!strings.HasSuffix(name, "/") && !strings.HasSuffix(name, ".lock")
+}
+
+// IsValidPattern checks if the supplied glob pattern can be used when protecting branches
+func IsValidPattern(pattern string) bool {
+	if len(pattern) == 0 || strings.HasPrefix(pattern, "/") || strings.HasSuffix(pattern, "/") {
+		return false
+	}
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// directorySize calculates the size of all files in the supplied directory
+func directorySize(path string) int64 {
+	var size int64
+	_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// lastPush figures out when a repository was last pushed to by looking at when the refs were last modified
+func lastPush(path string) *time.Time {
+	var result *time.Time
+	visit := func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t := info.ModTime()
+			if result == nil || t.After(*result) {
+				result = &t
+			}
+		}
+		return nil
+	}
+	_ = filepath.Walk(filepath.Join(path, "refs"), visit)
+	if info, err := os.Stat(filepath.Join(path, "packed-refs")); err == nil {
+		_ = visit("", info, nil)
+	}
+	return result
+}
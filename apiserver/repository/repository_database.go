@@ -0,0 +1,721 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DatabasePath = "/repositories.json"
+
+// TrashDirectory is the directory, relative to the repository path, where deleted repositories are moved to
+const TrashDirectory = ".trash"
+
+var (
+	RepositoryNotFoundError      = errors.New("repository not found")
+	RepositoryAlreadyExistsError = errors.New("repository already exists")
+	RepositoryNotDeletedError    = errors.New("repository is not deleted")
+	InvalidNameError             = errors.New("repository name is not valid")
+	InvalidBranchError           = errors.New("branch name is not valid")
+	InvalidPatternError          = errors.New("branch pattern is not valid")
+)
+
+type Database interface {
+	// Database reloads itself when the underlying data is changed
+	event.Listener
+
+	// CreateRepository creates a new bare repository
+	CreateRepository(author string, repository *Repository) error
+
+	// GetRepositories fetches a copy of all repositories, including the ones that are moved to the trash
+	GetRepositories() []*Repository
+
+	// GetRepository fetches a copy of the repository with the supplied name. Returns nil if no repository is found
+	GetRepository(name string) *Repository
+
+	// GetPath returns the path to where the supplied repository is located on disk
+	GetPath(repository *Repository) string
+
+	// UpdateRepository applies the supplied changes to a repository. If the repository is renamed, the supplied
+	// function is called so that data referring to the repository is changed in the same transaction
+	UpdateRepository(author string, name string, changes *api.RepositoryChanges, renamed RenamedFunc) (*Repository,
+		error)
+
+	// SetProtection replaces the rules applied to every push to a repository
+	SetProtection(author string, name string, protection *api.RepositoryProtection) (*Repository, error)
+
+	// DeleteRepository moves a repository to the trash. The supplied function is called so that data referring to
+	// the repository is removed in the same transaction
+	DeleteRepository(author string, name string, removed RemovedFunc) error
+
+	// RestoreRepository moves a repository out from the trash
+	RestoreRepository(author string, name string) error
+
+	// PurgeRepository permanently removes a repository that's moved to the trash. The supplied function is called
+	// so that data referring to the repository is removed in the same transaction
+	PurgeRepository(author string, name string, removed RemovedFunc) error
+
+	// MigrateNamespace moves repositories created before repositories were namespaced into the supplied owner's
+	// namespace. The supplied function is called for each moved repository, in the same transaction
+	MigrateNamespace(author string, owner string, renamed RenamedFunc) error
+}
+
+// RenamedFunc changes data that refers to a renamed repository in the transaction that renames it
+type RenamedFunc func(tx db.Transaction, oldName string, newName string) error
+
+// RemovedFunc removes data that refers to a removed repository in the transaction that removes it
+type RemovedFunc func(tx db.Transaction, name string) error
+
+type DatabaseImpl struct {
+	// Database is a generic json database
+	contentDatabase db.ContentDatabase
+
+	// repositoryPath is where all repositories are located
+	repositoryPath string
+
+	// gitPath points to the git executable
+	gitPath string
+
+	// defaultBranch is used if a new repository is created without a default branch
+	defaultBranch string
+
+	// processor is used when raising events about changed repositories
+	processor *event.Processor
+
+	repositories []*Repository
+	mutex        *sync.RWMutex
+
+	// version of the repositories file when it was last read or written
+	version string
+}
+
+func (d *DatabaseImpl) CreateRepository(author string, newRepository *Repository) error {
+	if !IsValidName(newRepository.Name) {
+		return InvalidNameError
+	}
+	if len(newRepository.DefaultBranch) == 0 {
+		newRepository.DefaultBranch = d.defaultBranch
+	}
+	if !IsValidBranch(newRepository.DefaultBranch) {
+		return InvalidBranchError
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.findRepository(newRepository.Name) != nil {
+		return RepositoryAlreadyExistsError
+	}
+	path := d.path(newRepository.Name)
+	if _, err := os.Stat(path); err == nil {
+		return RepositoryAlreadyExistsError
+	}
+
+	remove := func() {
+		_ = os.RemoveAll(path)
+		d.removeEmptyOwner(newRepository.Name)
+	}
+	if err := d.git("init", "--bare", path); err != nil {
+		remove()
+		return err
+	}
+	if err := d.git("--git-dir="+path, "symbolic-ref", "HEAD", "refs/heads/"+newRepository.DefaultBranch); err != nil {
+		remove()
+		return err
+	}
+	if err := writeDescription(path, newRepository.Description); err != nil {
+		remove()
+		return err
+	}
+
+	newRepository.CreatedAt = time.Now()
+	d.repositories = append(d.repositories, copyRepository(newRepository))
+	if err := d.save(author, fmt.Sprintf("creating repository %s", newRepository.Name)); err != nil {
+		d.repositories = d.repositories[:len(d.repositories)-1]
+		remove()
+		return err
+	}
+	d.raiseEvent(&EventRepositoryCreated{Repository: copyRepository(newRepository)})
+	return nil
+}
+
+func (d *DatabaseImpl) GetRepositories() []*Repository {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	result := make([]*Repository, len(d.repositories))
+	for i, repository := range d.repositories {
+		result[i] = copyRepository(repository)
+	}
+	return result
+}
+
+func (d *DatabaseImpl) GetRepository(name string) *Repository {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if repository := d.findRepository(name); repository != nil {
+		return copyRepository(repository)
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) GetPath(repository *Repository) string {
+	if repository.Deleted {
+		return filepath.Join(d.repositoryPath, TrashDirectory, repository.TrashPath)
+	}
+	return d.path(repository.Name)
+}
+
+func (d *DatabaseImpl) UpdateRepository(author string, name string, changes *api.RepositoryChanges,
+	renamed RenamedFunc) (*Repository, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	repository := d.findRepository(name)
+	if repository == nil || repository.Deleted {
+		return nil, RepositoryNotFoundError
+	}
+
+	// Everything is verified before the repository is changed, so that nothing has to be undone unless it can't
+	// be saved
+	renaming := changes.Name != nil && *changes.Name != repository.Name
+	if changes.DefaultBranch != nil && !IsValidBranch(*changes.DefaultBranch) {
+		return nil, InvalidBranchError
+	}
+	if renaming {
+		if !IsValidName(*changes.Name) {
+			return nil, InvalidNameError
+		}
+		if d.findRepository(*changes.Name) != nil {
+			return nil, RepositoryAlreadyExistsError
+		}
+		if _, err := os.Stat(d.path(*changes.Name)); err == nil {
+			return nil, RepositoryAlreadyExistsError
+		}
+	}
+
+	previous := *repository
+	path := d.GetPath(repository)
+	if changes.DefaultBranch != nil && *changes.DefaultBranch != repository.DefaultBranch {
+		if err := d.git("--git-dir="+path, "symbolic-ref", "HEAD", "refs/heads/"+*changes.DefaultBranch); err != nil {
+			return nil, err
+		}
+		repository.DefaultBranch = *changes.DefaultBranch
+	}
+
+	if changes.Description != nil && *changes.Description != repository.Description {
+		if err := writeDescription(path, *changes.Description); err != nil {
+			d.undo(repository, &previous)
+			return nil, err
+		}
+		repository.Description = *changes.Description
+	}
+
+	message := fmt.Sprintf("updating repository %s", name)
+	var moved map[string]string
+	if renaming {
+		newName := *changes.Name
+		newPath := d.path(newName)
+		if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+			d.undo(repository, &previous)
+			return nil, err
+		}
+		if err := os.Rename(path, newPath); err != nil {
+			d.undo(repository, &previous)
+			return nil, err
+		}
+		d.removeEmptyOwner(name)
+		repository.Name = newName
+		moved = map[string]string{name: newName}
+		message = fmt.Sprintf("renaming repository %s to %s", name, newName)
+	}
+
+	version := d.version
+	if err := d.saveWith(author, message, renameAll(moved, renamed)); err != nil {
+		// The changes are undone, unless they are already saved
+		if d.version == version {
+			if moved != nil {
+				newName := repository.Name
+				if moveErr := d.move(repository, name); moveErr != nil {
+					log.Printf("ERROR: could not move repository %s back to %s: %v\n", newName, name, moveErr)
+				} else {
+					d.removeEmptyOwner(newName)
+				}
+			}
+			d.undo(repository, &previous)
+		}
+		return nil, err
+	}
+	if moved != nil {
+		d.raiseEvent(&EventRepositoryRenamed{OldName: name, Repository: copyRepository(repository)})
+	}
+	return copyRepository(repository), nil
+}
+
+// undo restores the default branch and the description of a repository that's changed on disk, and then restores
+// the rest of the repository. The repository must be located where the previous repository was located
+func (d *DatabaseImpl) undo(repository *Repository, previous *Repository) {
+	path := d.GetPath(repository)
+	if repository.DefaultBranch != previous.DefaultBranch {
+		if err := d.git("--git-dir="+path, "symbolic-ref", "HEAD", "refs/heads/"+previous.DefaultBranch); err != nil {
+			log.Printf("ERROR: could not restore the default branch of repository %s: %v\n", previous.Name, err)
+		}
+	}
+	if repository.Description != previous.Description {
+		if err := writeDescription(path, previous.Description); err != nil {
+			log.Printf("ERROR: could not restore the description of repository %s: %v\n", previous.Name, err)
+		}
+	}
+	*repository = *previous
+}
+
+func (d *DatabaseImpl) SetProtection(author string, name string, protection *api.RepositoryProtection) (*Repository, error) {
+	for _, branch := range protection.Branches {
+		if !IsValidPattern(branch.Pattern) {
+			return nil, InvalidPatternError
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	repository := d.findRepository(name)
+	if repository == nil || repository.Deleted {
+		return nil, RepositoryNotFoundError
+	}
+	previous := repository.Protection
+	repository.Protection = *protection
+	if repository.Protection.Branches == nil {
+		repository.Protection.Branches = []api.BranchProtection{}
+	}
+
+	if err := d.save(author, fmt.Sprintf("protecting branches in repository %s", name)); err != nil {
+		repository.Protection = previous
+		return nil, err
+	}
+	return copyRepository(repository), nil
+}
+
+func (d *DatabaseImpl) DeleteRepository(author string, name string, removed RemovedFunc) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	repository := d.findRepository(name)
+	if repository == nil || repository.Deleted {
+		return RepositoryNotFoundError
+	}
+
+	trashPath := filepath.Join(d.repositoryPath, TrashDirectory)
+	if err := os.MkdirAll(trashPath, 0755); err != nil {
+		return err
+	}
+	now := time.Now()
+	trashName := fmt.Sprintf("%s.%d", strings.ReplaceAll(repository.Name, "/", "."), now.Unix())
+	path := d.GetPath(repository)
+	if err := os.Rename(path, filepath.Join(trashPath, trashName)); err != nil {
+		return err
+	}
+	d.removeEmptyOwner(repository.Name)
+
+	repository.Deleted = true
+	repository.DeletedAt = now
+	repository.TrashPath = trashName
+	version := d.version
+	message := fmt.Sprintf("moving repository %s to the trash", name)
+	if err := d.saveWith(author, message, removeOne(name, removed)); err != nil {
+		// The repository is moved back, unless it's already saved as deleted
+		if d.version == version {
+			if moveErr := d.restore(repository, path); moveErr != nil {
+				log.Printf("ERROR: could not move repository %s back from the trash: %v\n", name, moveErr)
+			}
+		}
+		return err
+	}
+	d.raiseEvent(&EventRepositoryDeleted{Repository: copyRepository(repository)})
+	return nil
+}
+
+func (d *DatabaseImpl) RestoreRepository(author string, name string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	repository := d.findRepository(name)
+	if repository == nil {
+		return RepositoryNotFoundError
+	}
+	if !repository.Deleted {
+		return RepositoryNotDeletedError
+	}
+
+	path := d.path(repository.Name)
+	if _, err := os.Stat(path); err == nil {
+		return RepositoryAlreadyExistsError
+	}
+	if err := d.restore(repository, path); err != nil {
+		return err
+	}
+	if err := d.save(author, fmt.Sprintf("restoring repository %s from the trash", name)); err != nil {
+		return err
+	}
+	d.raiseEvent(&EventRepositoryRestored{Repository: copyRepository(repository)})
+	return nil
+}
+
+// restore moves a repository out from the trash to the supplied path
+func (d *DatabaseImpl) restore(repository *Repository, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(d.GetPath(repository), path); err != nil {
+		return err
+	}
+	repository.Deleted = false
+	repository.DeletedAt = time.Time{}
+	repository.TrashPath = ""
+	return nil
+}
+
+func (d *DatabaseImpl) PurgeRepository(author string, name string, removed RemovedFunc) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i, repository := range d.repositories {
+		if repository.Name != name {
+			continue
+		}
+		if !repository.Deleted {
+			return RepositoryNotDeletedError
+		}
+
+		// The repository is removed from the disk once it's no longer stored, so that it's never lost if it
+		// can't be saved
+		repositories, version := d.repositories, d.version
+		d.repositories = append(append([]*Repository{}, repositories[:i]...), repositories[i+1:]...)
+		message := fmt.Sprintf("purging repository %s", name)
+		if err := d.saveWith(author, message, removeOne(name, removed)); err != nil {
+			if d.version == version {
+				d.repositories = repositories
+			}
+			return err
+		}
+		if err := os.RemoveAll(d.GetPath(repository)); err != nil {
+			log.Printf("WARN: could not remove purged repository %s from the trash: %v\n", name, err)
+		}
+		d.raiseEvent(&EventRepositoryPurged{Repository: copyRepository(repository)})
+		return nil
+	}
+	return RepositoryNotFoundError
+}
+
+func (d *DatabaseImpl) OnEvent(event event.Event) error {
+	if e, ok := event.(*db.EventDataChanged); ok {
+		if e.Path == DatabasePath {
+			return d.reload()
+		}
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) findRepository(name string) *Repository {
+	for _, repository := range d.repositories {
+		if repository.Name == name {
+			return repository
+		}
+	}
+	return nil
+}
+
+// save the repositories, if nobody else has changed the file since it was read. The mutex must be locked by
+// the caller
+func (d *DatabaseImpl) save(author string, message string) error {
+	version, err := d.contentDatabase.WriteVersion(DatabasePath, &Repositories{d.repositories}, d.version, author,
+		message)
+	if err != nil {
+		return err
+	}
+	d.version = version
+	return nil
+}
+
+// saveWith saves the repositories in the same transaction as the changes made by the supplied function. The mutex
+// must be locked by the caller
+func (d *DatabaseImpl) saveWith(author string, message string, fn func(tx db.Transaction) error) error {
+	return db.Update(d.contentDatabase, author, message, func(tx db.Transaction) error {
+		err := tx.WriteVersion(DatabasePath, &Repositories{d.repositories}, d.version, func(version string) {
+			d.version = version
+		})
+		if err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// renameAll calls the supplied function, if any, for each moved repository. The moved repositories are keyed by
+// their old name
+func renameAll(moved map[string]string, renamed RenamedFunc) func(tx db.Transaction) error {
+	return func(tx db.Transaction) error {
+		if renamed == nil {
+			return nil
+		}
+		for oldName, newName := range moved {
+			if err := renamed(tx, oldName, newName); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// removeOne calls the supplied function, if any, for a removed repository
+func removeOne(name string, removed RemovedFunc) func(tx db.Transaction) error {
+	return func(tx db.Transaction) error {
+		if removed == nil {
+			return nil
+		}
+		return removed(tx, name)
+	}
+}
+
+func (d *DatabaseImpl) reload() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var repositories Repositories
+	version, err := d.contentDatabase.ReadVersion(DatabasePath, &repositories)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.version = version
+		}
+		return err
+	}
+	d.version = version
+	d.repositories = repositories.Repositories
+	return nil
+}
+
+// discover imports repositories that exist on disk, but are not known by the database. This happens
+// when repositories are created manually using "git init --bare"
+func (d *DatabaseImpl) discover() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	files, err := ioutil.ReadDir(d.repositoryPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var discovered []string
+	found := func(name string, file os.FileInfo) {
+		if d.findRepository(name) != nil {
+			return
+		}
+		path := d.path(name)
+		log.Printf("INFO: discovered repository %s\n", name)
+		d.repositories = append(d.repositories, &Repository{
+			Name:          name,
+			Description:   readDescription(path),
+			DefaultBranch: readDefaultBranch(path),
+			CreatedAt:     file.ModTime(),
+		})
+		discovered = append(discovered, name)
+	}
+	for _, owner := range files {
+		if !owner.IsDir() || !isValidSegment(owner.Name()) {
+			continue
+		}
+		// Repositories created before repositories were namespaced are located directly in the repository path
+		if isRepository(d.path(owner.Name())) {
+			found(owner.Name(), owner)
+			continue
+		}
+		repositories, err := ioutil.ReadDir(d.path(owner.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range repositories {
+			name := owner.Name() + "/" + file.Name()
+			if file.IsDir() && IsValidName(name) && isRepository(d.path(name)) {
+				found(name, file)
+			}
+		}
+	}
+
+	if len(discovered) == 0 {
+		return nil
+	}
+	return d.save(db.SystemAuthor, fmt.Sprintf("discovering repositories %s", strings.Join(discovered, ", ")))
+}
+
+func (d *DatabaseImpl) MigrateNamespace(author string, owner string, renamed RenamedFunc) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	moved := map[string]string{}
+	var names []string
+	var err error
+	for _, repository := range d.repositories {
+		if _, _, ok := SplitName(repository.Name); ok {
+			continue
+		}
+		oldName, newName := repository.Name, owner+"/"+repository.Name
+		if err = d.move(repository, newName); err != nil {
+			break
+		}
+		log.Printf("INFO: moved repository %s to %s\n", oldName, newName)
+		moved[oldName] = newName
+		names = append(names, oldName)
+	}
+
+	// The repositories that are moved are saved even if a repository can't be moved, since they are no longer
+	// located where the database says they are
+	if len(moved) > 0 {
+		message := fmt.Sprintf("moving repositories %s to %s", strings.Join(names, ", "), owner)
+		saveErr := d.saveWith(author, message, renameAll(moved, renamed))
+		if saveErr == nil {
+			for _, oldName := range names {
+				repository := d.findRepository(moved[oldName])
+				d.raiseEvent(&EventRepositoryRenamed{OldName: oldName, Repository: copyRepository(repository)})
+			}
+		} else if err == nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+// move a repository created before repositories were namespaced. The mutex must be locked by the caller
+func (d *DatabaseImpl) move(repository *Repository, newName string) error {
+	if !IsValidName(newName) {
+		return InvalidNameError
+	}
+	if d.findRepository(newName) != nil {
+		return RepositoryAlreadyExistsError
+	}
+	if !repository.Deleted {
+		newPath := d.path(newName)
+		if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(d.path(repository.Name), newPath); err != nil {
+			return err
+		}
+	}
+	repository.Name = newName
+	return nil
+}
+
+// path returns where the repository with the supplied name is located when it's not in the trash
+func (d *DatabaseImpl) path(name string) string {
+	return filepath.Join(d.repositoryPath, filepath.FromSlash(name))
+}
+
+// removeEmptyOwner removes the directory of the owner of a repository, if the owner has no repositories left
+func (d *DatabaseImpl) removeEmptyOwner(name string) {
+	if owner, _, ok := SplitName(name); ok {
+		// Only empty directories are removed
+		_ = os.Remove(d.path(owner))
+	}
+}
+
+// isRepository checks if the supplied directory contains a bare git repository
+func isRepository(path string) bool {
+	_, err := os.Stat(filepath.Join(path, "HEAD"))
+	return err == nil
+}
+
+// git executes a git command
+func (d *DatabaseImpl) git(args ...string) error {
+	output, err := exec.Command(d.gitPath, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// defaultDescription is the description git puts in new repositories
+const defaultDescription = "Unnamed repository; edit this file 'description' to name the repository."
+
+func writeDescription(path string, description string) error {
+	return ioutil.WriteFile(filepath.Join(path, "description"), []byte(description+"\n"), 0644)
+}
+
+func readDescription(path string) string {
+	bytes, err := ioutil.ReadFile(filepath.Join(path, "description"))
+	if err != nil {
+		return ""
+	}
+	description := strings.TrimSpace(string(bytes))
+	if strings.HasPrefix(description, defaultDescription) {
+		return ""
+	}
+	return description
+}
+
+func readDefaultBranch(path string) string {
+	bytes, err := ioutil.ReadFile(filepath.Join(path, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(bytes)), "ref: refs/heads/")
+}
+
+func (d *DatabaseImpl) raiseEvent(e event.Event) {
+	if d.processor != nil {
+		if err := d.processor.RaiseEvent(e); err != nil {
+			log.Printf("WARN: could not raise event: %v\n", err)
+		}
+	}
+}
+
+// copyRepository creates a copy of the supplied repository, so that it can be returned, or sent in an event,
+// without being changed afterwards
+func copyRepository(repository *Repository) *Repository {
+	result := *repository
+	result.Protection.Branches = make([]api.BranchProtection, len(repository.Protection.Branches))
+	for i, branch := range repository.Protection.Branches {
+		branch.Users = append([]string{}, branch.Users...)
+		branch.Teams = append([]string{}, branch.Teams...)
+		result.Protection.Branches[i] = branch
+	}
+	return &result
+}
+
+func New(database db.ContentDatabase, repositoryPath string, gitPath string, defaultBranch string,
+	processor *event.Processor) (Database, error) {
+	result := &DatabaseImpl{
+		contentDatabase: database,
+		repositoryPath:  repositoryPath,
+		gitPath:         gitPath,
+		defaultBranch:   defaultBranch,
+		processor:       processor,
+		repositories:    []*Repository{},
+		mutex:           &sync.RWMutex{},
+		version:         db.MissingVersion,
+	}
+
+	if err := db.AddCollection(database, DatabasePath, db.Collection{Field: "Repositories", Key: "Name"}); err != nil {
+		return nil, err
+	}
+	if err := result.reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := result.discover(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
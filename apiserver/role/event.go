@@ -0,0 +1,16 @@
+package role
+
+// EventRoleCreated raised when a new custom role is created
+type EventRoleCreated struct {
+	Role *Role
+}
+
+// EventRoleChanged raised when the permissions or the description of a custom role is changed
+type EventRoleChanged struct {
+	Role *Role
+}
+
+// EventRoleRemoved raised when a custom role is removed
+type EventRoleRemoved struct {
+	Role *Role
+}
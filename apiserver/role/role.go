@@ -0,0 +1,42 @@
+package role
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"time"
+)
+
+type Roles struct {
+	Roles []*Role
+}
+
+// Role is a custom role made of fine-grained permissions. Custom roles are assigned to users and apply to the
+// whole server
+type Role struct {
+	Name        string
+	Description string
+	Permissions []api.Permission
+	CreatedAt   time.Time
+}
+
+func (r *Role) ToApi() *api.Role {
+	return &api.Role{
+		Name:        r.Name,
+		Description: r.Description,
+		Permissions: append([]api.Permission{}, r.Permissions...),
+	}
+}
+
+// BuiltIn returns the api representation of a built-in role. Returns nil if no built-in role has the supplied name
+func BuiltIn(name string) *api.Role {
+	permissions, ok := server.BuiltInRoles[name]
+	if !ok {
+		return nil
+	}
+	return &api.Role{
+		Name:        name,
+		Description: server.BuiltInRoleDescriptions[name],
+		Permissions: append([]api.Permission{}, permissions...),
+		BuiltIn:     true,
+	}
+}
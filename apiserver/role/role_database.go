@@ -0,0 +1,284 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const DatabasePath = "/roles.json"
+
+var (
+	RoleNotFoundError      = errors.New("role not found")
+	RoleAlreadyExistsError = errors.New("role already exists")
+	BuiltInRoleError       = errors.New("built-in roles can't be changed")
+	InvalidNameError       = errors.New("name is not valid")
+	InvalidPermissionError = errors.New("unknown permission")
+)
+
+type Database interface {
+	// Database reloads itself when the underlying data is changed
+	event.Listener
+
+	// CreateRole creates a new custom role
+	CreateRole(author string, role *Role) error
+
+	// GetRoles fetches all custom roles
+	GetRoles() []*Role
+
+	// GetRole fetches a custom role with the supplied name. Returns nil if no custom role is found
+	GetRole(name string) *Role
+
+	// UpdateRole changes the description or the permissions of a custom role
+	UpdateRole(author string, name string, changes *api.RoleChanges) (*Role, error)
+
+	// RemoveRole removes a custom role
+	RemoveRole(author string, name string) error
+
+	// Exists checks if a built-in or custom role with the supplied name exists
+	Exists(name string) bool
+
+	// AddPermissions grants the permissions of the roles assigned to the supplied user on the whole server
+	AddPermissions(user string, permissions *server.Permissions)
+}
+
+type DatabaseImpl struct {
+	// Database is a generic json database
+	contentDatabase db.ContentDatabase
+
+	// processor is used when raising events about changed roles
+	processor *event.Processor
+
+	roles []*Role
+	mutex *sync.RWMutex
+
+	// version of the roles file when it was last read or written
+	version string
+}
+
+func (d *DatabaseImpl) CreateRole(author string, role *Role) error {
+	if !user.IsValidName(role.Name) {
+		return InvalidNameError
+	}
+	if _, ok := server.BuiltInRoles[role.Name]; ok {
+		return RoleAlreadyExistsError
+	}
+	if err := validatePermissions(role.Permissions); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.findRole(role.Name) != nil {
+		return RoleAlreadyExistsError
+	}
+	role.CreatedAt = time.Now()
+	if role.Permissions == nil {
+		role.Permissions = []api.Permission{}
+	}
+	d.roles = append(d.roles, role)
+	if err := d.write(author, fmt.Sprintf("creating role %s", role.Name)); err != nil {
+		d.roles = d.roles[:len(d.roles)-1]
+		return err
+	}
+	d.raiseEvent(&EventRoleCreated{Role: copyRole(role)})
+	return nil
+}
+
+func (d *DatabaseImpl) GetRoles() []*Role {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	result := make([]*Role, len(d.roles))
+	for i, role := range d.roles {
+		result[i] = copyRole(role)
+	}
+	return result
+}
+
+func (d *DatabaseImpl) GetRole(name string) *Role {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if role := d.findRole(name); role != nil {
+		return copyRole(role)
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) UpdateRole(author string, name string, changes *api.RoleChanges) (*Role, error) {
+	if _, ok := server.BuiltInRoles[name]; ok {
+		return nil, BuiltInRoleError
+	}
+	if err := validatePermissions(changes.Permissions); err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	role := d.findRole(name)
+	if role == nil {
+		return nil, RoleNotFoundError
+	}
+	previous := *role
+	if changes.Description != nil {
+		role.Description = *changes.Description
+	}
+	if changes.Permissions != nil {
+		role.Permissions = changes.Permissions
+	}
+	if err := d.write(author, fmt.Sprintf("updating role %s", name)); err != nil {
+		*role = previous
+		return nil, err
+	}
+	d.raiseEvent(&EventRoleChanged{Role: copyRole(role)})
+	return copyRole(role), nil
+}
+
+func (d *DatabaseImpl) RemoveRole(author string, name string) error {
+	if _, ok := server.BuiltInRoles[name]; ok {
+		return BuiltInRoleError
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i, role := range d.roles {
+		if role.Name != name {
+			continue
+		}
+		previous := d.roles
+		d.roles = append(append([]*Role{}, d.roles[:i]...), d.roles[i+1:]...)
+		if err := d.write(author, fmt.Sprintf("removing role %s", name)); err != nil {
+			d.roles = previous
+			return err
+		}
+		d.raiseEvent(&EventRoleRemoved{Role: copyRole(role)})
+		return nil
+	}
+	return RoleNotFoundError
+}
+
+func (d *DatabaseImpl) Exists(name string) bool {
+	if _, ok := server.BuiltInRoles[name]; ok {
+		return true
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.findRole(name) != nil
+}
+
+func (d *DatabaseImpl) AddPermissions(_ string, permissions *server.Permissions) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	// Roles that no longer exist are ignored
+	grants := append([]api.Permission{}, permissions.Grants...)
+	for _, name := range permissions.Roles {
+		if builtIn, ok := server.BuiltInRoles[name]; ok {
+			grants = append(grants, builtIn...)
+		} else if role := d.findRole(name); role != nil {
+			grants = append(grants, role.Permissions...)
+		}
+	}
+	permissions.Grants = grants
+}
+
+func (d *DatabaseImpl) OnEvent(e event.Event) error {
+	switch evt := e.(type) {
+	case *db.EventDataChanged:
+		if evt.Path == DatabasePath {
+			return d.reload()
+		}
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) findRole(name string) *Role {
+	for _, role := range d.roles {
+		if role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
+
+// write the roles, if nobody else has changed the file since it was read. The mutex must be locked by the caller
+func (d *DatabaseImpl) write(author string, message string) error {
+	version, err := d.contentDatabase.WriteVersion(DatabasePath, &Roles{d.roles}, d.version, author, message)
+	if err != nil {
+		return err
+	}
+	d.version = version
+	return nil
+}
+
+func (d *DatabaseImpl) reload() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var roles Roles
+	version, err := d.contentDatabase.ReadVersion(DatabasePath, &roles)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.version = version
+		}
+		return err
+	}
+	d.version = version
+	d.roles = roles.Roles
+	return nil
+}
+
+func (d *DatabaseImpl) raiseEvent(e event.Event) {
+	if d.processor != nil {
+		if err := d.processor.RaiseEvent(e); err != nil {
+			log.Printf("WARN: could not raise event: %v\n", err)
+		}
+	}
+}
+
+// validatePermissions verifies that all permissions are known
+func validatePermissions(permissions []api.Permission) error {
+	for _, permission := range permissions {
+		if !permission.IsValid() {
+			return fmt.Errorf("%w: %s", InvalidPermissionError, permission)
+		}
+	}
+	return nil
+}
+
+func copyRole(role *Role) *Role {
+	result := *role
+	result.Permissions = append([]api.Permission{}, role.Permissions...)
+	return &result
+}
+
+func New(database db.ContentDatabase, processor *event.Processor) (Database, error) {
+	result := &DatabaseImpl{
+		contentDatabase: database,
+		processor:       processor,
+		roles:           []*Role{},
+		mutex:           &sync.RWMutex{},
+		version:         db.MissingVersion,
+	}
+
+	if err := db.AddCollection(database, DatabasePath, db.Collection{Field: "Roles", Key: "Name"}); err != nil {
+		return nil, err
+	}
+	if err := result.reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return result, nil
+}
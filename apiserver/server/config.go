@@ -1,15 +1,50 @@
 package server
 
 import (
+	"github.com/westcoastcode-se/gitgo/api"
 	"time"
 )
 
 const DefaultAddress = ":9998"
 const DefaultCAPath = "data/ca.crt"
+const DefaultCAKeyPath = "data/ca.key"
+const DefaultCRLPath = "data/ca.crl"
 const DefaultCertPath = "data/apiserver.crt"
 const DefaultPrivateKey = "data/apiserver.key"
+const DefaultServiceCertPath = "data/apiserver_client.crt"
+const DefaultServiceKeyPath = "data/apiserver_client.key"
 const DefaultRepositoryPath = "data/repositories"
 const DefaultDatabasePath = "data/db"
+const DefaultDatabaseType = DatabaseTypeJson
+const DefaultBoltPath = "data/gitgo.db"
+const DefaultEventLogPath = "data/events.log"
+const DefaultGitPath = "git"
+const DefaultBranch = "main"
+const DefaultBootstrapUser = "superuser"
+
+const (
+	// DatabaseTypeGit stores the data in a git repository, where every change is committed
+	DatabaseTypeGit = "git"
+
+	// DatabaseTypeJson stores the data in json files
+	DatabaseTypeJson = "json"
+
+	// DatabaseTypeBolt stores the data in an embedded key-value store, with indexes of the data
+	DatabaseTypeBolt = "bolt"
+)
+
+// Principal is the identity a client-side certificate authenticates as. Either Service or User is set
+type Principal struct {
+	// Service is the name of a trusted service, such as the git server. Services are only allowed to use the
+	// internal api
+	Service string
+
+	// Roles contains the roles granted to the service
+	Roles []string
+
+	// User is the name of the user the certificate authenticates as
+	User string
+}
 
 type Config struct {
 	Address      string
@@ -21,12 +56,86 @@ type Config struct {
 	CertPath   string
 	PrivateKey string
 
+	// CAKeyPath points to the private key of the certificate authority. A new certificate authority is created
+	// if neither CAPath nor CAKeyPath exists. Certificates can't be issued if the key is missing
+	CAKeyPath string
+
+	// CRLPath points to where the certificate revocation list is written. The list is read from here if the
+	// certificate authority can't sign it
+	CRLPath string
+
+	// ServerNames contains the host names the api server's certificate is issued for, if it's missing
+	ServerNames []string
+
+	// ServiceCertPath and ServiceKeyPath points to where the git server's client-side certificate is written, if
+	// it's missing. The certificate is issued for the common name mapped to the git server in Principals
+	ServiceCertPath string
+	ServiceKeyPath  string
+
+	// CAValidity is how long a new certificate authority is valid
+	CAValidity time.Duration
+
+	// CertificateValidity is how long an issued certificate is valid, unless something else is requested
+	CertificateValidity time.Duration
+
+	// CertificateWarning is how long before a certificate expires that warnings are logged
+	CertificateWarning time.Duration
+
+	// CertificateWatchInterval is how often CertPath, PrivateKey and CAPath are checked for changes. The files
+	// are also read again when the api server receives SIGHUP
+	CertificateWatchInterval time.Duration
+
 	// DatabasePath points to a location where the database data is located. It can be a path
 	// on the hard-drive
 	DatabasePath string
 
+	// DatabaseType is how the data is stored. Either DatabaseTypeGit, DatabaseTypeJson or DatabaseTypeBolt
+	DatabaseType string
+
+	// BoltPath points to the file where the data is stored when DatabaseType is DatabaseTypeBolt
+	BoltPath string
+
+	// DatabaseWatchInterval is how often the database is checked for changes made outside of the api server
+	DatabaseWatchInterval time.Duration
+
+	// EventLogPath points to the file where all events are stored
+	EventLogPath string
+
 	// RepositoryPath points to where repositories are located
 	RepositoryPath string
+
+	// GitPath points to the git executable used when managing repositories
+	GitPath string
+
+	// DefaultBranch is the branch new repositories uses if nothing else is specified
+	DefaultBranch string
+
+	// BootstrapUser is the name of the administrator that's created when the server is started without any users
+	BootstrapUser string
+
+	// EventWorkers is how many goroutines process events. Events in the same topic are always processed by the
+	// same worker
+	EventWorkers int
+
+	// EventQueueSize is how many events each worker can have queued. Raising an event fails if the queue is full
+	EventQueueSize int
+
+	// ShutdownTimeout is how long to wait for active requests and queued events when the server is stopped
+	ShutdownTimeout time.Duration
+
+	// WebhookTimeout is how long to wait for a webhook to respond before the delivery is considered failed
+	WebhookTimeout time.Duration
+
+	// WebhookAllowedNetworks contains the networks, in CIDR notation, that webhooks can be sent to even though
+	// they are internal, such as "10.0.0.0/8". Loopback, private and link-local addresses are blocked otherwise
+	WebhookAllowedNetworks []string
+
+	// Principals maps the common names of client-side certificates to the identity they authenticate as
+	Principals map[string]Principal
+
+	// CertificateUsers allows users to authenticate using a client-side certificate issued to them by the
+	// certificate authority, if the common name isn't found in Principals
+	CertificateUsers bool
 }
 
 func LoadConfig() Config {
@@ -38,7 +147,36 @@ func LoadConfig() Config {
 		CAPath:         DefaultCAPath,
 		CertPath:       DefaultCertPath,
 		PrivateKey:     DefaultPrivateKey,
+		CAKeyPath:      DefaultCAKeyPath,
+		CRLPath:        DefaultCRLPath,
 		RepositoryPath: DefaultRepositoryPath,
 		DatabasePath:   DefaultDatabasePath,
+		DatabaseType:   DefaultDatabaseType,
+		BoltPath:       DefaultBoltPath,
+		EventLogPath:   DefaultEventLogPath,
+		GitPath:        DefaultGitPath,
+		DefaultBranch:  DefaultBranch,
+		BootstrapUser:  DefaultBootstrapUser,
+		WebhookTimeout: 10 * time.Second,
+
+		ServerNames:         []string{"localhost"},
+		ServiceCertPath:     DefaultServiceCertPath,
+		ServiceKeyPath:      DefaultServiceKeyPath,
+		CAValidity:          10 * 365 * 24 * time.Hour,
+		CertificateValidity: 365 * 24 * time.Hour,
+		CertificateWarning:  30 * 24 * time.Hour,
+
+		CertificateWatchInterval: 10 * time.Second,
+
+		DatabaseWatchInterval: time.Second,
+
+		EventWorkers:    4,
+		EventQueueSize:  1024,
+		ShutdownTimeout: 30 * time.Second,
+
+		Principals: map[string]Principal{
+			"apiserverclient": {Service: "gitserver", Roles: []string{api.RoleService}},
+		},
+		CertificateUsers: false,
 	}
 }
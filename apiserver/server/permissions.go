@@ -1,7 +1,247 @@
 package server
 
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"strings"
+)
+
+// Resource is what a permission is required on. A field that's empty means any resource of that kind, so that
+// a route can verify that a permission might be granted before it knows which resource the request targets
+type Resource struct {
+	// Repository is the name of a repository, in the form "owner/name"
+	Repository string
+
+	// Namespace is the user or organization owning the resource. The owner of the repository is used if empty
+	Namespace string
+
+	// User is the name of the user whose account the resource belongs to
+	User string
+}
+
 type Permissions struct {
+	// User is the name of the user the permissions are granted to
+	User string
+
+	// Admin is set if the permissions grants the site-admin role
+	Admin bool
+
+	// Repositories contains the access level for each repository that's explicitly granted
+	Repositories map[string]api.Access
+
+	// Namespaces contains the access level granted to all repositories owned by a specific user or organization
+	Namespaces map[string]api.Access
+
+	// Organizations contains the role the user has in each organization the user is a member of
+	Organizations map[string]api.OrganizationRole
+
+	// Roles contains the names of the roles assigned on the whole server
+	Roles []string
+
+	// Grants contains the permissions granted on the whole server by the assigned roles
+	Grants []api.Permission
+
+	// Scopes restricts the permissions when a request is authenticated with a personal access token.
+	// Nothing is restricted if nil
+	Scopes []api.Scope
+
+	// Service is the name of the trusted service making the request, such as the git server. Empty if the
+	// request is made by a user
+	Service string
+}
+
+// Has checks if the permissions allows the supplied permission on the supplied resource
+func (p *Permissions) Has(permission api.Permission, resource Resource) bool {
+	if permission == api.PermissionNone {
+		return true
+	}
+	if access, ok := repositoryPermissions[permission]; ok {
+		if len(resource.Repository) == 0 {
+			return p.maxAccess().Allows(access)
+		}
+		return p.GetAccess(resource.Repository).Allows(access)
+	}
+
+	for _, g := range p.grants(resource) {
+		if contains(g.permissions, permission) && p.hasScopes(g.requiredScopes(permission)) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasOnSite checks if the permissions allows the supplied permission on the whole server, and not only on the
+// resources owned by or granted to the user
+func (p *Permissions) HasOnSite(permission api.Permission) bool {
+	if access, ok := repositoryPermissions[permission]; ok {
+		return p.restrict(p.siteAccess()).Allows(access)
+	}
+	for _, g := range p.siteGrants() {
+		if contains(g.permissions, permission) && p.hasScopes(g.requiredScopes(permission)) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsService checks if the permissions are granted to a trusted service instead of a user
+func (p *Permissions) IsService() bool {
+	return len(p.Service) > 0
+}
+
+// HasScope checks if the supplied scope is allowed
+func (p *Permissions) HasScope(scope api.Scope) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAccess returns the access level for the supplied repository
+func (p *Permissions) GetAccess(repository string) api.Access {
+	access := p.siteAccess()
+	if a, ok := p.Repositories[repository]; ok && !access.Allows(a) {
+		access = a
+	}
+	if a, ok := p.Namespaces[owner(repository)]; ok && !access.Allows(a) {
+		access = a
+	}
+	return p.restrict(access)
+}
+
+// maxAccess returns the highest access level granted to any repository
+func (p *Permissions) maxAccess() api.Access {
+	access := p.siteAccess()
+	for _, a := range p.Repositories {
+		if !access.Allows(a) {
+			access = a
+		}
+	}
+	for _, a := range p.Namespaces {
+		if !access.Allows(a) {
+			access = a
+		}
+	}
+	return p.restrict(access)
+}
+
+// siteAccess returns the access level granted to all repositories by the roles granted on the whole server
+func (p *Permissions) siteAccess() api.Access {
+	access := api.AccessNone
+	for _, g := range p.siteGrants() {
+		for permission, a := range repositoryPermissions {
+			if contains(g.permissions, permission) && !access.Allows(a) {
+				access = a
+			}
+		}
+	}
+	return access
+}
+
+// restrict the supplied access level to what the scopes allow
+func (p *Permissions) restrict(access api.Access) api.Access {
+	if p.Scopes != nil {
+		if allowed := api.MaxRepositoryAccess(p.Scopes); !allowed.Allows(access) {
+			return allowed
+		}
+	}
+	return access
+}
+
+// grant is a set of permissions granted by a role. A personal access token must have all scopes listed for a
+// permission to be allowed to use it
+type grant struct {
+	permissions []api.Permission
+	scopes      map[api.Permission][]api.Scope
+
+	// missingScopes are required for permissions that are missing in scopes. Nothing is required if nil
+	missingScopes []api.Scope
+}
+
+// requiredScopes returns the scopes a personal access token must have to use the supplied permission
+func (g grant) requiredScopes(permission api.Permission) []api.Scope {
+	if scopes, ok := g.scopes[permission]; ok {
+		return scopes
+	}
+	return g.missingScopes
+}
+
+// siteGrants returns the permissions granted on the whole server
+func (p *Permissions) siteGrants() []grant {
+	var result []grant
+	if p.Admin {
+		result = append(result, grant{permissions: BuiltInRoles[api.RoleSiteAdmin], scopes: siteScopes,
+			missingScopes: adminScopes})
+	}
+	if len(p.Grants) > 0 {
+		result = append(result, grant{permissions: p.Grants, scopes: siteScopes, missingScopes: adminScopes})
+	}
+	return result
+}
+
+// grants returns the permissions granted on the supplied resource
+func (p *Permissions) grants(resource Resource) []grant {
+	result := p.siteGrants()
+	if len(p.User) > 0 {
+		result = append(result, grant{permissions: userPermissions, scopes: userScopes})
+		if len(resource.User) == 0 || resource.User == p.User {
+			result = append(result, grant{permissions: accountPermissions, scopes: userScopes})
+		}
+	}
+
+	namespace := resource.Namespace
+	if len(namespace) == 0 {
+		namespace = owner(resource.Repository)
+	}
+	for name, access := range p.Namespaces {
+		if (len(namespace) == 0 || name == namespace) && access.Allows(api.AccessAdmin) {
+			result = append(result, grant{permissions: BuiltInRoles[api.RoleOrgOwner], scopes: ownerScopes})
+		}
+	}
+	for name := range p.Organizations {
+		if len(namespace) == 0 || name == namespace {
+			result = append(result, grant{permissions: BuiltInRoles[api.RoleOrgMember]})
+		}
+	}
+	return result
+}
+
+// hasScopes checks if all the supplied scopes are allowed
+func (p *Permissions) hasScopes(scopes []api.Scope) bool {
+	for _, scope := range scopes {
+		if !p.HasScope(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// owner returns the user or organization owning the supplied repository
+func owner(repository string) string {
+	if idx := strings.Index(repository, "/"); idx != -1 {
+		return repository[:idx]
+	}
+	return ""
+}
+
+func contains(permissions []api.Permission, permission api.Permission) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionProvider adds the permissions a user is granted through something other than the user itself, such
+// as organizations and roles
+type PermissionProvider interface {
+	AddPermissions(user string, permissions *Permissions)
 }
 
 // MissingPermissions is used when a specific request has no permissions associated with it
-var MissingPermissions = &Permissions{}
+var MissingPermissions = &Permissions{Scopes: []api.Scope{}}
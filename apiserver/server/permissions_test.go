@@ -0,0 +1,167 @@
+package server
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"testing"
+)
+
+func TestPermissionsHas(t *testing.T) {
+	reader := &Permissions{User: "per", Repositories: map[string]api.Access{"acme/website": api.AccessRead}}
+	owner := &Permissions{User: "per", Namespaces: map[string]api.Access{"per": api.AccessAdmin}}
+	ownerReadToken := &Permissions{User: "per", Namespaces: map[string]api.Access{"per": api.AccessAdmin},
+		Scopes: []api.Scope{api.ScopeRepoRead}}
+	member := &Permissions{User: "per",
+		Organizations: map[string]api.OrganizationRole{"acme": api.OrganizationRoleMember}}
+	admin := &Permissions{User: "superuser", Admin: true}
+	adminReadToken := &Permissions{User: "superuser", Admin: true, Scopes: []api.Scope{api.ScopeRepoRead}}
+	adminUsersToken := &Permissions{User: "superuser", Admin: true, Scopes: []api.Scope{api.ScopeAdminUsers}}
+	unknown := api.Permission("unknown:permission")
+	granted := &Permissions{Grants: []api.Permission{unknown}}
+	grantedUsersToken := &Permissions{Grants: []api.Permission{unknown}, Scopes: []api.Scope{api.ScopeAdminUsers}}
+	grantedAdminToken := &Permissions{Grants: []api.Permission{unknown},
+		Scopes: []api.Scope{api.ScopeAdminUsers, api.ScopeAdminRepositories}}
+
+	tests := []struct {
+		name        string
+		permissions *Permissions
+		permission  api.Permission
+		resource    Resource
+		expected    bool
+	}{
+		{"none is always allowed", MissingPermissions, api.PermissionNone, Resource{}, true},
+		{"missing permissions", MissingPermissions, api.PermissionRepositoryRead, Resource{Repository: "a/b"}, false},
+		{"read granted repository", reader, api.PermissionRepositoryRead, Resource{Repository: "acme/website"}, true},
+		{"write granted repository", reader, api.PermissionRepositoryWrite, Resource{Repository: "acme/website"},
+			false},
+		{"read other repository", reader, api.PermissionRepositoryRead, Resource{Repository: "acme/other"}, false},
+		{"read any repository", reader, api.PermissionRepositoryRead, Resource{}, true},
+		{"admin own repository", owner, api.PermissionRepositoryAdmin, Resource{Repository: "per/website"}, true},
+		{"admin other namespace", owner, api.PermissionRepositoryAdmin, Resource{Repository: "acme/website"}, false},
+		{"create in own namespace", owner, api.PermissionRepositoryCreate, Resource{Namespace: "per"}, true},
+		{"create in other namespace", owner, api.PermissionRepositoryCreate, Resource{Namespace: "acme"}, false},
+		{"read own repository with read token", ownerReadToken, api.PermissionRepositoryRead,
+			Resource{Repository: "per/website"}, true},
+		{"write own repository with read token", ownerReadToken, api.PermissionRepositoryWrite,
+			Resource{Repository: "per/website"}, false},
+		{"create with read token", ownerReadToken, api.PermissionRepositoryCreate, Resource{Namespace: "per"}, false},
+		{"read own account", reader, api.PermissionAccountRead, Resource{User: "per"}, true},
+		{"read other account", reader, api.PermissionAccountRead, Resource{User: "bob"}, false},
+		{"read organization as member", member, api.PermissionOrganizationRead, Resource{Namespace: "acme"}, true},
+		{"manage organization as member", member, api.PermissionOrganizationManage, Resource{Namespace: "acme"},
+			false},
+		{"read other organization", member, api.PermissionOrganizationRead, Resource{Namespace: "other"}, false},
+		{"admin manages users", admin, api.PermissionUsersManage, Resource{}, true},
+		{"admin writes any repository", admin, api.PermissionRepositoryWrite, Resource{Repository: "a/b"}, true},
+		{"admin with read token reads repository", adminReadToken, api.PermissionRepositoryRead,
+			Resource{Repository: "a/b"}, true},
+		{"admin with read token writes repository", adminReadToken, api.PermissionRepositoryWrite,
+			Resource{Repository: "a/b"}, false},
+		{"admin with read token manages users", adminReadToken, api.PermissionUsersManage, Resource{}, false},
+		{"admin with users token manages users", adminUsersToken, api.PermissionUsersManage, Resource{}, true},
+		{"admin with users token manages database", adminUsersToken, api.PermissionDatabaseManage, Resource{},
+			false},
+		{"admin with users token reports events", adminUsersToken, api.PermissionEventsReport, Resource{}, false},
+		{"admin with users token verifies tokens", adminUsersToken, api.PermissionTokensVerify, Resource{}, true},
+		{"granted permission without token", granted, unknown, Resource{}, true},
+		{"granted permission without scopes", granted, api.PermissionUsersRead, Resource{}, false},
+		{"permission missing in site scopes", grantedUsersToken, unknown, Resource{}, false},
+		{"permission missing in site scopes with admin token", grantedAdminToken, unknown, Resource{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.permissions.Has(test.permission, test.resource); actual != test.expected {
+				t.Errorf("expected %v but was %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPermissionsGetAccess(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions *Permissions
+		repository  string
+		expected    api.Access
+	}{
+		{"nothing granted", &Permissions{}, "acme/website", api.AccessNone},
+		{"granted repository", &Permissions{Repositories: map[string]api.Access{"acme/website": api.AccessWrite}},
+			"acme/website", api.AccessWrite},
+		{"highest of repository and namespace", &Permissions{
+			Repositories: map[string]api.Access{"acme/website": api.AccessRead},
+			Namespaces:   map[string]api.Access{"acme": api.AccessAdmin}}, "acme/website", api.AccessAdmin},
+		{"site role", &Permissions{Grants: BuiltInRoles[api.RoleWriter]}, "acme/website", api.AccessWrite},
+		{"restricted by scopes", &Permissions{Admin: true, Scopes: []api.Scope{api.ScopeRepoWrite}},
+			"acme/website", api.AccessWrite},
+		{"no repository scopes", &Permissions{Admin: true, Scopes: []api.Scope{api.ScopeUserWrite}},
+			"acme/website", api.AccessNone},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.permissions.GetAccess(test.repository); actual != test.expected {
+				t.Errorf("expected %s but was %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPermissionsHasOnSite(t *testing.T) {
+	owner := &Permissions{User: "per", Namespaces: map[string]api.Access{"per": api.AccessAdmin},
+		Organizations: map[string]api.OrganizationRole{"acme": api.OrganizationRoleOwner}}
+	tests := []struct {
+		name        string
+		permissions *Permissions
+		permission  api.Permission
+		expected    bool
+	}{
+		{"admin", &Permissions{Admin: true}, api.PermissionUsersManage, true},
+		{"admin with read token", &Permissions{Admin: true, Scopes: []api.Scope{api.ScopeRepoRead}},
+			api.PermissionUsersManage, false},
+		{"granted by role", &Permissions{Grants: []api.Permission{api.PermissionUsersManage}},
+			api.PermissionUsersManage, true},
+		{"repository access by role", &Permissions{Grants: BuiltInRoles[api.RoleWriter]},
+			api.PermissionRepositoryWrite, true},
+		{"repository access in own namespace", owner, api.PermissionRepositoryAdmin, false},
+		{"organization owner", owner, api.PermissionOrganizationManage, false},
+		{"account of every user", &Permissions{User: "per"}, api.PermissionAccountWrite, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.permissions.HasOnSite(test.permission); actual != test.expected {
+				t.Errorf("expected %v but was %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+// TestSiteScopes makes sure that every permission granted on the whole server, except the repository permissions
+// that are restricted by the access level, has the scopes a token needs to use it
+func TestSiteScopes(t *testing.T) {
+	for _, permission := range api.Permissions {
+		if _, ok := repositoryPermissions[permission]; ok {
+			continue
+		}
+		if _, ok := siteScopes[permission]; !ok {
+			t.Errorf("%s is missing in siteScopes", permission)
+		}
+	}
+}
+
+func TestBuiltInRoles(t *testing.T) {
+	for _, name := range BuiltInRoleNames {
+		permissions, ok := BuiltInRoles[name]
+		if !ok {
+			t.Errorf("%s is missing in BuiltInRoles", name)
+		}
+		if _, ok := BuiltInRoleDescriptions[name]; !ok {
+			t.Errorf("%s is missing in BuiltInRoleDescriptions", name)
+		}
+		for _, permission := range permissions {
+			if !permission.IsValid() {
+				t.Errorf("%s contains unknown permission %s", name, permission)
+			}
+		}
+	}
+	if len(BuiltInRoles) != len(BuiltInRoleNames) {
+		t.Errorf("expected %d built-in roles but was %d", len(BuiltInRoleNames), len(BuiltInRoles))
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import "github.com/westcoastcode-se/gitgo/api"
+
+// BuiltInRoles contains the permissions of each built-in role
+var BuiltInRoles = map[string][]api.Permission{
+	api.RoleSiteAdmin: api.Permissions,
+	api.RoleOrgOwner: {api.PermissionOrganizationRead, api.PermissionOrganizationManage,
+		api.PermissionRepositoryCreate, api.PermissionRepositoryRead, api.PermissionRepositoryWrite,
+		api.PermissionRepositoryAdmin},
+	api.RoleOrgMember: {api.PermissionOrganizationRead},
+	api.RoleRepoAdmin: {api.PermissionRepositoryRead, api.PermissionRepositoryWrite, api.PermissionRepositoryAdmin},
+	api.RoleWriter:    {api.PermissionRepositoryRead, api.PermissionRepositoryWrite},
+	api.RoleReader:    {api.PermissionRepositoryRead},
+	api.RoleService: {api.PermissionRepositoryRead, api.PermissionUsersRead, api.PermissionEventsRead,
+		api.PermissionEventsReport, api.PermissionTokensVerify},
+}
+
+// BuiltInRoleDescriptions contains a short description of each built-in role
+var BuiltInRoleDescriptions = map[string]string{
+	api.RoleSiteAdmin: "Full access to the whole server",
+	api.RoleOrgOwner:  "Manages an organization and all its repositories",
+	api.RoleOrgMember: "Views an organization, its members and its teams",
+	api.RoleRepoAdmin: "Manages a repository",
+	api.RoleWriter:    "Reads and pushes to a repository",
+	api.RoleReader:    "Reads a repository",
+	api.RoleService:   "Trusted services, such as the git server",
+}
+
+// BuiltInRoleNames contains the names of the built-in roles, in the order they are listed
+var BuiltInRoleNames = []string{api.RoleSiteAdmin, api.RoleOrgOwner, api.RoleOrgMember, api.RoleRepoAdmin,
+	api.RoleWriter, api.RoleReader, api.RoleService}
+
+// repositoryPermissions maps each repository permission to the access level it requires. Repository permissions
+// are granted through the repo-admin, writer and reader roles, which are the same as the access levels
+var repositoryPermissions = map[api.Permission]api.Access{
+	api.PermissionRepositoryRead:  api.AccessRead,
+	api.PermissionRepositoryWrite: api.AccessWrite,
+	api.PermissionRepositoryAdmin: api.AccessAdmin,
+}
+
+// userPermissions are granted to every user
+var userPermissions = []api.Permission{api.PermissionOrganizationCreate}
+
+// accountPermissions are granted to every user for their own account
+var accountPermissions = []api.Permission{api.PermissionAccountRead, api.PermissionAccountWrite}
+
+// siteScopes are the scopes required to use the permissions granted on the whole server. Permissions that are
+// missing require adminScopes, so that a new permission is never usable by a narrowly scoped token by mistake
+var siteScopes = map[api.Permission][]api.Scope{
+	api.PermissionRepositoryCreate:   {api.ScopeAdminRepositories},
+	api.PermissionRepositoriesManage: {api.ScopeAdminRepositories},
+	api.PermissionOrganizationCreate: {api.ScopeAdminUsers},
+	api.PermissionOrganizationRead:   {api.ScopeAdminUsers},
+	api.PermissionOrganizationManage: {api.ScopeAdminUsers},
+	api.PermissionAccountRead:        {api.ScopeAdminUsers},
+	api.PermissionAccountWrite:       {api.ScopeAdminUsers},
+	api.PermissionUsersRead:          {api.ScopeAdminUsers},
+	api.PermissionUsersManage:        {api.ScopeAdminUsers},
+	api.PermissionRolesManage:        {api.ScopeAdminUsers},
+	api.PermissionDatabaseManage:     {api.ScopeAdminUsers, api.ScopeAdminRepositories},
+	api.PermissionCertificatesManage: {api.ScopeAdminUsers},
+	api.PermissionEventsRead:         {api.ScopeAdminUsers},
+	api.PermissionEventsReport:       {api.ScopeAdminRepositories},
+	api.PermissionTokensVerify:       {api.ScopeAdminUsers},
+}
+
+// adminScopes are required to use a permission granted on the whole server that's missing in siteScopes
+var adminScopes = []api.Scope{api.ScopeAdminUsers, api.ScopeAdminRepositories}
+
+// ownerScopes are the scopes required to use the permissions granted to the owner of a namespace
+var ownerScopes = map[api.Permission][]api.Scope{
+	api.PermissionRepositoryCreate:   {api.ScopeRepoAdmin},
+	api.PermissionOrganizationManage: {api.ScopeOrgAdmin},
+}
+
+// userScopes are the scopes required to use the permissions granted to every user
+var userScopes = map[api.Permission][]api.Scope{
+	api.PermissionOrganizationCreate: {api.ScopeOrgAdmin},
+	api.PermissionAccountWrite:       {api.ScopeUserWrite},
+}
@@ -0,0 +1,65 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/westcoastcode-se/gitgo/api"
+	"time"
+)
+
+// SecretPrefix is put in front of all secrets, so that tokens are easy to recognize if they are leaked
+const SecretPrefix = "gitgo_"
+
+type Tokens struct {
+	Tokens []*Token
+}
+
+type Token struct {
+	ID        string
+	Name      string
+	User      string
+	Scopes    []api.Scope
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	// Hash is a SHA-256 hash of the secret. The secret itself is never stored
+	Hash string
+
+	// LastUsedAt is when the token was last used. It's stored at most once every LastUsedInterval
+	LastUsedAt *time.Time
+}
+
+// IsExpired checks if the token has stopped working
+func (t *Token) IsExpired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
+func (t *Token) ToApi() *api.Token {
+	return &api.Token{
+		ID:         t.ID,
+		Name:       t.Name,
+		User:       t.User,
+		Scopes:     t.Scopes,
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+}
+
+// newSecret generates a new random secret
+func newSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return SecretPrefix + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// hashSecret creates the hash that's stored in the database. The secrets are long and random, so a fast
+// hash is enough
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
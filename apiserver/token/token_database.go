@@ -0,0 +1,304 @@
+package token
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DatabasePath = "/tokens.json"
+
+// DefaultLifetime is how long a token works if no expiration time is supplied
+const DefaultLifetime = 90 * 24 * time.Hour
+
+// LastUsedInterval is how often the time when a token was last used is stored, so that a token that is used for
+// every request doesn't cause a write each time
+const LastUsedInterval = time.Hour
+
+var (
+	TokenNotFoundError    = errors.New("token not found")
+	InvalidTokenError     = errors.New("token is not valid")
+	ExpiredTokenError     = errors.New("token has expired")
+	InvalidScopeError     = errors.New("unknown scope")
+	InvalidExpiresAtError = errors.New("expiration time must be in the future")
+)
+
+type Database interface {
+	// Database reloads itself when the underlying data is changed
+	event.Listener
+
+	// AddToken creates a new token for the supplied user. The secret is returned together with the token and
+	// can't be retrieved again
+	AddToken(author string, user string, name string, scopes []api.Scope, expiresAt *time.Time) (*Token, string, error)
+
+	// GetTokens fetches all tokens owned by the supplied user
+	GetTokens(user string) []*Token
+
+	// RemoveToken revokes one of the user's tokens
+	RemoveToken(author string, user string, id string) error
+
+	// RemoveUserTokens revokes all tokens owned by the supplied user
+	RemoveUserTokens(author string, user string) error
+
+	// Authenticate finds the token that matches the supplied secret
+	Authenticate(secret string) (*Token, error)
+}
+
+type DatabaseImpl struct {
+	// Database is a generic json database
+	contentDatabase db.ContentDatabase
+
+	tokens []*Token
+	mutex  *sync.RWMutex
+
+	// version of the tokens file when it was last read or written
+	version string
+
+	// lastUsed contains when tokens were last used, keyed by their ID, until it's stored
+	lastUsedMutex sync.Mutex
+	lastUsed      map[string]time.Time
+}
+
+func (d *DatabaseImpl) AddToken(author string, user string, name string, scopes []api.Scope,
+	expiresAt *time.Time) (*Token, string, error) {
+	for _, scope := range scopes {
+		if !scope.IsValid() {
+			return nil, "", InvalidScopeError
+		}
+	}
+	now := time.Now()
+	expires := now.Add(DefaultLifetime)
+	if expiresAt != nil {
+		if !expiresAt.After(now) {
+			return nil, "", InvalidExpiresAtError
+		}
+		expires = *expiresAt
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	t := &Token{
+		ID:        uuid.New().String(),
+		Name:      name,
+		User:      user,
+		Scopes:    append([]api.Scope{}, scopes...),
+		CreatedAt: now,
+		ExpiresAt: expires,
+		Hash:      hashSecret(secret),
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	tokens := append(d.removeExpired(), t)
+	err = d.write(&Tokens{tokens}, author,
+		fmt.Sprintf("adding token %s for user %s", t.ID, user))
+	if err != nil {
+		return nil, "", err
+	}
+	d.tokens = tokens
+	return t, secret, nil
+}
+
+func (d *DatabaseImpl) GetTokens(user string) []*Token {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var result []*Token
+	for _, t := range d.tokens {
+		if t.User == user {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func (d *DatabaseImpl) RemoveToken(author string, user string, id string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i, t := range d.tokens {
+		if t.User != user || t.ID != id {
+			continue
+		}
+		tokens := append(append([]*Token{}, d.tokens[:i]...), d.tokens[i+1:]...)
+		err := d.write(&Tokens{tokens}, author,
+			fmt.Sprintf("revoking token %s for user %s", id, user))
+		if err != nil {
+			return err
+		}
+		d.tokens = tokens
+		return nil
+	}
+	return TokenNotFoundError
+}
+
+func (d *DatabaseImpl) RemoveUserTokens(author string, user string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var tokens []*Token
+	for _, t := range d.tokens {
+		if t.User != user {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == len(d.tokens) {
+		return nil
+	}
+	err := d.write(&Tokens{tokens}, author,
+		fmt.Sprintf("revoking all tokens for user %s", user))
+	if err != nil {
+		return err
+	}
+	d.tokens = tokens
+	return nil
+}
+
+func (d *DatabaseImpl) Authenticate(secret string) (*Token, error) {
+	if !strings.HasPrefix(secret, SecretPrefix) {
+		return nil, InvalidTokenError
+	}
+	hash := []byte(hashSecret(secret))
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	for _, t := range d.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), hash) == 1 {
+			if t.IsExpired() {
+				return nil, ExpiredTokenError
+			}
+			d.used(t)
+			return t, nil
+		}
+	}
+	return nil, InvalidTokenError
+}
+
+// used records that the supplied token is used. Unless it was stored within LastUsedInterval, the time is stored
+// by a separate goroutine, so that authenticating never waits for the tokens to be written
+func (d *DatabaseImpl) used(t *Token) {
+	now := time.Now()
+	if t.LastUsedAt != nil && now.Sub(*t.LastUsedAt) < LastUsedInterval {
+		return
+	}
+
+	d.lastUsedMutex.Lock()
+	defer d.lastUsedMutex.Unlock()
+	if _, ok := d.lastUsed[t.ID]; ok {
+		return
+	}
+	d.lastUsed[t.ID] = now
+	go d.storeLastUsed(t.ID)
+}
+
+// storeLastUsed writes when the token with the supplied ID was last used. If it can't be stored then it's stored
+// the next time the token is used
+func (d *DatabaseImpl) storeLastUsed(id string) {
+	d.lastUsedMutex.Lock()
+	usedAt := d.lastUsed[id]
+	d.lastUsedMutex.Unlock()
+	defer func() {
+		d.lastUsedMutex.Lock()
+		defer d.lastUsedMutex.Unlock()
+		delete(d.lastUsed, id)
+	}()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i, t := range d.tokens {
+		if t.ID != id {
+			continue
+		}
+		updated := *t
+		updated.LastUsedAt = &usedAt
+		tokens := append([]*Token{}, d.tokens...)
+		tokens[i] = &updated
+		err := d.write(&Tokens{tokens}, db.SystemAuthor, fmt.Sprintf("token %s for user %s was used", t.ID, t.User))
+		if err != nil {
+			log.Printf("WARN: could not store when token %s was last used: %v\n", t.ID, err)
+			return
+		}
+		d.tokens = tokens
+		return
+	}
+}
+
+func (d *DatabaseImpl) OnEvent(event event.Event) error {
+	if e, ok := event.(*db.EventDataChanged); ok {
+		if e.Path == DatabasePath {
+			return d.reload()
+		}
+	}
+	return nil
+}
+
+// removeExpired returns all tokens that haven't expired yet
+func (d *DatabaseImpl) removeExpired() []*Token {
+	var result []*Token
+	for _, t := range d.tokens {
+		if !t.IsExpired() {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// write the supplied tokens, if nobody else has changed the file since it was read. The mutex must be locked
+// by the caller
+func (d *DatabaseImpl) write(tokens *Tokens, author string, message string) error {
+	version, err := d.contentDatabase.WriteVersion(DatabasePath, tokens, d.version, author, message)
+	if err != nil {
+		return err
+	}
+	d.version = version
+	return nil
+}
+
+func (d *DatabaseImpl) reload() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var tokens Tokens
+	version, err := d.contentDatabase.ReadVersion(DatabasePath, &tokens)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.version = version
+		}
+		return err
+	}
+	d.version = version
+	d.tokens = tokens.Tokens
+	return nil
+}
+
+func New(database db.ContentDatabase) (Database, error) {
+	result := &DatabaseImpl{
+		contentDatabase: database,
+		tokens:          []*Token{},
+		mutex:           &sync.RWMutex{},
+		version:         db.MissingVersion,
+		lastUsed:        map[string]time.Time{},
+	}
+
+	if err := db.AddCollection(database, DatabasePath, db.Collection{Field: "Tokens", Key: "ID"}); err != nil {
+		return nil, err
+	}
+	if err := result.reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return result, nil
+}
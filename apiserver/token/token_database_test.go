@@ -0,0 +1,142 @@
+package token
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/jsondb"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func newDatabase(t *testing.T) *DatabaseImpl {
+	dir, err := ioutil.TempDir("", "gitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	database, err := New(jsondb.New(dir, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return database.(*DatabaseImpl)
+}
+
+func TestAddToken(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name      string
+		scopes    []api.Scope
+		expiresAt *time.Time
+		expected  error
+	}{
+		{"default lifetime", []api.Scope{api.ScopeRepoRead}, nil, nil},
+		{"expires in the future", []api.Scope{api.ScopeRepoRead}, &future, nil},
+		{"expires in the past", []api.Scope{api.ScopeRepoRead}, &past, InvalidExpiresAtError},
+		{"unknown scope", []api.Scope{"repo:unknown"}, nil, InvalidScopeError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newDatabase(t)
+			token, secret, err := d.AddToken("per", "per", "ci", test.scopes, test.expiresAt)
+			if err != test.expected {
+				t.Fatalf("expected %v but was %v", test.expected, err)
+			}
+			if err != nil {
+				return
+			}
+			if token.Hash == secret || token.Hash != hashSecret(secret) {
+				t.Errorf("expected the hash of the secret to be stored")
+			}
+			if len(d.GetTokens("per")) != 1 {
+				t.Errorf("expected 1 token but was %d", len(d.GetTokens("per")))
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	d := newDatabase(t)
+	token, secret, err := d.AddToken("per", "per", "ci", []api.Scope{api.ScopeRepoRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, expiredSecret, err := d.AddToken("per", "per", "old", []api.Scope{api.ScopeRepoRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name     string
+		secret   string
+		expected error
+	}{
+		{"valid secret", secret, nil},
+		{"expired token", expiredSecret, ExpiredTokenError},
+		{"unknown secret", SecretPrefix + "unknown", InvalidTokenError},
+		{"missing prefix", secret[len(SecretPrefix):], InvalidTokenError},
+		{"empty secret", "", InvalidTokenError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := d.Authenticate(test.secret)
+			if err != test.expected {
+				t.Fatalf("expected %v but was %v", test.expected, err)
+			}
+			if err == nil && actual.ID != token.ID {
+				t.Errorf("expected token %s but was %s", token.ID, actual.ID)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRemovedToken(t *testing.T) {
+	d := newDatabase(t)
+	token, secret, err := d.AddToken("per", "per", "ci", []api.Scope{api.ScopeRepoRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.RemoveToken("per", "per", token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Authenticate(secret); err != InvalidTokenError {
+		t.Errorf("expected %v but was %v", InvalidTokenError, err)
+	}
+}
+
+func TestAuthenticateStoresLastUsed(t *testing.T) {
+	d := newDatabase(t)
+	_, secret, err := d.AddToken("per", "per", "ci", []api.Scope{api.ScopeRepoRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Authenticate(secret); err != nil {
+		t.Fatal(err)
+	}
+
+	// The time is stored in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for d.GetTokens("per")[0].LastUsedAt == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the time when the token was last used to be stored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The tokens are read again, to make sure that the time is written and not only kept in memory
+	if err = d.reload(); err != nil {
+		t.Fatal(err)
+	}
+	lastUsedAt := d.GetTokens("per")[0].LastUsedAt
+	if lastUsedAt == nil {
+		t.Fatal("expected the time when the token was last used to be written")
+	}
+	if _, err = d.Authenticate(secret); err != nil {
+		t.Fatal(err)
+	}
+	if actual := d.GetTokens("per")[0].LastUsedAt; !actual.Equal(*lastUsedAt) {
+		t.Errorf("expected %v to be kept within LastUsedInterval but was %v", lastUsedAt, actual)
+	}
+}
@@ -1,6 +1,30 @@
 package user
 
+import "github.com/westcoastcode-se/gitgo/api"
+
 // EventUserAdded raised when a new user is added
 type EventUserAdded struct {
 	User *User
 }
+
+// EventUserRemoved raised when a user is removed
+type EventUserRemoved struct {
+	User *User
+}
+
+// EventUserChanged raised when a user's permissions or password is changed
+type EventUserChanged struct {
+	User *User
+}
+
+// EventPublicKeyAdded raised when a public key is registered for a user
+type EventPublicKeyAdded struct {
+	User *User
+	Key  api.PublicKey
+}
+
+// EventPublicKeyRemoved raised when a public key is removed from a user
+type EventPublicKeyRemoved struct {
+	User *User
+	Key  api.PublicKey
+}
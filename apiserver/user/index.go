@@ -0,0 +1,37 @@
+package user
+
+import "encoding/json"
+
+const (
+	// FingerprintIndex maps the fingerprint of each public key to the user that owns it
+	FingerprintIndex = "user-fingerprints"
+
+	// RepositoryAccessIndex maps each repository to the users that are explicitly granted access to it
+	RepositoryAccessIndex = "repository-access"
+)
+
+// indexFingerprints returns the fingerprints of the public keys of a stored user
+func indexFingerprints(entity []byte) ([]string, error) {
+	var user User
+	if err := json.Unmarshal(entity, &user); err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, key := range user.PublicKeys {
+		result = append(result, key.Fingerprint)
+	}
+	return result, nil
+}
+
+// indexRepositoryAccess returns the repositories a stored user is explicitly granted access to
+func indexRepositoryAccess(entity []byte) ([]string, error) {
+	var user User
+	if err := json.Unmarshal(entity, &user); err != nil {
+		return nil, err
+	}
+	var result []string
+	for repository := range user.Repositories {
+		result = append(result, repository)
+	}
+	return result, nil
+}
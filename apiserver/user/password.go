@@ -0,0 +1,39 @@
+package user
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the minimum number of characters a password must have
+const MinPasswordLength = 8
+
+var (
+	PasswordTooShortError  = errors.New("password is too short")
+	IncorrectPasswordError = errors.New("password is incorrect")
+)
+
+// dummyHash is compared against when a user doesn't exist, so that it takes the same amount of time
+// to authenticate an unknown user as a known one
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// HashPassword creates a bcrypt hash of the supplied password
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", PasswordTooShortError
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// VerifyPassword checks if the supplied password matches the user's password. A nil user is allowed
+func (u *User) VerifyPassword(password string) bool {
+	if u == nil || len(u.PasswordHash) == 0 {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
@@ -0,0 +1,45 @@
+package user
+
+import (
+	"errors"
+	"github.com/westcoastcode-se/gitgo/api"
+	"golang.org/x/crypto/ssh"
+	"regexp"
+	"strings"
+)
+
+var (
+	InvalidPublicKeyError       = errors.New("public key is not a valid authorized_keys line")
+	InvalidPublicKeyNameError   = errors.New("public key name is not valid")
+	PublicKeyNotFoundError      = errors.New("public key not found")
+	PublicKeyAlreadyExistsError = errors.New("public key is already registered")
+	PublicKeyNameTakenError     = errors.New("public key name is already used")
+)
+
+// ParsePublicKey parses a public key in the authorized_keys format. The fingerprint is calculated by the
+// server, so that we never have to trust a fingerprint supplied by a client. The comment is used as the name
+// if no name is supplied.
+func ParsePublicKey(name string, authorizedKey string) (api.PublicKey, error) {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return api.PublicKey{}, InvalidPublicKeyError
+	}
+	if len(name) == 0 {
+		name = comment
+	}
+	if !IsValidKeyName(name) {
+		return api.PublicKey{}, InvalidPublicKeyNameError
+	}
+	return api.PublicKey{
+		Name:        name,
+		Fingerprint: ssh.FingerprintSHA256(key),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+	}, nil
+}
+
+var validKeyNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-.@]+$`)
+
+// IsValidKeyName checks if the supplied name can be used as a public key name
+func IsValidKeyName(name string) bool {
+	return validKeyNameRegex.MatchString(name)
+}
@@ -1,21 +1,68 @@
 package user
 
-import "github.com/westcoastcode-se/gitgo/api"
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"regexp"
+)
 
 type Users struct {
 	Users []*User
 }
 
 type User struct {
-	Name       string
-	Password   string
+	Name string
+
+	// PasswordHash is a bcrypt hash of the user's password
+	PasswordHash string
+
+	// Password is the plaintext password used by older versions of the database. It's replaced with
+	// a hash when the database is loaded
+	Password string
+
 	PublicKeys []api.PublicKey
+
+	// Admin is set if the user has full access to all repositories
+	Admin bool
+
+	// Repositories contains the access level this user has been granted for each repository
+	Repositories map[string]api.Access
+
+	// Roles contains the roles assigned to the user on the whole server
+	Roles []string
+}
+
+// Permissions returns the permissions granted to this user. The permissions are a copy, so that they can be
+// extended with the permissions from organizations and roles without changing the user
+func (u *User) Permissions() *server.Permissions {
+	repositories := make(map[string]api.Access, len(u.Repositories))
+	for repository, access := range u.Repositories {
+		repositories[repository] = access
+	}
+	return &server.Permissions{
+		User:         u.Name,
+		Admin:        u.Admin,
+		Repositories: repositories,
+		// Users own all repositories in their own namespace
+		Namespaces: map[string]api.Access{u.Name: api.AccessAdmin},
+		Roles:      append([]string{}, u.Roles...),
+	}
 }
 
 func (u *User) ToApi() *api.User {
 	return &api.User{
-		Name:       u.Name,
-		Password:   u.Password,
-		PublicKeys: u.PublicKeys,
+		Name:         u.Name,
+		PublicKeys:   u.PublicKeys,
+		Admin:        u.Admin,
+		Repositories: u.Repositories,
+		Roles:        append([]string{}, u.Roles...),
 	}
 }
+
+// validNameRegex doesn't allow names starting with a dash, so that a name is never mistaken for an option
+var validNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_\-.]*$`)
+
+// IsValidName checks if the supplied name can be used as a user name
+func IsValidName(name string) bool {
+	return validNameRegex.MatchString(name)
+}
@@ -1,51 +1,174 @@
 package user
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/westcoastcode-se/gitgo/api"
 	"github.com/westcoastcode-se/gitgo/apiserver/db"
 	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"os"
+	"strings"
 	"sync"
 )
 
 const DatabasePath = "/users.json"
 
+var (
+	UserNotFoundError      = errors.New("user not found")
+	UserAlreadyExistsError = errors.New("user already exists")
+	InvalidNameError       = errors.New("user name is not valid")
+	LastAdminError         = errors.New("at least one administrator is required")
+	NameAlreadyUsedError   = errors.New("name is already used by an organization")
+)
+
+// NamespaceOwner uses names in the namespace that users share with others, such as organizations, since both can
+// own repositories
+type NamespaceOwner interface {
+	// OwnsName checks if the supplied name is used
+	OwnsName(name string) bool
+}
+
 type Database interface {
-	// AddUser adds the supplied user
-	AddUser(user *User) error
+	// Database reloads itself when the underlying data is changed
+	event.Listener
+
+	// AddUser adds the supplied user. NameAlreadyUsedError is returned if a namespace owner uses the name
+	AddUser(author string, user *User) error
+
+	// AddNamespaceOwner registers an owner of names in the namespace that users share with it
+	AddNamespaceOwner(owner NamespaceOwner)
+
+	// ClaimName calls the supplied function, which gives a name to a namespace owner, while no user can be added.
+	// UserAlreadyExistsError is returned if a user already has the name
+	ClaimName(name string, fn func() error) error
+
+	// GetUsers fetches a copy of all users
+	GetUsers() []*User
 
 	// GetUserUsingPublicKey can be called to search for potential users using a specific public key
 	GetUserUsingPublicKey(publicKey string) *User
+
+	// GetUser fetches a copy of the user with the supplied name. Returns nil if no user is found
+	GetUser(name string) *User
+
+	// GetRepositoryAccess fetches the access explicitly granted to each user for the supplied repository
+	GetRepositoryAccess(repository string) map[string]api.Access
+
+	// UpdateUser applies the supplied changes to a user
+	UpdateUser(author string, name string, changes *api.UserChanges) (*User, error)
+
+	// SetPassword changes the password for a user
+	SetPassword(author string, name string, password string) error
+
+	// RemoveUser removes the user with the supplied name
+	RemoveUser(author string, name string) error
+
+	// AddPublicKey registers a public key for a user. A public key can only be registered once across all users
+	AddPublicKey(author string, name string, key api.PublicKey) error
+
+	// RenamePublicKey changes the name of one of the user's public keys
+	RenamePublicKey(author string, name string, keyName string, newKeyName string) error
+
+	// RemovePublicKey removes one of the user's public keys
+	RemovePublicKey(author string, name string, keyName string) error
+
+	// RenameRepository moves all access granted to a repository so that it's granted to the new repository name.
+	// The users are written in the supplied transaction, and are changed once it's stored
+	RenameRepository(tx db.Transaction, oldName string, newName string) error
+
+	// RemoveRepository revokes all access granted to a removed repository. The users are written in the supplied
+	// transaction, and are changed once it's stored
+	RemoveRepository(tx db.Transaction, name string) error
+
+	// RemoveRole removes a role from all users it's assigned to
+	RemoveRole(author string, role string) error
+
+	// Bootstrap creates an administrator with a random password if no users exist. The password is returned
+	// if a user is created, otherwise an empty string is returned
+	Bootstrap(name string) (string, error)
 }
 
 type DatabaseImpl struct {
 	// Database is a generic json database
 	contentDatabase db.ContentDatabase
 
+	// processor is used when raising events about changed users
+	processor *event.Processor
+
+	// indexes is set if the content database keeps indexes of the users, so that they don't have to be scanned
+	indexes db.IndexedDatabase
+
 	users []*User
 	mutex *sync.RWMutex
+
+	// version of the users file when it was last read or written
+	version string
+
+	// namespaceMutex is locked while a name is given to a user or a namespace owner, so that it's never used twice
+	namespaceMutex sync.Mutex
+	owners         []NamespaceOwner
 }
 
-func (d *DatabaseImpl) AddUser(newUser *User) error {
+func (d *DatabaseImpl) AddUser(author string, newUser *User) error {
+	if !IsValidName(newUser.Name) {
+		return InvalidNameError
+	}
+
+	d.namespaceMutex.Lock()
+	defer d.namespaceMutex.Unlock()
+	for _, owner := range d.owners {
+		if owner.OwnsName(newUser.Name) {
+			return NameAlreadyUsedError
+		}
+	}
+
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	for _, user := range d.users {
-		if user.Name == newUser.Name {
-			return errors.New("user already exists")
-		}
+	if d.findUser(newUser.Name) != nil {
+		return UserAlreadyExistsError
 	}
-	d.users = append(d.users, newUser)
-	err := d.contentDatabase.Write(DatabasePath, &Users{d.users},
+	d.users = append(d.users, copyUser(newUser))
+	err := d.write(&Users{d.users}, author,
 		fmt.Sprintf("adding user %s", newUser.Name))
 	if err != nil {
+		d.users = d.users[:len(d.users)-1]
 		return err
 	}
-	// TODO post event on event queue
+	d.raiseEvent(&EventUserAdded{User: copyUser(newUser)})
 	return nil
 }
 
+func (d *DatabaseImpl) AddNamespaceOwner(owner NamespaceOwner) {
+	d.namespaceMutex.Lock()
+	defer d.namespaceMutex.Unlock()
+	d.owners = append(d.owners, owner)
+}
+
+func (d *DatabaseImpl) ClaimName(name string, fn func() error) error {
+	d.namespaceMutex.Lock()
+	defer d.namespaceMutex.Unlock()
+	if d.GetUser(name) != nil {
+		return UserAlreadyExistsError
+	}
+	return fn()
+}
+
+func (d *DatabaseImpl) GetUsers() []*User {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	result := make([]*User, len(d.users))
+	for i, user := range d.users {
+		result[i] = copyUser(user)
+	}
+	return result
+}
+
 func (d *DatabaseImpl) OnEvent(event event.Event) error {
 	if e, ok := event.(*db.EventDataChanged); ok {
 		if e.Path == DatabasePath {
@@ -59,50 +182,468 @@ func (d *DatabaseImpl) GetUserUsingPublicKey(fingerprint string) *User {
 	d.mutex.RLock()
 	defer d.mutex.RUnlock()
 
+	if d.indexes != nil {
+		names, err := d.indexes.Lookup(FingerprintIndex, fingerprint)
+		if err == nil {
+			if user := d.findUser(names[0]); user != nil {
+				return copyUser(user)
+			}
+			return nil
+		}
+		if errors.Is(err, db.IndexEntryNotFoundError) {
+			return nil
+		}
+		log.Printf("WARN: could not use index %s: %v\n", FingerprintIndex, err)
+	}
 	for _, user := range d.users {
 		for _, key := range user.PublicKeys {
 			if key.Fingerprint == fingerprint {
-				return user
+				return copyUser(user)
 			}
 		}
 	}
 	return nil
 }
 
+func (d *DatabaseImpl) GetUser(name string) *User {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if user := d.findUser(name); user != nil {
+		return copyUser(user)
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) GetRepositoryAccess(repository string) map[string]api.Access {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	result := map[string]api.Access{}
+	users := d.users
+	if d.indexes != nil {
+		names, err := d.indexes.Lookup(RepositoryAccessIndex, repository)
+		if err == nil || errors.Is(err, db.IndexEntryNotFoundError) {
+			users = nil
+			for _, name := range names {
+				if user := d.findUser(name); user != nil {
+					users = append(users, user)
+				}
+			}
+		} else {
+			log.Printf("WARN: could not use index %s: %v\n", RepositoryAccessIndex, err)
+		}
+	}
+	for _, user := range users {
+		if access, ok := user.Repositories[repository]; ok {
+			result[user.Name] = access
+		}
+	}
+	return result
+}
+
+func (d *DatabaseImpl) UpdateUser(author string, name string, changes *api.UserChanges) (*User, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	user := d.findUser(name)
+	if user == nil {
+		return nil, UserNotFoundError
+	}
+	if changes.Admin != nil && !*changes.Admin && user.Admin && d.countAdmins() == 1 {
+		return nil, LastAdminError
+	}
+
+	previous := *user
+	if changes.Admin != nil {
+		user.Admin = *changes.Admin
+	}
+	if changes.Repositories != nil {
+		user.Repositories = changes.Repositories
+	}
+	if changes.Roles != nil {
+		user.Roles = changes.Roles
+	}
+
+	err := d.write(&Users{d.users}, author,
+		fmt.Sprintf("updating user %s", name))
+	if err != nil {
+		*user = previous
+		return nil, err
+	}
+	d.raiseEvent(&EventUserChanged{User: copyUser(user)})
+	return copyUser(user), nil
+}
+
+func (d *DatabaseImpl) SetPassword(author string, name string, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	user := d.findUser(name)
+	if user == nil {
+		return UserNotFoundError
+	}
+
+	previous := user.PasswordHash
+	user.PasswordHash = hash
+	err = d.write(&Users{d.users}, author,
+		fmt.Sprintf("changing password for user %s", name))
+	if err != nil {
+		user.PasswordHash = previous
+		return err
+	}
+	d.raiseEvent(&EventUserChanged{User: copyUser(user)})
+	return nil
+}
+
+func (d *DatabaseImpl) RemoveUser(author string, name string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i, user := range d.users {
+		if user.Name != name {
+			continue
+		}
+		if user.Admin && d.countAdmins() == 1 {
+			return LastAdminError
+		}
+		users := append(append([]*User{}, d.users[:i]...), d.users[i+1:]...)
+		err := d.write(&Users{users}, author,
+			fmt.Sprintf("removing user %s", name))
+		if err != nil {
+			return err
+		}
+		d.users = users
+		d.raiseEvent(&EventUserRemoved{User: copyUser(user)})
+		return nil
+	}
+	return UserNotFoundError
+}
+
+func (d *DatabaseImpl) AddPublicKey(author string, name string, key api.PublicKey) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	user := d.findUser(name)
+	if user == nil {
+		return UserNotFoundError
+	}
+	for _, u := range d.users {
+		for _, k := range u.PublicKeys {
+			if k.Fingerprint == key.Fingerprint {
+				return PublicKeyAlreadyExistsError
+			}
+		}
+	}
+	if findPublicKey(user, key.Name) != -1 {
+		return PublicKeyNameTakenError
+	}
+
+	previous := user.PublicKeys
+	user.PublicKeys = append(append([]api.PublicKey{}, previous...), key)
+	err := d.write(&Users{d.users}, author,
+		fmt.Sprintf("adding public key %s to user %s", key.Name, name))
+	if err != nil {
+		user.PublicKeys = previous
+		return err
+	}
+	d.raiseEvent(&EventPublicKeyAdded{User: copyUser(user), Key: key})
+	return nil
+}
+
+func (d *DatabaseImpl) RenamePublicKey(author string, name string, keyName string, newKeyName string) error {
+	if !IsValidKeyName(newKeyName) {
+		return InvalidPublicKeyNameError
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	user := d.findUser(name)
+	if user == nil {
+		return UserNotFoundError
+	}
+	idx := findPublicKey(user, keyName)
+	if idx == -1 {
+		return PublicKeyNotFoundError
+	}
+	if keyName == newKeyName {
+		return nil
+	}
+	if findPublicKey(user, newKeyName) != -1 {
+		return PublicKeyNameTakenError
+	}
+
+	previous := user.PublicKeys
+	user.PublicKeys = append([]api.PublicKey{}, previous...)
+	user.PublicKeys[idx].Name = newKeyName
+	err := d.write(&Users{d.users}, author,
+		fmt.Sprintf("renaming public key %s to %s for user %s", keyName, newKeyName, name))
+	if err != nil {
+		user.PublicKeys = previous
+		return err
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) RemovePublicKey(author string, name string, keyName string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	user := d.findUser(name)
+	if user == nil {
+		return UserNotFoundError
+	}
+	idx := findPublicKey(user, keyName)
+	if idx == -1 {
+		return PublicKeyNotFoundError
+	}
+
+	previous := user.PublicKeys
+	user.PublicKeys = append(append([]api.PublicKey{}, previous[:idx]...), previous[idx+1:]...)
+	err := d.write(&Users{d.users}, author,
+		fmt.Sprintf("removing public key %s from user %s", keyName, name))
+	if err != nil {
+		user.PublicKeys = previous
+		return err
+	}
+	d.raiseEvent(&EventPublicKeyRemoved{User: copyUser(user), Key: previous[idx]})
+	return nil
+}
+
+func (d *DatabaseImpl) RenameRepository(tx db.Transaction, oldName string, newName string) error {
+	return d.moveAccess(tx, oldName, newName)
+}
+
+func (d *DatabaseImpl) RemoveRepository(tx db.Transaction, name string) error {
+	return d.moveAccess(tx, name, "")
+}
+
+// moveAccess moves all access granted to a repository to its new name. The access is revoked if the new name is
+// empty
+func (d *DatabaseImpl) moveAccess(tx db.Transaction, oldName string, newName string) error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var changed []*User
+	users := make([]*User, len(d.users))
+	for i, user := range d.users {
+		users[i] = user
+		if access, ok := user.Repositories[oldName]; ok {
+			renamed := copyUser(user)
+			delete(renamed.Repositories, oldName)
+			if len(newName) > 0 {
+				renamed.Repositories[newName] = access
+			}
+			users[i] = renamed
+			changed = append(changed, renamed)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return tx.WriteVersion(DatabasePath, &Users{users}, d.version, func(version string) {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		d.users = users
+		d.version = version
+		for _, user := range changed {
+			d.raiseEvent(&EventUserChanged{User: copyUser(user)})
+		}
+	})
+}
+
+func (d *DatabaseImpl) RemoveRole(author string, role string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var changed []*User
+	users := make([]*User, len(d.users))
+	for i, user := range d.users {
+		users[i] = user
+		for j, r := range user.Roles {
+			if r == role {
+				removed := copyUser(user)
+				removed.Roles = append(append([]string{}, user.Roles[:j]...), user.Roles[j+1:]...)
+				users[i] = removed
+				changed = append(changed, removed)
+				break
+			}
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	err := d.write(&Users{users}, author, fmt.Sprintf("removing role %s", role))
+	if err != nil {
+		return err
+	}
+	d.users = users
+	for _, user := range changed {
+		d.raiseEvent(&EventUserChanged{User: copyUser(user)})
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) Bootstrap(name string) (string, error) {
+	d.mutex.RLock()
+	empty := len(d.users) == 0
+	d.mutex.RUnlock()
+	if !empty {
+		return "", nil
+	}
+
+	bytes := make([]byte, 18)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(bytes)
+	hash, err := HashPassword(password)
+	if err != nil {
+		return "", err
+	}
+	err = d.AddUser(db.SystemAuthor, &User{
+		Name:         name,
+		PasswordHash: hash,
+		PublicKeys:   []api.PublicKey{},
+		Admin:        true,
+		Repositories: map[string]api.Access{},
+	})
+	if err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// raiseEvent sends the supplied event to the rest of the server
+func (d *DatabaseImpl) raiseEvent(e event.Event) {
+	if d.processor != nil {
+		if err := d.processor.RaiseEvent(e); err != nil {
+			log.Printf("WARN: could not raise event: %v\n", err)
+		}
+	}
+}
+
+// copyUser creates a copy of the supplied user, so that it can be returned, or sent in an event, without
+// being changed afterwards
+func copyUser(user *User) *User {
+	result := *user
+	result.PublicKeys = append([]api.PublicKey{}, user.PublicKeys...)
+	result.Roles = append([]string{}, user.Roles...)
+	result.Repositories = map[string]api.Access{}
+	for repository, access := range user.Repositories {
+		result.Repositories[repository] = access
+	}
+	return &result
+}
+
+func (d *DatabaseImpl) findUser(name string) *User {
+	for _, user := range d.users {
+		if user.Name == name {
+			return user
+		}
+	}
+	return nil
+}
+
+// findPublicKey returns the index of the user's public key with the supplied name, or -1 if it's not found
+func findPublicKey(user *User, keyName string) int {
+	for i, key := range user.PublicKeys {
+		if key.Name == keyName {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *DatabaseImpl) countAdmins() int {
+	count := 0
+	for _, user := range d.users {
+		if user.Admin {
+			count++
+		}
+	}
+	return count
+}
+
+// write the supplied users, if nobody else has changed the file since it was read. The mutex must be locked
+// by the caller
+func (d *DatabaseImpl) write(users *Users, author string, message string) error {
+	version, err := d.contentDatabase.WriteVersion(DatabasePath, users, d.version, author, message)
+	if err != nil {
+		return err
+	}
+	d.version = version
+	return nil
+}
+
 func (d *DatabaseImpl) reload() error {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
 	var users Users
-	err := d.contentDatabase.Read(DatabasePath, &users)
+	version, err := d.contentDatabase.ReadVersion(DatabasePath, &users)
 	if err != nil {
+		if os.IsNotExist(err) {
+			d.version = version
+		}
 		return err
 	}
+	d.version = version
 	d.users = users.Users
-	return nil
+	return d.migratePasswords()
+}
+
+// migratePasswords replaces plaintext passwords, used by older versions of the database, with password hashes
+func (d *DatabaseImpl) migratePasswords() error {
+	var migrated []string
+	for _, user := range d.users {
+		if len(user.Password) == 0 {
+			continue
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = string(hash)
+		user.Password = ""
+		migrated = append(migrated, user.Name)
+	}
+	if len(migrated) == 0 {
+		return nil
+	}
+	return d.write(&Users{d.users}, db.SystemAuthor,
+		fmt.Sprintf("hashing passwords for users %s", strings.Join(migrated, ", ")))
 }
 
-func New(database db.ContentDatabase) Database {
-	return &DatabaseImpl{
+func New(database db.ContentDatabase, processor *event.Processor) (Database, error) {
+	result := &DatabaseImpl{
 		contentDatabase: database,
-		users: []*User{
-			{
-				Name:       "superuser",
-				Password:   "superuser",
-				PublicKeys: []api.PublicKey{},
-			},
-			{
-				Name:     "per",
-				Password: "password",
-				PublicKeys: []api.PublicKey{
-					{
-						Name:        "MacOSX",
-						Fingerprint: "SHA256:MWhJKWXhJL635pzRPGjovQbrLZcTpwB7g2NWdifQKIQ",
-						PublicKey:   "",
-					},
-				},
-			},
-		},
-		mutex: &sync.RWMutex{},
+		processor:       processor,
+		users:           []*User{},
+		mutex:           &sync.RWMutex{},
+		version:         db.MissingVersion,
+	}
+	if indexes, ok := database.(db.IndexedDatabase); ok {
+		if err := indexes.AddCollection(DatabasePath, db.Collection{Field: "Users", Key: "Name"}); err != nil {
+			return nil, err
+		}
+		if err := indexes.AddIndex(FingerprintIndex, DatabasePath, indexFingerprints); err != nil {
+			return nil, err
+		}
+		if err := indexes.AddIndex(RepositoryAccessIndex, DatabasePath, indexRepositoryAccess); err != nil {
+			return nil, err
+		}
+		result.indexes = indexes
+	}
+
+	if err := result.reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
+	return result, nil
 }
@@ -0,0 +1,105 @@
+package web
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/routes"
+	"net/http"
+	"strings"
+)
+
+// authenticate resolves the user, or service, making the supplied request together with the permissions the
+// request is granted. Client-side certificates are preferred, but HTTP Basic authentication is allowed so that
+// human administrators don't need a personal client certificate, and personal access tokens are allowed so that
+// automated jobs don't need one either. A nil user is returned if the request is not authenticated at all
+func (s *Server) authenticate(r *http.Request) (*user.User, *server.Permissions, responses.RequestError) {
+	var commonName = TryExtractCommonName(r.TLS)
+	if len(commonName) > 0 {
+		return s.authenticateCertificate(commonName, r.TLS.VerifiedChains[0][0])
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		t, err := s.Tokens.Authenticate(strings.TrimPrefix(authorization, "Bearer "))
+		if err != nil {
+			return nil, nil, &responses.UnauthorizedError{Message: err.Error()}
+		}
+		u := s.Users.GetUser(t.User)
+		if u == nil {
+			return nil, nil, &responses.UnauthorizedError{Message: "token is not valid"}
+		}
+		permissions := u.Permissions()
+		permissions.Scopes = append(permissions.Scopes[:0:0], t.Scopes...)
+		return u, permissions, nil
+	}
+
+	if name, password, ok := r.BasicAuth(); ok {
+		u := s.Users.GetUser(name)
+		if !u.VerifyPassword(password) {
+			return nil, nil, &responses.UnauthorizedError{Message: "invalid user name or password"}
+		}
+		return u, u.Permissions(), nil
+	}
+	return nil, server.MissingPermissions, nil
+}
+
+// authorize is a middleware that verifies that the request is granted the permission required by the route. The
+// permissions granted through organizations, teams and roles are resolved before the route is served
+func (s *Server) authorize(permission api.Permission, next routes.Route) routes.Route {
+	return routes.RouteFunc(func(request *routes.Request) error {
+		permissions := *request.Permissions()
+		for _, provider := range s.providers() {
+			provider.AddPermissions(permissions.User, &permissions)
+		}
+		request.Context.SetValue(server.ContextPermissions, &permissions)
+
+		if err := request.Require(permission, request.Resource()); err != nil {
+			return err
+		}
+		return next.ServeRoute(request)
+	})
+}
+
+// providers returns everything that grants users permissions, other than the users themselves
+func (s *Server) providers() []server.PermissionProvider {
+	return []server.PermissionProvider{s.Organizations, s.Roles}
+}
+
+// authenticateCertificate resolves the identity a client-side certificate with the supplied common name is mapped
+// to. Certificates that aren't mapped are only accepted if they are issued to the user by the certificate authority
+func (s *Server) authenticateCertificate(commonName string, cert *x509.Certificate) (*user.User,
+	*server.Permissions, responses.RequestError) {
+	principal, ok := s.Config.Principals[commonName]
+	if !ok {
+		if !s.Config.CertificateUsers || !s.isIssuedToUser(commonName, cert) {
+			return nil, nil, &responses.UnauthorizedError{Message: "certificate is not mapped to an identity"}
+		}
+		principal = server.Principal{User: commonName}
+	}
+
+	// Services are not users, so they are never granted the permissions of a user with the same name
+	if len(principal.Service) > 0 {
+		permissions := &server.Permissions{
+			Service: principal.Service,
+			Roles:   append([]string{}, principal.Roles...),
+		}
+		return &user.User{Name: principal.Service}, permissions, nil
+	}
+
+	u := s.Users.GetUser(principal.User)
+	if u == nil {
+		return nil, nil, &responses.UnauthorizedError{Message: "certificate is not mapped to an identity"}
+	}
+	return u, u.Permissions(), nil
+}
+
+// isIssuedToUser checks that the supplied certificate is a client-side certificate issued to the user with the
+// supplied name, and not just any certificate signed by the certificate authority with the same common name
+func (s *Server) isIssuedToUser(name string, cert *x509.Certificate) bool {
+	c := s.Certificates.GetCertificate(fmt.Sprintf("%x", cert.SerialNumber))
+	return c != nil && c.CommonName == name && c.Type == api.CertificateTypeClient && !c.IsRevoked()
+}
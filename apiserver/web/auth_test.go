@@ -0,0 +1,63 @@
+package web
+
+import (
+	"crypto/x509"
+	"github.com/westcoastcode-se/gitgo/api"
+	"math/big"
+	"testing"
+)
+
+func TestAuthenticateCertificate(t *testing.T) {
+	s := newTestServer(t)
+	s.Certificates = newCertificates(t)
+	s.Config.CertificateUsers = true
+	issued := issue(t, s.Certificates, "per", api.CertificateTypeClient)
+	serverSide := issue(t, s.Certificates, "per", api.CertificateTypeServer)
+	revoked := issue(t, s.Certificates, "per", api.CertificateTypeClient)
+	if err := s.Certificates.Revoke("superuser", revoked.SerialNumber.Text(16)); err != nil {
+		t.Fatal(err)
+	}
+	// Signed by the certificate authority, but never issued by the api server
+	forged := &x509.Certificate{SerialNumber: big.NewInt(4711)}
+
+	tests := []struct {
+		name       string
+		commonName string
+		cert       *x509.Certificate
+		expected   string
+	}{
+		{"issued certificate", "per", issued, "per"},
+		{"issued to another user", "bob", issued, ""},
+		{"forged certificate", "per", forged, ""},
+		{"server certificate", "per", serverSide, ""},
+		{"revoked certificate", "per", revoked, ""},
+		{"service principal", "apiserverclient", forged, "gitserver"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u, _, err := s.authenticateCertificate(test.commonName, test.cert)
+			if len(test.expected) == 0 {
+				if err == nil {
+					t.Errorf("expected the certificate to be rejected but it authenticated as %s", u.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected the certificate to authenticate as %s but was %v", test.expected, err)
+			}
+			if u.Name != test.expected {
+				t.Errorf("expected %s but was %s", test.expected, u.Name)
+			}
+		})
+	}
+}
+
+func TestAuthenticateCertificateUsersDisabled(t *testing.T) {
+	s := newTestServer(t)
+	s.Certificates = newCertificates(t)
+	issued := issue(t, s.Certificates, "per", api.CertificateTypeClient)
+
+	if u, _, err := s.authenticateCertificate("per", issued); err == nil {
+		t.Errorf("expected the certificate to be rejected but it authenticated as %s", u.Name)
+	}
+}
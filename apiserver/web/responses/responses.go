@@ -2,13 +2,131 @@ package responses
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/westcoastcode-se/gitgo/api"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type RequestError interface {
+	error
 	Reason() string
 	StatusCode() int
+
+	// Code returns a machine-readable code that describes the error
+	Code() string
+}
+
+type BadRequestError struct {
+	Message string
+}
+
+func (e *BadRequestError) Reason() string {
+	return "Bad Request"
+}
+
+func (e *BadRequestError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e *BadRequestError) Code() string {
+	return api.ErrorCodeBadRequest
+}
+
+func (e *BadRequestError) Error() string {
+	return e.Message
+}
+
+// ValidationError is returned when one or more fields in the request body are not valid
+type ValidationError struct {
+	Message string
+	Fields  []api.FieldError
+}
+
+func (e *ValidationError) Reason() string {
+	return "Bad Request"
+}
+
+func (e *ValidationError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e *ValidationError) Code() string {
+	return api.ErrorCodeValidation
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// NewFieldError creates a validation error for a single field
+func NewFieldError(field string, message string) *ValidationError {
+	return &ValidationError{
+		Message: "validation failed",
+		Fields:  []api.FieldError{{Field: field, Message: message}},
+	}
+}
+
+type UnauthorizedError struct {
+	Message string
+}
+
+func (e *UnauthorizedError) Reason() string {
+	return "Unauthorized"
+}
+
+func (e *UnauthorizedError) StatusCode() int {
+	return http.StatusUnauthorized
+}
+
+func (e *UnauthorizedError) Code() string {
+	return api.ErrorCodeUnauthorized
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.Message
+}
+
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Reason() string {
+	return "Forbidden"
+}
+
+func (e *ForbiddenError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+func (e *ForbiddenError) Code() string {
+	return api.ErrorCodeForbidden
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
+
+// PermissionDeniedError is returned when the request is not granted the permission required to serve it
+type PermissionDeniedError struct {
+	Permission api.Permission
+}
+
+func (e *PermissionDeniedError) Reason() string {
+	return "Forbidden"
+}
+
+func (e *PermissionDeniedError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+func (e *PermissionDeniedError) Code() string {
+	return api.ErrorCodeForbidden
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("permission %s is required", e.Permission)
 }
 
 type NotFoundError struct {
@@ -23,13 +141,128 @@ func (e *NotFoundError) StatusCode() int {
 	return http.StatusNotFound
 }
 
+func (e *NotFoundError) Code() string {
+	return api.ErrorCodeNotFound
+}
+
 func (e *NotFoundError) Error() string {
 	return e.Message
 }
 
+type MethodNotAllowedError struct {
+	Message string
+}
+
+func (e *MethodNotAllowedError) Reason() string {
+	return "Method Not Allowed"
+}
+
+func (e *MethodNotAllowedError) StatusCode() int {
+	return http.StatusMethodNotAllowed
+}
+
+func (e *MethodNotAllowedError) Code() string {
+	return api.ErrorCodeMethodNotAllowed
+}
+
+func (e *MethodNotAllowedError) Error() string {
+	return e.Message
+}
+
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Reason() string {
+	return "Conflict"
+}
+
+func (e *ConflictError) StatusCode() int {
+	return http.StatusConflict
+}
+
+func (e *ConflictError) Code() string {
+	return api.ErrorCodeConflict
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// RateLimitedError is returned when the caller has sent too many requests
+type RateLimitedError struct {
+	Message string
+
+	// RetryAfter is how long the caller should wait before trying again
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Reason() string {
+	return "Too Many Requests"
+}
+
+func (e *RateLimitedError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+func (e *RateLimitedError) Code() string {
+	return api.ErrorCodeRateLimited
+}
+
+func (e *RateLimitedError) Error() string {
+	return e.Message
+}
+
+type InternalError struct {
+	Message string
+}
+
+func (e *InternalError) Reason() string {
+	return "Internal Server Error"
+}
+
+func (e *InternalError) StatusCode() int {
+	return http.StatusInternalServerError
+}
+
+func (e *InternalError) Code() string {
+	return api.ErrorCodeInternal
+}
+
+func (e *InternalError) Error() string {
+	return e.Message
+}
+
+// ToRequestError converts the supplied error into a request error. Errors that are not request errors
+// are considered to be internal errors. The original message is not sent to the client, since it might
+// contain details about the server
+func ToRequestError(err error) RequestError {
+	if requestError, ok := err.(RequestError); ok {
+		return requestError
+	}
+	return &InternalError{Message: "an internal error occurred"}
+}
+
 // WriteError writes the supplied error
-func WriteError(uri string, err RequestError, rw http.ResponseWriter) (int, error) {
-	bytes, _ := json.Marshal(&api.Error{URI: uri, Reason: err.Reason()})
+func WriteError(uri string, requestUUID string, err RequestError, rw http.ResponseWriter) (int, error) {
+	body := &api.Error{
+		Code:        err.Code(),
+		URI:         uri,
+		Reason:      err.Reason(),
+		Message:     err.Error(),
+		RequestUUID: requestUUID,
+	}
+	switch e := err.(type) {
+	case *ValidationError:
+		body.Fields = e.Fields
+	case *PermissionDeniedError:
+		body.Permission = e.Permission
+	case *RateLimitedError:
+		rw.Header().Set("Retry-After", strconv.Itoa(int(e.RetryAfter.Seconds())))
+	}
+
+	bytes, _ := json.Marshal(body)
+	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(err.StatusCode())
 	return rw.Write(bytes)
 }
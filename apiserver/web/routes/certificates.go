@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/pki"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"net/http"
+)
+
+// CertificatesPath is the uri where all certificate routes are located
+const CertificatesPath = "/api/v1/certificates"
+
+// Certificates is a route used by administrators when issuing and revoking certificates using the api server's
+// certificate authority. Everyone is allowed to download the certificate of the certificate authority and the
+// certificate revocation list
+//
+// GET    /api/v1/certificates
+// POST   /api/v1/certificates
+// GET    /api/v1/certificates/ca
+// GET    /api/v1/certificates/crl
+// GET    /api/v1/certificates/{serial}
+// DELETE /api/v1/certificates/{serial}
+type Certificates struct {
+	Certificates pki.Database
+}
+
+// Register adds all certificate routes to the supplied router
+func (h *Certificates) Register(router *Router) {
+	router.HandleFunc(http.MethodGet, CertificatesPath, api.PermissionCertificatesManage, h.list)
+	router.HandleFunc(http.MethodPost, CertificatesPath, api.PermissionCertificatesManage, h.issue)
+	router.HandleFunc(http.MethodGet, CertificatesPath+"/ca", api.PermissionNone, h.authority)
+	router.HandleFunc(http.MethodGet, CertificatesPath+"/crl", api.PermissionNone, h.crl)
+	router.HandleFunc(http.MethodGet, CertificatesPath+"/{serial}", api.PermissionCertificatesManage, h.get)
+	router.HandleFunc(http.MethodDelete, CertificatesPath+"/{serial}", api.PermissionCertificatesManage, h.revoke)
+}
+
+func (h *Certificates) list(request *Request) error {
+	result := api.Certificates{Certificates: []api.Certificate{}}
+	for _, c := range h.Certificates.GetCertificates() {
+		result.Certificates = append(result.Certificates, *c.ToApi())
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Certificates) issue(request *Request) error {
+	var body api.NewCertificate
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+	c, keyPair, err := h.Certificates.Issue(request.Author(), &body)
+	if err != nil {
+		return toCertificateRequestError(err)
+	}
+	bytes, _ := json.Marshal(&api.IssuedCertificate{
+		Certificate:    *c.ToApi(),
+		CertificatePEM: string(keyPair.Certificate),
+		PrivateKeyPEM:  string(keyPair.PrivateKey),
+	})
+	_, _ = request.Created(bytes)
+	return nil
+}
+
+func (h *Certificates) authority(request *Request) error {
+	writePEM(request, h.Certificates.Authority().PEM())
+	return nil
+}
+
+func (h *Certificates) crl(request *Request) error {
+	crl := h.Certificates.CRL()
+	if len(crl) == 0 {
+		return &responses.NotFoundError{Message: "there is no certificate revocation list"}
+	}
+	writePEM(request, crl)
+	return nil
+}
+
+func (h *Certificates) get(request *Request) error {
+	c := h.Certificates.GetCertificate(request.Param("serial"))
+	if c == nil {
+		return &responses.NotFoundError{Message: pki.CertificateNotFoundError.Error()}
+	}
+	bytes, _ := json.Marshal(c.ToApi())
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Certificates) revoke(request *Request) error {
+	if err := h.Certificates.Revoke(request.Author(), request.Param("serial")); err != nil {
+		return toCertificateRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+// writePEM writes the supplied PEM encoded data as the response
+func writePEM(request *Request, data []byte) {
+	request.Response.Header().Set("Content-Type", "application/x-pem-file")
+	request.Response.WriteHeader(http.StatusOK)
+	_, _ = request.Response.Write(data)
+}
+
+// toCertificateRequestError converts errors from the certificate database into request errors
+func toCertificateRequestError(err error) error {
+	switch err {
+	case pki.CertificateNotFoundError:
+		return &responses.NotFoundError{Message: err.Error()}
+	case pki.AlreadyRevokedError:
+		return &responses.ConflictError{Message: err.Error()}
+	case pki.InvalidCommonNameError:
+		return responses.NewFieldError("CommonName", err.Error())
+	case pki.InvalidTypeError:
+		return responses.NewFieldError("Type", err.Error())
+	case pki.InvalidIPAddressError:
+		return responses.NewFieldError("IPAddresses", err.Error())
+	case pki.InvalidNotAfterError:
+		return responses.NewFieldError("NotAfter", err.Error())
+	case pki.AuthorityKeyMissingError:
+		return &responses.ConflictError{Message: err.Error()}
+	}
+	return err
+}
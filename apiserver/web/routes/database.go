@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/gitdb"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"net/http"
+	"strconv"
+)
+
+// DatabasePath is the uri used when managing the api server's database
+const DatabasePath = "/api/v1/database"
+
+// Database is a route used by administrators to view the history of the data stored by the api server, such as
+// users and repositories, and to revert it to an earlier revision. It's only available if the database keeps
+// a history
+//
+// GET  /api/v1/database/history?path={path}&limit={limit}
+// POST /api/v1/database/revert
+type Database struct {
+	Database db.ContentDatabase
+}
+
+// Register adds the database routes to the supplied router
+func (h *Database) Register(router *Router) {
+	router.HandleFunc(http.MethodGet, DatabasePath+"/history", api.PermissionDatabaseManage, h.history)
+	router.HandleFunc(http.MethodPost, DatabasePath+"/revert", api.PermissionDatabaseManage, h.revert)
+}
+
+// versioned returns the database if it keeps a history
+func (h *Database) versioned(request *Request) (db.VersionedDatabase, error) {
+	versioned, ok := h.Database.(db.VersionedDatabase)
+	if !ok {
+		return nil, &responses.NotFoundError{Message: "the database does not keep a history"}
+	}
+	return versioned, nil
+}
+
+func (h *Database) history(request *Request) error {
+	versioned, err := h.versioned(request)
+	if err != nil {
+		return err
+	}
+	path := request.Query("path")
+	if len(path) == 0 {
+		return &responses.BadRequestError{Message: "query parameter 'path' is required"}
+	}
+	limit := 50
+	if value := request.Query("limit"); len(value) > 0 {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			return &responses.BadRequestError{Message: "query parameter 'limit' must be a positive number"}
+		}
+	}
+
+	revisions, err := versioned.History(path, limit)
+	if err != nil {
+		return toDatabaseRequestError(err)
+	}
+	result := api.DatabaseRevisions{Path: path, Revisions: []api.DatabaseRevision{}}
+	for _, r := range revisions {
+		result.Revisions = append(result.Revisions, api.DatabaseRevision{
+			ID:        r.ID,
+			Author:    r.Author,
+			Message:   r.Message,
+			CreatedAt: r.CreatedAt,
+		})
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Database) revert(request *Request) error {
+	versioned, err := h.versioned(request)
+	if err != nil {
+		return err
+	}
+	var body api.DatabaseRevert
+	if err = request.ReadBody(&body); err != nil {
+		return err
+	}
+	if err = versioned.Revert(body.Path, body.Revision, request.Author()); err != nil {
+		return toDatabaseRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+// toDatabaseRequestError converts errors from the database into request errors
+func toDatabaseRequestError(err error) error {
+	switch err {
+	case gitdb.InvalidPathError:
+		return responses.NewFieldError("Path", err.Error())
+	case gitdb.InvalidRevisionError:
+		return responses.NewFieldError("Revision", err.Error())
+	case gitdb.RevisionNotFoundError:
+		return &responses.NotFoundError{Message: err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/eventlog"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// EventsPath is the uri where the event log is read
+const EventsPath = "/api/v1/events"
+
+// DefaultEventLimit is how many events are returned if no limit is supplied
+const DefaultEventLimit = 100
+
+// EventLog is a route used by administrators, and others with the events:read permission, when reading the
+// event log. Clients remember the sequence number of the latest event they have seen, so that they can
+// catch up after being offline.
+//
+// The stream is sent as server-sent events, where the id is the sequence number. The stream is closed before
+// the server's write timeout, after which the client is expected to reconnect using the "Last-Event-ID" header
+//
+// GET /api/v1/events?since={sequence}&limit={limit}&wait={seconds}
+// GET /api/v1/events/stream?since={sequence}
+type EventLog struct {
+	Log *eventlog.Log
+
+	// MaxWait is the longest time a request is allowed to wait. It must be shorter than the server's write timeout
+	MaxWait time.Duration
+}
+
+// Register adds the event log routes to the supplied router
+func (h *EventLog) Register(router *Router) {
+	router.HandleFunc(http.MethodGet, EventsPath, api.PermissionEventsRead, h.list)
+	router.HandleFunc(http.MethodGet, EventsPath+"/stream", api.PermissionEventsRead, h.stream)
+}
+
+func (h *EventLog) list(request *Request) error {
+	since, err := parseSequence(request.Query("since"))
+	if err != nil {
+		return err
+	}
+
+	limit := DefaultEventLimit
+	if value := request.Query("limit"); len(value) > 0 {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > 1000 {
+			return &responses.BadRequestError{Message: "query parameter 'limit' must be a number between 1 and 1000"}
+		}
+	}
+
+	var wait time.Duration
+	if value := request.Query("wait"); len(value) > 0 {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return &responses.BadRequestError{Message: "query parameter 'wait' must be a positive number"}
+		}
+		wait = time.Duration(seconds) * time.Second
+		if wait > h.MaxWait {
+			wait = h.MaxWait
+		}
+	}
+
+	result, err := h.Log.Wait(request.Original.Context(), since, limit, wait)
+	if err != nil {
+		return err
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *EventLog) stream(request *Request) error {
+	value := request.Original.Header.Get("Last-Event-ID")
+	if len(value) == 0 {
+		value = request.Query("since")
+	}
+	since, err := parseSequence(value)
+	if err != nil {
+		return err
+	}
+	flusher, ok := request.Response.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming is not supported by the connection")
+	}
+
+	rw := request.Response
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(rw, "retry: 1000\n\n")
+	flusher.Flush()
+
+	ctx := request.Original.Context()
+	deadline := time.Now().Add(h.MaxWait)
+	for remaining := h.MaxWait; remaining > 0 && ctx.Err() == nil; remaining = time.Until(deadline) {
+		result, err := h.Log.Wait(ctx, since, DefaultEventLimit, remaining)
+		if err != nil {
+			log.Printf("WARN: could not read events after %d: %v\n", since, err)
+			return nil
+		}
+		for _, record := range result.Records {
+			bytes, _ := json.Marshal(&record)
+			if _, err = fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", record.Sequence, record.Type,
+				bytes); err != nil {
+				return nil
+			}
+		}
+		flusher.Flush()
+		since = result.Sequence
+	}
+	return nil
+}
+
+func parseSequence(value string) (uint64, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	sequence, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, &responses.BadRequestError{Message: "the sequence number must be a positive number"}
+	}
+	return sequence, nil
+}
@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"net/http"
+)
+
+// ReportedEventsPath is the uri where other services report events
+const ReportedEventsPath = InternalPath + "/events"
+
+// Events is a route used by trusted services, such as the git server, when reporting pushes and fetches. The
+// events are raised using the event processor, so that listeners inside the api server are told about them
+//
+// POST /internal/v1/events
+type Events struct {
+	Repositories repository.Database
+	Processor    *event.Processor
+}
+
+// Register adds the events route to the supplied router
+func (h *Events) Register(router *Router) {
+	router.Handle(http.MethodPost, ReportedEventsPath, api.PermissionEventsReport, h)
+}
+
+func (h *Events) ServeRoute(request *Request) error {
+	var body api.GitEvent
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+	if r := h.Repositories.GetRepository(body.Repository); r == nil || r.Deleted {
+		return &responses.NotFoundError{Message: "repository not found"}
+	}
+
+	var evt event.Event
+	switch body.Type {
+	case api.GitEventPush:
+		evt = &repository.EventRepositoryPushed{
+			Repository: body.Repository,
+			User:       body.User,
+			Protocol:   body.Protocol,
+			RefUpdates: body.RefUpdates,
+		}
+	case api.GitEventFetch:
+		evt = &repository.EventRepositoryFetched{
+			Repository: body.Repository,
+			User:       body.User,
+			Protocol:   body.Protocol,
+		}
+	default:
+		return responses.NewFieldError("Type", "unknown event type")
+	}
+	if err := h.Processor.RaiseEvent(evt); err != nil {
+		return err
+	}
+	request.NoContent()
+	return nil
+}
@@ -0,0 +1,9 @@
+package routes
+
+// InternalPath is the uri where all internal routes are located. The internal api is used by trusted services,
+// such as the git server, when authenticating users and reporting what they do. It's not a part of the public
+// api and users are not allowed to use it
+const InternalPath = "/internal/v1"
+
+// InternalRepositoryPath is the uri where the internal routes for a single repository are located
+const InternalRepositoryPath = InternalPath + "/repositories/{owner}/{repository}"
@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/invalidation"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// InvalidationsPath is the uri where clients wait for cache invalidations
+const InvalidationsPath = InternalPath + "/invalidations"
+
+// Invalidations is a long-poll route used by clients, such as the git server, that caches users and access
+// levels. The request waits until something is changed, or until the wait time has passed, so that
+// clients are told about revoked keys and permissions within seconds
+//
+// GET /internal/v1/invalidations?epoch={epoch}&since={sequence}&wait={seconds}
+type Invalidations struct {
+	Broker *invalidation.Broker
+
+	// MaxWait is the longest time a request is allowed to wait. It must be shorter than the server's write timeout
+	MaxWait time.Duration
+}
+
+// Register adds the invalidations route to the supplied router
+func (h *Invalidations) Register(router *Router) {
+	router.Handle(http.MethodGet, InvalidationsPath, api.PermissionEventsRead, h)
+}
+
+func (h *Invalidations) ServeRoute(request *Request) error {
+	var since uint64
+	if value := request.Query("since"); len(value) > 0 {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return &responses.BadRequestError{Message: "query parameter 'since' must be a number"}
+		}
+	}
+
+	wait := h.MaxWait
+	if value := request.Query("wait"); len(value) > 0 {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return &responses.BadRequestError{Message: "query parameter 'wait' must be a positive number"}
+		}
+		if d := time.Duration(seconds) * time.Second; d < wait {
+			wait = d
+		}
+	}
+
+	result := h.Broker.Wait(request.Original.Context(), request.Query("epoch"), since, wait)
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
@@ -0,0 +1,323 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/organization"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"net/http"
+	"sort"
+)
+
+// OrganizationsPath is the uri where all organization routes are located
+const OrganizationsPath = "/api/v1/organizations"
+
+// Organizations is a route used when managing organizations, their members and their teams. Members are
+// allowed to see an organization, while owners are allowed to change it
+//
+// GET    /api/v1/organizations
+// POST   /api/v1/organizations
+// GET    /api/v1/organizations/{organization}
+// DELETE /api/v1/organizations/{organization}
+// GET    /api/v1/organizations/{organization}/members
+// PUT    /api/v1/organizations/{organization}/members/{user}
+// DELETE /api/v1/organizations/{organization}/members/{user}
+// GET    /api/v1/organizations/{organization}/teams
+// POST   /api/v1/organizations/{organization}/teams
+// GET    /api/v1/organizations/{organization}/teams/{team}
+// DELETE /api/v1/organizations/{organization}/teams/{team}
+// PUT    /api/v1/organizations/{organization}/teams/{team}/members/{user}
+// DELETE /api/v1/organizations/{organization}/teams/{team}/members/{user}
+// PUT    /api/v1/organizations/{organization}/teams/{team}/repositories/{owner}/{repository}
+// DELETE /api/v1/organizations/{organization}/teams/{team}/repositories/{owner}/{repository}
+type Organizations struct {
+	Organizations organization.Database
+	Users         user.Database
+	Repositories  repository.Database
+}
+
+// Register adds all organization routes to the supplied router
+func (h *Organizations) Register(router *Router) {
+	path := OrganizationsPath + "/{organization}"
+	read, manage := api.PermissionOrganizationRead, api.PermissionOrganizationManage
+	router.HandleFunc(http.MethodGet, OrganizationsPath, api.PermissionNone, h.list)
+	router.HandleFunc(http.MethodPost, OrganizationsPath, api.PermissionOrganizationCreate, h.create)
+	router.HandleFunc(http.MethodGet, path, read, h.get)
+	router.HandleFunc(http.MethodDelete, path, manage, h.remove)
+	router.HandleFunc(http.MethodGet, path+"/members", read, h.members)
+	router.HandleFunc(http.MethodPut, path+"/members/{user}", manage, h.setMember)
+	router.HandleFunc(http.MethodDelete, path+"/members/{user}", read, h.removeMember)
+	router.HandleFunc(http.MethodGet, path+"/teams", read, h.teams)
+	router.HandleFunc(http.MethodPost, path+"/teams", manage, h.createTeam)
+	router.HandleFunc(http.MethodGet, path+"/teams/{team}", read, h.team)
+	router.HandleFunc(http.MethodDelete, path+"/teams/{team}", manage, h.removeTeam)
+	router.HandleFunc(http.MethodPut, path+"/teams/{team}/members/{user}", manage, h.addTeamMember)
+	router.HandleFunc(http.MethodDelete, path+"/teams/{team}/members/{user}", manage, h.removeTeamMember)
+	router.HandleFunc(http.MethodPut, path+"/teams/{team}/repositories/{owner}/{repository}", manage,
+		h.setTeamAccess)
+	router.HandleFunc(http.MethodDelete, path+"/teams/{team}/repositories/{owner}/{repository}", manage,
+		h.revokeTeamAccess)
+}
+
+func (h *Organizations) list(request *Request) error {
+	permissions := request.Permissions()
+	result := api.Organizations{Organizations: []api.Organization{}}
+	for _, o := range h.Organizations.GetOrganizations() {
+		if permissions.Has(api.PermissionOrganizationRead, server.Resource{Namespace: o.Name}) {
+			result.Organizations = append(result.Organizations, *o.ToApi())
+		}
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Organizations) create(request *Request) error {
+	var body api.NewOrganization
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+	o := &organization.Organization{Name: body.Name, Description: body.Description}
+	if err := h.Organizations.CreateOrganization(request.Author(), o, request.User.Name); err != nil {
+		return toOrganizationRequestError(err)
+	}
+	bytes, _ := json.Marshal(o.ToApi())
+	_, _ = request.Created(bytes)
+	return nil
+}
+
+func (h *Organizations) get(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	bytes, _ := json.Marshal(o.ToApi())
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Organizations) remove(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	for _, r := range h.Repositories.GetRepositories() {
+		if repository.Owner(r.Name) == o.Name {
+			return &responses.ConflictError{Message: "the organization still owns repositories"}
+		}
+	}
+	if err = h.Organizations.RemoveOrganization(request.Author(), o.Name); err != nil {
+		return toOrganizationRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+func (h *Organizations) members(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	bytes, _ := json.Marshal(o.MembersToApi())
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Organizations) setMember(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	var body api.MembershipChange
+	if err = request.ReadBody(&body); err != nil {
+		return err
+	}
+	name := request.Param("user")
+	if h.Users.GetUser(name) == nil {
+		return &responses.NotFoundError{Message: "user not found"}
+	}
+	if err = h.Organizations.SetMember(request.Author(), o.Name, name, body.Role); err != nil {
+		return toOrganizationRequestError(err)
+	}
+	bytes, _ := json.Marshal(&api.OrganizationMember{User: name, Role: body.Role})
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Organizations) removeMember(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	// Members are allowed to leave an organization by themselves
+	name := request.Param("user")
+	if name != request.User.Name {
+		if err = request.Require(api.PermissionOrganizationManage, request.Resource()); err != nil {
+			return err
+		}
+	}
+	if err = h.Organizations.RemoveMember(request.Author(), o.Name, name); err != nil {
+		return toOrganizationRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+func (h *Organizations) teams(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	sort.Slice(o.Teams, func(i, j int) bool {
+		return o.Teams[i].Name < o.Teams[j].Name
+	})
+	result := api.Teams{Teams: []api.Team{}}
+	for _, t := range o.Teams {
+		result.Teams = append(result.Teams, *t.ToApi(o.Name))
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Organizations) createTeam(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	var body api.NewTeam
+	if err = request.ReadBody(&body); err != nil {
+		return err
+	}
+	t := &organization.Team{Name: body.Name, Description: body.Description}
+	if err = h.Organizations.CreateTeam(request.Author(), o.Name, t); err != nil {
+		return toOrganizationRequestError(err)
+	}
+	bytes, _ := json.Marshal(t.ToApi(o.Name))
+	_, _ = request.Created(bytes)
+	return nil
+}
+
+func (h *Organizations) team(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	return h.writeTeam(request, o.Name)
+}
+
+func (h *Organizations) removeTeam(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	if err = h.Organizations.RemoveTeam(request.Author(), o.Name, request.Param("team")); err != nil {
+		return toOrganizationRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+func (h *Organizations) addTeamMember(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	err = h.Organizations.AddTeamMember(request.Author(), o.Name, request.Param("team"), request.Param("user"))
+	if err != nil {
+		return toOrganizationRequestError(err)
+	}
+	return h.writeTeam(request, o.Name)
+}
+
+func (h *Organizations) removeTeamMember(request *Request) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	err = h.Organizations.RemoveTeamMember(request.Author(), o.Name, request.Param("team"), request.Param("user"))
+	if err != nil {
+		return toOrganizationRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+func (h *Organizations) setTeamAccess(request *Request) error {
+	var body api.TeamAccess
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+	return h.changeTeamAccess(request, body.Access)
+}
+
+func (h *Organizations) revokeTeamAccess(request *Request) error {
+	return h.changeTeamAccess(request, api.AccessNone)
+}
+
+// changeTeamAccess grants a team access to the repository found in the path
+func (h *Organizations) changeTeamAccess(request *Request, access api.Access) error {
+	o, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	name := request.Repository()
+	if r := h.Repositories.GetRepository(name); r == nil || r.Deleted {
+		return &responses.NotFoundError{Message: "repository not found"}
+	}
+	err = h.Organizations.SetTeamAccess(request.Author(), o.Name, request.Param("team"), name, access)
+	if err != nil {
+		return toOrganizationRequestError(err)
+	}
+	return h.writeTeam(request, o.Name)
+}
+
+// writeTeam writes the team found in the path as the response
+func (h *Organizations) writeTeam(request *Request, name string) error {
+	o := h.Organizations.GetOrganization(name)
+	if o == nil {
+		return &responses.NotFoundError{Message: organization.OrganizationNotFoundError.Error()}
+	}
+	t := o.FindTeam(request.Param("team"))
+	if t == nil {
+		return &responses.NotFoundError{Message: organization.TeamNotFoundError.Error()}
+	}
+	bytes, _ := json.Marshal(t.ToApi(o.Name))
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+// find the organization targeted by the request
+func (h *Organizations) find(request *Request) (*organization.Organization, error) {
+	o := h.Organizations.GetOrganization(request.Param("organization"))
+	if o == nil {
+		return nil, &responses.NotFoundError{Message: organization.OrganizationNotFoundError.Error()}
+	}
+	return o, nil
+}
+
+// toOrganizationRequestError converts errors from the organization database into request errors
+func toOrganizationRequestError(err error) error {
+	switch err {
+	case organization.OrganizationNotFoundError, organization.TeamNotFoundError:
+		return &responses.NotFoundError{Message: err.Error()}
+	case user.UserAlreadyExistsError:
+		// Organizations and users share the same namespace, since both can own repositories
+		return &responses.ConflictError{Message: "name is already used by a user"}
+	case organization.OrganizationAlreadyExistsError, organization.TeamAlreadyExistsError,
+		organization.LastOwnerError, organization.NotMemberError:
+		return &responses.ConflictError{Message: err.Error()}
+	case organization.InvalidNameError:
+		return responses.NewFieldError("Name", err.Error())
+	case organization.InvalidRoleError:
+		return responses.NewFieldError("Role", err.Error())
+	case organization.InvalidAccessError:
+		return responses.NewFieldError("Access", err.Error())
+	case organization.RepositoryNotOwnedError:
+		return &responses.BadRequestError{Message: err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"net/http"
+)
+
+// PublicKeys is a route used when managing a user's public keys. The keys are used when a user authenticates
+// against the git server. The git server asks for the user on each login, so a removed key can't be used the
+// next time the user logs in.
+//
+// GET    /api/v1/users/{name}/keys
+// POST   /api/v1/users/{name}/keys
+// PATCH  /api/v1/users/{name}/keys/{key}
+// DELETE /api/v1/users/{name}/keys/{key}
+type PublicKeys struct {
+	Users user.Database
+}
+
+// Register adds all public key routes to the supplied router
+func (h *PublicKeys) Register(router *Router) {
+	router.HandleFunc(http.MethodGet, UsersPath+"/{name}/keys", api.PermissionAccountRead, h.list)
+	router.HandleFunc(http.MethodPost, UsersPath+"/{name}/keys", api.PermissionAccountWrite, h.add)
+	router.HandleFunc(http.MethodPatch, UsersPath+"/{name}/keys/{key}", api.PermissionAccountWrite, h.rename)
+	router.HandleFunc(http.MethodDelete, UsersPath+"/{name}/keys/{key}", api.PermissionAccountWrite, h.remove)
+}
+
+// find the user whose keys are managed by the request
+func (h *PublicKeys) find(request *Request) (*user.User, error) {
+	return findManagedUser(h.Users, request.Param("name"))
+}
+
+func (h *PublicKeys) list(request *Request) error {
+	u, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	result := api.PublicKeys{PublicKeys: append([]api.PublicKey{}, u.PublicKeys...)}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *PublicKeys) add(request *Request) error {
+	u, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	if err = authorizeCredentials(request, u); err != nil {
+		return err
+	}
+
+	var body api.NewPublicKey
+	if err = request.ReadBody(&body); err != nil {
+		return err
+	}
+
+	key, err := user.ParsePublicKey(body.Name, body.PublicKey)
+	if err != nil {
+		return toPublicKeyRequestError(err)
+	}
+	if err = h.Users.AddPublicKey(request.Author(), u.Name, key); err != nil {
+		return toPublicKeyRequestError(err)
+	}
+
+	bytes, _ := json.Marshal(key)
+	_, _ = request.Created(bytes)
+	return nil
+}
+
+func (h *PublicKeys) rename(request *Request) error {
+	u, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	if err = authorizeCredentials(request, u); err != nil {
+		return err
+	}
+
+	keyName := request.Param("key")
+	var body api.PublicKeyChanges
+	if err = request.ReadBody(&body); err != nil {
+		return err
+	}
+	if body.Name != nil {
+		if err := h.Users.RenamePublicKey(request.Author(), u.Name, keyName, *body.Name); err != nil {
+			return toPublicKeyRequestError(err)
+		}
+		keyName = *body.Name
+	}
+
+	for _, key := range h.Users.GetUser(u.Name).PublicKeys {
+		if key.Name == keyName {
+			bytes, _ := json.Marshal(key)
+			_, _ = request.Ok(bytes)
+			return nil
+		}
+	}
+	return &responses.NotFoundError{Message: user.PublicKeyNotFoundError.Error()}
+}
+
+func (h *PublicKeys) remove(request *Request) error {
+	u, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	if err = authorizeCredentials(request, u); err != nil {
+		return err
+	}
+
+	if err = h.Users.RemovePublicKey(request.Author(), u.Name, request.Param("key")); err != nil {
+		return toPublicKeyRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+// findManagedUser finds a user whose keys and tokens are managed
+func findManagedUser(users user.Database, name string) (*user.User, error) {
+	u := users.GetUser(name)
+	if u == nil {
+		return nil, &responses.NotFoundError{Message: "user not found"}
+	}
+	return u, nil
+}
+
+// authorizeCredentials verifies that the caller is allowed to change the password, keys or tokens of the supplied
+// user. Only site administrators change other users' credentials, since they can be used to act as the user
+func authorizeCredentials(request *Request, u *user.User) error {
+	permissions := request.Permissions()
+	if permissions.User == u.Name || holdsAll(permissions, server.BuiltInRoles[api.RoleSiteAdmin]) {
+		return nil
+	}
+	return &responses.ForbiddenError{Message: "only site administrators can change another user's credentials"}
+}
+
+// toPublicKeyRequestError converts errors from the user database into request errors
+func toPublicKeyRequestError(err error) error {
+	switch err {
+	case user.PublicKeyNotFoundError:
+		return &responses.NotFoundError{Message: err.Error()}
+	case user.PublicKeyAlreadyExistsError, user.PublicKeyNameTakenError:
+		return &responses.ConflictError{Message: err.Error()}
+	case user.InvalidPublicKeyError:
+		return responses.NewFieldError("PublicKey", err.Error())
+	case user.InvalidPublicKeyNameError:
+		return responses.NewFieldError("Name", err.Error())
+	}
+	return toUserRequestError(err)
+}
@@ -0,0 +1,227 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/organization"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"github.com/westcoastcode-se/gitgo/apiserver/webhook"
+	"net/http"
+)
+
+// Repositories is a route used when managing repositories
+//
+// GET    /api/v1/repositories[?deleted=true]
+// POST   /api/v1/repositories
+// GET    /api/v1/repositories/{owner}/{repository}
+// PATCH  /api/v1/repositories/{owner}/{repository}
+// DELETE /api/v1/repositories/{owner}/{repository}[?purge=true]
+// POST   /api/v1/repositories/{owner}/{repository}/restore
+type Repositories struct {
+	Repositories  repository.Database
+	Users         user.Database
+	Organizations organization.Database
+	Webhooks      webhook.Database
+}
+
+// Register adds all repository routes to the supplied router
+func (h *Repositories) Register(router *Router) {
+	router.HandleFunc(http.MethodGet, RepositoriesPath, api.PermissionNone, h.list)
+	router.HandleFunc(http.MethodPost, RepositoriesPath, api.PermissionRepositoryCreate, h.create)
+	router.HandleFunc(http.MethodGet, RepositoryPath, api.PermissionRepositoryRead, h.get)
+	router.HandleFunc(http.MethodPatch, RepositoryPath, api.PermissionRepositoryAdmin, h.update)
+	router.HandleFunc(http.MethodDelete, RepositoryPath, api.PermissionRepositoryAdmin, h.delete)
+	router.HandleFunc(http.MethodPost, RepositoryPath+"/restore", api.PermissionRepositoryAdmin, h.restore)
+}
+
+func (h *Repositories) list(request *Request) error {
+	permissions := request.Permissions()
+	includeDeleted := request.Query("deleted") == "true"
+
+	result := api.Repositories{Repositories: []api.Repository{}}
+	for _, r := range h.Repositories.GetRepositories() {
+		if r.Deleted != includeDeleted {
+			continue
+		}
+		access := permissions.GetAccess(r.Name)
+		if !access.Allows(api.AccessRead) || (r.Deleted && !access.Allows(api.AccessAdmin)) {
+			continue
+		}
+		result.Repositories = append(result.Repositories, *r.ToApi(h.Repositories.GetPath(r)))
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Repositories) create(request *Request) error {
+	var body api.NewRepository
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+	if err := h.authorizeOwner(request, body.Name); err != nil {
+		return err
+	}
+
+	r := &repository.Repository{
+		Name:          body.Name,
+		Description:   body.Description,
+		DefaultBranch: body.DefaultBranch,
+	}
+	if err := h.Repositories.CreateRepository(request.Author(), r); err != nil {
+		return toRepositoryRequestError(err)
+	}
+
+	bytes, _ := json.Marshal(r.ToApi(h.Repositories.GetPath(r)))
+	_, _ = request.Created(bytes)
+	return nil
+}
+
+func (h *Repositories) get(request *Request) error {
+	r, err := h.find(request.Repository())
+	if err != nil {
+		return err
+	}
+	bytes, _ := json.Marshal(r.ToApi(h.Repositories.GetPath(r)))
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Repositories) update(request *Request) error {
+	name := request.Repository()
+	if _, err := h.find(name); err != nil {
+		return err
+	}
+
+	var body api.RepositoryChanges
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+	// Transferring a repository requires the same permissions as creating a repository for the new owner
+	if body.Name != nil && repository.Owner(*body.Name) != repository.Owner(name) {
+		if err := h.authorizeOwner(request, *body.Name); err != nil {
+			return err
+		}
+	}
+
+	r, err := h.Repositories.UpdateRepository(request.Author(), name, &body, h.renamed)
+	if err != nil {
+		return toRepositoryRequestError(err)
+	}
+
+	bytes, _ := json.Marshal(r.ToApi(h.Repositories.GetPath(r)))
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Repositories) delete(request *Request) error {
+	r, err := h.find(request.Repository())
+	if err != nil {
+		return err
+	}
+
+	if request.Query("purge") == "true" {
+		err = h.Repositories.PurgeRepository(request.Author(), r.Name, h.purged)
+	} else {
+		err = h.Repositories.DeleteRepository(request.Author(), r.Name, h.removed)
+	}
+	if err != nil {
+		return toRepositoryRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+func (h *Repositories) restore(request *Request) error {
+	r, err := h.find(request.Repository())
+	if err != nil {
+		return err
+	}
+	if err = h.Repositories.RestoreRepository(request.Author(), r.Name); err != nil {
+		return toRepositoryRequestError(err)
+	}
+
+	bytes, _ := json.Marshal(r.ToApi(h.Repositories.GetPath(r)))
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+// authorizeOwner verifies that the owner of the supplied repository name exists, and that the logged in user is
+// allowed to create repositories for it. Administrators can create repositories for everyone, users for
+// themselves and owners of an organization for the organization
+func (h *Repositories) authorizeOwner(request *Request, name string) error {
+	owner, _, ok := repository.SplitName(name)
+	if !ok || !repository.IsValidName(name) {
+		return responses.NewFieldError("Name", repository.InvalidNameError.Error())
+	}
+	if h.Users.GetUser(owner) == nil && h.Organizations.GetOrganization(owner) == nil {
+		return responses.NewFieldError("Name", fmt.Sprintf("user or organization %s does not exist", owner))
+	}
+	return request.Require(api.PermissionRepositoryCreate, server.Resource{Namespace: owner})
+}
+
+// find a repository
+// renamed moves the access and webhooks of a renamed repository in the transaction that renames it
+func (h *Repositories) renamed(tx db.Transaction, oldName string, newName string) error {
+	if err := h.Users.RenameRepository(tx, oldName, newName); err != nil {
+		return fmt.Errorf("could not move access from %s to %s: %w", oldName, newName, err)
+	}
+	if err := h.Organizations.RenameRepository(tx, oldName, newName); err != nil {
+		return fmt.Errorf("could not move team access from %s to %s: %w", oldName, newName, err)
+	}
+	if err := h.Webhooks.RenameRepository(tx, oldName, newName); err != nil {
+		return fmt.Errorf("could not move webhooks from %s to %s: %w", oldName, newName, err)
+	}
+	return nil
+}
+
+// removed revokes the access granted to a repository in the transaction that moves it to the trash
+func (h *Repositories) removed(tx db.Transaction, name string) error {
+	if err := h.Users.RemoveRepository(tx, name); err != nil {
+		return fmt.Errorf("could not revoke access to %s: %w", name, err)
+	}
+	if err := h.Organizations.RemoveRepository(tx, name); err != nil {
+		return fmt.Errorf("could not revoke team access to %s: %w", name, err)
+	}
+	return nil
+}
+
+// purged removes the webhooks of a repository, and any access granted to it, in the transaction that removes the
+// repository permanently
+func (h *Repositories) purged(tx db.Transaction, name string) error {
+	if err := h.removed(tx, name); err != nil {
+		return err
+	}
+	if err := h.Webhooks.RemoveRepository(tx, name); err != nil {
+		return fmt.Errorf("could not remove webhooks of %s: %w", name, err)
+	}
+	return nil
+}
+
+func (h *Repositories) find(name string) (*repository.Repository, error) {
+	r := h.Repositories.GetRepository(name)
+	if r == nil {
+		return nil, &responses.NotFoundError{Message: "repository not found"}
+	}
+	return r, nil
+}
+
+// toRepositoryRequestError converts errors from the repository database into request errors
+func toRepositoryRequestError(err error) error {
+	switch err {
+	case repository.RepositoryNotFoundError:
+		return &responses.NotFoundError{Message: err.Error()}
+	case repository.RepositoryAlreadyExistsError, repository.RepositoryNotDeletedError:
+		return &responses.ConflictError{Message: err.Error()}
+	case repository.InvalidNameError:
+		return responses.NewFieldError("Name", err.Error())
+	case repository.InvalidBranchError:
+		return responses.NewFieldError("DefaultBranch", err.Error())
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"net/http"
+	"sort"
+)
+
+// RepositoriesPath is the uri where all repository routes are located
+const RepositoriesPath = "/api/v1/repositories"
+
+// RepositoryPath is the uri pattern of a specific repository
+const RepositoryPath = RepositoriesPath + "/{owner}/{repository}"
+
+// RepositoryAccess is a route used to resolve the access level a user has to a specific repository. It
+// is used by the git server to authorize reads and writes before executing any git commands
+//
+// GET /api/v1/repositories/{owner}/{repository}/access[?user={name}]
+// GET /internal/v1/repositories/{owner}/{repository}/access?user={name}
+//
+// All users that are explicitly granted access to the repository are listed if no user is supplied. Only
+// users with admin access to the repository are allowed to list them
+type RepositoryAccess struct {
+	Users user.Database
+
+	// Providers grants users the permissions of their organizations, teams and roles
+	Providers []server.PermissionProvider
+}
+
+// Register adds the repository access route to the supplied router
+func (h *RepositoryAccess) Register(router *Router) {
+	router.Handle(http.MethodGet, RepositoryPath+"/access", api.PermissionRepositoryRead, h)
+}
+
+// RegisterInternal adds the route used by the git server before a git command is executed to the supplied router
+func (h *RepositoryAccess) RegisterInternal(router *Router) {
+	router.Handle(http.MethodGet, InternalRepositoryPath+"/access", api.PermissionRepositoryRead, h)
+}
+
+func (h *RepositoryAccess) ServeRoute(request *Request) error {
+	repository := request.Repository()
+
+	var name = request.Query("user")
+	if len(name) == 0 {
+		return h.list(request, repository)
+	}
+
+	u := h.Users.GetUser(name)
+	if u == nil {
+		return &responses.NotFoundError{Message: "user not found"}
+	}
+
+	permissions := u.Permissions()
+	for _, provider := range h.Providers {
+		provider.AddPermissions(u.Name, permissions)
+	}
+	access := api.RepositoryAccess{
+		Repository: repository,
+		User:       u.Name,
+		Access:     permissions.GetAccess(repository),
+	}
+	bytes, _ := json.Marshal(access)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+// list the access explicitly granted to each user, sorted by user name
+func (h *RepositoryAccess) list(request *Request, repository string) error {
+	if err := request.Require(api.PermissionRepositoryAdmin, request.Resource()); err != nil {
+		return err
+	}
+
+	access := h.Users.GetRepositoryAccess(repository)
+	names := make([]string, 0, len(access))
+	for name := range access {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := api.RepositoryAccesses{Accesses: []api.RepositoryAccess{}}
+	for _, name := range names {
+		result.Accesses = append(result.Accesses, api.RepositoryAccess{
+			Repository: repository,
+			User:       name,
+			Access:     access[name],
+		})
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
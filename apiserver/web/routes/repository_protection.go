@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/organization"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"net/http"
+)
+
+// RepositoryProtection is a route used when protecting branches and tags in a repository. The rules are read by
+// the git server and enforced when a push is received
+//
+// GET /api/v1/repositories/{owner}/{repository}/protection
+// PUT /api/v1/repositories/{owner}/{repository}/protection
+// GET /internal/v1/repositories/{owner}/{repository}/protection
+type RepositoryProtection struct {
+	Repositories  repository.Database
+	Users         user.Database
+	Organizations organization.Database
+}
+
+// Register adds the repository protection routes to the supplied router
+func (h *RepositoryProtection) Register(router *Router) {
+	router.HandleFunc(http.MethodGet, RepositoryPath+"/protection", api.PermissionRepositoryRead, h.get)
+	router.HandleFunc(http.MethodPut, RepositoryPath+"/protection", api.PermissionRepositoryAdmin, h.set)
+}
+
+// RegisterInternal adds the route used by the git server when a push is received to the supplied router
+func (h *RepositoryProtection) RegisterInternal(router *Router) {
+	router.HandleFunc(http.MethodGet, InternalRepositoryPath+"/protection", api.PermissionRepositoryRead, h.get)
+}
+
+func (h *RepositoryProtection) get(request *Request) error {
+	r := h.Repositories.GetRepository(request.Repository())
+	if r == nil || r.Deleted {
+		return &responses.NotFoundError{Message: "repository not found"}
+	}
+	return h.write(request, r)
+}
+
+func (h *RepositoryProtection) set(request *Request) error {
+	name := request.Repository()
+	if r := h.Repositories.GetRepository(name); r == nil {
+		return &responses.NotFoundError{Message: "repository not found"}
+	}
+
+	var body api.RepositoryProtection
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+	owner := h.Organizations.GetOrganization(repository.Owner(name))
+	for _, branch := range body.Branches {
+		for _, name := range branch.Users {
+			if h.Users.GetUser(name) == nil {
+				return responses.NewFieldError("Branches", fmt.Sprintf("user %s does not exist", name))
+			}
+		}
+		for _, name := range branch.Teams {
+			if owner == nil || owner.FindTeam(name) == nil {
+				return responses.NewFieldError("Branches", fmt.Sprintf("team %s does not exist", name))
+			}
+		}
+	}
+
+	r, err := h.Repositories.SetProtection(request.Author(), name, &body)
+	if err != nil {
+		if err == repository.InvalidPatternError {
+			return responses.NewFieldError("Branches", err.Error())
+		}
+		return toRepositoryRequestError(err)
+	}
+	return h.write(request, r)
+}
+
+func (h *RepositoryProtection) write(request *Request, r *repository.Repository) error {
+	protection := r.Protection
+	if protection.Branches == nil {
+		protection.Branches = []api.BranchProtection{}
+	}
+	bytes, _ := json.Marshal(&protection)
+	_, _ = request.Ok(bytes)
+	return nil
+}
@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/role"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"net/http"
+)
+
+// RolesPath is the uri where all role routes are located
+const RolesPath = "/api/v1/roles"
+
+// Roles is a route used when managing custom roles. Everyone is allowed to see the roles, including the built-in
+// ones, but only administrators are allowed to change them
+//
+// GET    /api/v1/roles
+// POST   /api/v1/roles
+// GET    /api/v1/roles/{role}
+// PATCH  /api/v1/roles/{role}
+// DELETE /api/v1/roles/{role}
+type Roles struct {
+	Roles role.Database
+	Users user.Database
+}
+
+// Register adds all role routes to the supplied router
+func (h *Roles) Register(router *Router) {
+	path := RolesPath + "/{role}"
+	router.HandleFunc(http.MethodGet, RolesPath, api.PermissionNone, h.list)
+	router.HandleFunc(http.MethodPost, RolesPath, api.PermissionRolesManage, h.create)
+	router.HandleFunc(http.MethodGet, path, api.PermissionNone, h.get)
+	router.HandleFunc(http.MethodPatch, path, api.PermissionRolesManage, h.update)
+	router.HandleFunc(http.MethodDelete, path, api.PermissionRolesManage, h.remove)
+}
+
+func (h *Roles) list(request *Request) error {
+	result := api.Roles{Roles: []api.Role{}}
+	for _, name := range server.BuiltInRoleNames {
+		result.Roles = append(result.Roles, *role.BuiltIn(name))
+	}
+	for _, r := range h.Roles.GetRoles() {
+		result.Roles = append(result.Roles, *r.ToApi())
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Roles) create(request *Request) error {
+	var body api.NewRole
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+	r := &role.Role{Name: body.Name, Description: body.Description, Permissions: body.Permissions}
+	if err := h.Roles.CreateRole(request.Author(), r); err != nil {
+		return toRoleRequestError(err)
+	}
+	bytes, _ := json.Marshal(r.ToApi())
+	_, _ = request.Created(bytes)
+	return nil
+}
+
+func (h *Roles) get(request *Request) error {
+	name := request.Param("role")
+	result := role.BuiltIn(name)
+	if result == nil {
+		r := h.Roles.GetRole(name)
+		if r == nil {
+			return &responses.NotFoundError{Message: role.RoleNotFoundError.Error()}
+		}
+		result = r.ToApi()
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Roles) update(request *Request) error {
+	var body api.RoleChanges
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+	r, err := h.Roles.UpdateRole(request.Author(), request.Param("role"), &body)
+	if err != nil {
+		return toRoleRequestError(err)
+	}
+	bytes, _ := json.Marshal(r.ToApi())
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Roles) remove(request *Request) error {
+	name := request.Param("role")
+	if err := h.Roles.RemoveRole(request.Author(), name); err != nil {
+		return toRoleRequestError(err)
+	}
+	// Users that were assigned the role no longer have it
+	if err := h.Users.RemoveRole(request.Author(), name); err != nil {
+		return err
+	}
+	request.NoContent()
+	return nil
+}
+
+// toRoleRequestError converts errors from the role database into request errors
+func toRoleRequestError(err error) error {
+	switch {
+	case errors.Is(err, role.RoleNotFoundError):
+		return &responses.NotFoundError{Message: err.Error()}
+	case errors.Is(err, role.RoleAlreadyExistsError):
+		return &responses.ConflictError{Message: err.Error()}
+	case errors.Is(err, role.BuiltInRoleError):
+		return &responses.ForbiddenError{Message: err.Error()}
+	case errors.Is(err, role.InvalidNameError):
+		return responses.NewFieldError("Name", err.Error())
+	case errors.Is(err, role.InvalidPermissionError):
+		return responses.NewFieldError("Permissions", err.Error())
+	}
+	return err
+}
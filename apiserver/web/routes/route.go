@@ -1,8 +1,13 @@
 package routes
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/westcoastcode-se/gitgo/api"
 	"github.com/westcoastcode-se/gitgo/apiserver/server"
 	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
 	"net/http"
 )
 
@@ -12,30 +17,115 @@ type Request struct {
 	Original *http.Request
 	Response http.ResponseWriter
 	URI      string
+
+	// Params contains the parameters found in the path, for example "name" if the route pattern
+	// contains {name}
+	Params map[string]string
 }
 
 func (r *Request) IsLoggedIn() bool {
 	return r.User != nil
 }
 
+// Param returns the value of a parameter found in the path
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
+// Repository returns the name of the repository found in the path, in the form "{owner}/{name}". Empty is
+// returned if the path doesn't contain a repository
+func (r *Request) Repository() string {
+	owner, name := r.Param("owner"), r.Param("repository")
+	if len(owner) == 0 || len(name) == 0 {
+		return ""
+	}
+	return owner + "/" + name
+}
+
+// Resource returns the resource targeted by the request, found in the path. A repository is found in the {owner}
+// and {repository} parameters, an organization in the {organization} parameter and a user account in the
+// {name} parameter of the user routes
+func (r *Request) Resource() server.Resource {
+	namespace := r.Param("organization")
+	if len(namespace) == 0 {
+		namespace = r.Param("owner")
+	}
+	return server.Resource{Repository: r.Repository(), Namespace: namespace, User: r.Param("name")}
+}
+
+// Require verifies that the request is granted the supplied permission on the supplied resource
+func (r *Request) Require(permission api.Permission, resource server.Resource) error {
+	if !r.Permissions().Has(permission, resource) {
+		return &responses.PermissionDeniedError{Permission: permission}
+	}
+	return nil
+}
+
+// Author returns the name recorded as the author of changes made by this request
+func (r *Request) Author() string {
+	return r.User.Name
+}
+
+// Permissions returns the permissions granted to this request
+func (r *Request) Permissions() *server.Permissions {
+	return r.Context.GetPermissions()
+}
+
 func (r *Request) Query(param string) string {
 	return r.Original.URL.Query().Get(param)
 }
 
+// ReadBody parses the json encoded request body into the supplied value
+func (r *Request) ReadBody(i interface{}) error {
+	defer r.Original.Body.Close()
+	if err := json.NewDecoder(r.Original.Body).Decode(i); err != nil {
+		return &responses.BadRequestError{Message: fmt.Sprintf("could not parse request body: %v", err)}
+	}
+	return nil
+}
+
 func (r *Request) Ok(body []byte) (int, error) {
-	r.Response.WriteHeader(http.StatusOK)
+	return r.write(http.StatusOK, body)
+}
+
+func (r *Request) Created(body []byte) (int, error) {
+	return r.write(http.StatusCreated, body)
+}
+
+func (r *Request) NoContent() {
+	r.Response.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Request) write(statusCode int, body []byte) (int, error) {
 	r.Response.Header().Set("Content-Type", "application/json")
+	r.Response.WriteHeader(statusCode)
 	return r.Response.Write(body)
 }
 
+// Error writes the supplied error as the response
+func (r *Request) Error(err responses.RequestError) {
+	_, _ = responses.WriteError(r.URI, r.Context.GetRequestUUID(), err, r.Response)
+}
+
+// RequestUUIDHeader is the header containing the unique identifier of a request. Callers are allowed to supply
+// their own identifier, so that a request can be traced across servers
+const RequestUUIDHeader = "X-Request-Id"
+
 func FromHttpRequest(rw http.ResponseWriter, r *http.Request) *Request {
 	ctx, _ := server.NewContext()
+	requestUUID, err := uuid.Parse(r.Header.Get(RequestUUIDHeader))
+	if err != nil {
+		requestUUID = uuid.New()
+	}
+	ctx.SetValue(server.ContextRequestUUID, requestUUID.String())
+	rw.Header().Set(RequestUUIDHeader, requestUUID.String())
 	return &Request{
 		Context:  ctx,
 		User:     nil,
 		Original: r,
 		Response: rw,
 		URI:      r.RequestURI,
+		Params:   map[string]string{},
 	}
 }
 
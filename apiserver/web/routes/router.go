@@ -0,0 +1,133 @@
+package routes
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// RouteFunc is an adapter that allows ordinary functions to be used as routes
+type RouteFunc func(request *Request) error
+
+func (f RouteFunc) ServeRoute(request *Request) error {
+	return f(request)
+}
+
+// Middleware wraps a route, for example to verify that the request is allowed before the route is served. The
+// permission is the one the route requires
+type Middleware func(permission api.Permission, next Route) Route
+
+type registeredRoute struct {
+	method     string
+	segments   []string
+	permission api.Permission
+	route      Route
+}
+
+// Router keeps track of which route is responsible for which method and path. A path pattern consists of segments
+// separated by a '/'. A segment in the form of {name} matches any value, which is then available as a parameter
+// on the request. Each route declares the permission it requires, which is verified by the router's middlewares
+//
+//	router.Handle(http.MethodGet, "/api/v1/users/{user}", api.PermissionAccountRead, route)
+type Router struct {
+	routes      []*registeredRoute
+	middlewares []Middleware
+}
+
+// Handle registers a route for the supplied method and path pattern
+func (r *Router) Handle(method string, pattern string, permission api.Permission, route Route) {
+	r.routes = append(r.routes, &registeredRoute{
+		method:     method,
+		segments:   splitPath(pattern),
+		permission: permission,
+		route:      route,
+	})
+}
+
+// HandleFunc registers a function for the supplied method and path pattern
+func (r *Router) HandleFunc(method string, pattern string, permission api.Permission, f func(request *Request) error) {
+	r.Handle(method, pattern, permission, RouteFunc(f))
+}
+
+// Use adds a middleware that wraps all routes. Middlewares are applied in the order they are added
+func (r *Router) Use(middleware Middleware) {
+	r.middlewares = append(r.middlewares, middleware)
+}
+
+// Match finds the route responsible for the supplied method and escaped path. The parameters found in the path are
+// returned together with the route. If the path exists, but not for the supplied method, then the allowed
+// methods are returned instead. HEAD requests are served by GET routes
+func (r *Router) Match(method string, path string) (Route, map[string]string, []string) {
+	segments := splitPath(path)
+	var allowed []string
+	for _, candidate := range r.routes {
+		params, ok := candidate.match(segments)
+		if !ok {
+			continue
+		}
+		if candidate.method == method || (method == http.MethodHead && candidate.method == http.MethodGet) {
+			return r.wrap(candidate), params, nil
+		}
+		allowed = appendUnique(allowed, candidate.method)
+		if candidate.method == http.MethodGet {
+			allowed = appendUnique(allowed, http.MethodHead)
+		}
+	}
+	sort.Strings(allowed)
+	return nil, nil, allowed
+}
+
+// wrap the supplied route in all middlewares, so that the first middleware is the first one to serve the request
+func (r *Router) wrap(candidate *registeredRoute) Route {
+	route := candidate.route
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		route = r.middlewares[i](candidate.permission, route)
+	}
+	return route
+}
+
+func (r *registeredRoute) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, segment := range r.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if len(segments[i]) == 0 {
+				return nil, false
+			}
+			value, err := url.PathUnescape(segments[i])
+			if err != nil {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = value
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// NewRouter creates a new router without any routes
+func NewRouter() *Router {
+	return &Router{}
+}
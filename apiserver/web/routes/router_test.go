@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRouterMatch(t *testing.T) {
+	router := NewRouter()
+	noop := func(*Request) error { return nil }
+	router.HandleFunc(http.MethodGet, "/api/v1/repositories/{owner}/{name}", api.PermissionRepositoryRead, noop)
+	router.HandleFunc(http.MethodPatch, "/api/v1/repositories/{owner}/{name}", api.PermissionRepositoryAdmin, noop)
+	router.HandleFunc(http.MethodPost, "/api/v1/repositories", api.PermissionRepositoryCreate, noop)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		found   bool
+		params  map[string]string
+		allowed string
+	}{
+		{"parameters", http.MethodGet, "/api/v1/repositories/acme/website", true,
+			map[string]string{"owner": "acme", "name": "website"}, ""},
+		{"escaped parameter", http.MethodGet, "/api/v1/repositories/acme/web%20site", true,
+			map[string]string{"owner": "acme", "name": "web site"}, ""},
+		{"trailing slash", http.MethodPost, "/api/v1/repositories/", true, map[string]string{}, ""},
+		{"head", http.MethodHead, "/api/v1/repositories/acme/website", true,
+			map[string]string{"owner": "acme", "name": "website"}, ""},
+		{"other method", http.MethodDelete, "/api/v1/repositories/acme/website", false, nil, "GET,HEAD,PATCH"},
+		{"only post", http.MethodGet, "/api/v1/repositories", false, nil, "POST"},
+		{"empty parameter", http.MethodGet, "/api/v1/repositories/acme//", false, nil, ""},
+		{"unknown path", http.MethodGet, "/api/v1/unknown", false, nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route, params, allowed := router.Match(test.method, test.path)
+			if (route != nil) != test.found {
+				t.Fatalf("expected a route to be found: %v", test.found)
+			}
+			if len(params) != len(test.params) {
+				t.Errorf("expected %v but was %v", test.params, params)
+			}
+			for name, value := range test.params {
+				if params[name] != value {
+					t.Errorf("expected %s to be %s but was %s", name, value, params[name])
+				}
+			}
+			if actual := strings.Join(allowed, ","); actual != test.allowed {
+				t.Errorf("expected %s to be allowed but was %s", test.allowed, actual)
+			}
+		})
+	}
+}
+
+func TestRouterMiddlewares(t *testing.T) {
+	router := NewRouter()
+	var calls []string
+	middleware := func(name string) Middleware {
+		return func(permission api.Permission, next Route) Route {
+			return RouteFunc(func(request *Request) error {
+				calls = append(calls, name+" "+string(permission))
+				return next.ServeRoute(request)
+			})
+		}
+	}
+	router.Use(middleware("first"))
+	router.Use(middleware("second"))
+	router.HandleFunc(http.MethodGet, "/api/v1/users", api.PermissionUsersRead, func(*Request) error {
+		calls = append(calls, "route")
+		return nil
+	})
+
+	route, _, _ := router.Match(http.MethodGet, "/api/v1/users")
+	if err := route.ServeRoute(&Request{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "first users:read,second users:read,route"
+	if actual := strings.Join(calls, ","); actual != expected {
+		t.Errorf("expected %s but was %s", expected, actual)
+	}
+}
@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/organization"
+	"github.com/westcoastcode-se/gitgo/apiserver/token"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"net/http"
+)
+
+// Tokens is a route used when managing a user's personal access tokens. A token is sent in the
+// "Authorization: Bearer" header instead of using a client-side certificate. Services, such as the git
+// server, verify tokens sent to them by users using the internal api
+//
+// GET    /api/v1/users/{name}/tokens
+// POST   /api/v1/users/{name}/tokens
+// DELETE /api/v1/users/{name}/tokens/{id}
+// POST   /internal/v1/tokens/verify
+type Tokens struct {
+	Users         user.Database
+	Organizations organization.Database
+	Tokens        token.Database
+}
+
+// Register adds all token routes to the supplied router
+func (h *Tokens) Register(router *Router) {
+	router.HandleFunc(http.MethodGet, UsersPath+"/{name}/tokens", api.PermissionAccountRead, h.list)
+	router.HandleFunc(http.MethodPost, UsersPath+"/{name}/tokens", api.PermissionAccountWrite, h.create)
+	router.HandleFunc(http.MethodDelete, UsersPath+"/{name}/tokens/{id}", api.PermissionAccountWrite, h.revoke)
+}
+
+// RegisterInternal adds the route used by services when verifying tokens to the supplied router
+func (h *Tokens) RegisterInternal(router *Router) {
+	router.HandleFunc(http.MethodPost, InternalPath+"/tokens/verify", api.PermissionTokensVerify, h.verify)
+}
+
+// find the user whose tokens are managed by the request
+func (h *Tokens) find(request *Request) (*user.User, error) {
+	return findManagedUser(h.Users, request.Param("name"))
+}
+
+func (h *Tokens) list(request *Request) error {
+	u, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	result := api.Tokens{Tokens: []api.Token{}}
+	for _, t := range h.Tokens.GetTokens(u.Name) {
+		result.Tokens = append(result.Tokens, *t.ToApi())
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Tokens) create(request *Request) error {
+	u, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	if err = authorizeCredentials(request, u); err != nil {
+		return err
+	}
+
+	var body api.NewToken
+	if err = request.ReadBody(&body); err != nil {
+		return err
+	}
+	if len(body.Scopes) == 0 {
+		return responses.NewFieldError("Scopes", "at least one scope is required")
+	}
+
+	// A token must never be able to create a token with more scopes than it has itself
+	for _, scope := range body.Scopes {
+		if !request.Permissions().HasScope(scope) {
+			return &responses.ForbiddenError{Message: "not allowed to create a token with scope " + string(scope)}
+		}
+	}
+
+	t, secret, err := h.Tokens.AddToken(request.Author(), u.Name, body.Name, body.Scopes, body.ExpiresAt)
+	if err != nil {
+		return toTokenRequestError(err)
+	}
+
+	bytes, _ := json.Marshal(&api.CreatedToken{Token: *t.ToApi(), Secret: secret})
+	_, _ = request.Created(bytes)
+	return nil
+}
+
+func (h *Tokens) revoke(request *Request) error {
+	u, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	if err = authorizeCredentials(request, u); err != nil {
+		return err
+	}
+
+	if err = h.Tokens.RemoveToken(request.Author(), u.Name, request.Param("id")); err != nil {
+		return toTokenRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+// verify that a secret belongs to the supplied user. Not found is returned if the token is not valid, so
+// that it can't be confused with the caller not being authorized
+func (h *Tokens) verify(request *Request) error {
+	var body api.TokenVerification
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+
+	t, err := h.Tokens.Authenticate(body.Secret)
+	if err != nil || t.User != body.User {
+		return &responses.NotFoundError{Message: "token is not valid"}
+	}
+	u := h.Users.GetUser(t.User)
+	if u == nil {
+		return &responses.NotFoundError{Message: "token is not valid"}
+	}
+
+	bytes, _ := json.Marshal(&api.VerifiedToken{User: *toApiUser(u, h.Organizations), Scopes: t.Scopes})
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+// toTokenRequestError converts errors from the token database into request errors
+func toTokenRequestError(err error) error {
+	switch err {
+	case token.TokenNotFoundError:
+		return &responses.NotFoundError{Message: err.Error()}
+	case token.InvalidScopeError:
+		return responses.NewFieldError("Scopes", err.Error())
+	case token.InvalidExpiresAtError:
+		return responses.NewFieldError("ExpiresAt", err.Error())
+	}
+	return err
+}
@@ -2,43 +2,355 @@ package routes
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"github.com/westcoastcode-se/gitgo/api"
-	"log"
+	"github.com/westcoastcode-se/gitgo/apiserver/organization"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/role"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/token"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
 	"net/http"
 )
 
+// UsersPath is the uri where all user routes are located
+const UsersPath = "/api/v1/users"
+
+// Users is a route used when managing users
+//
+// GET    /api/v1/users
+// POST   /api/v1/users
+// GET    /api/v1/users/{name}
+// PATCH  /api/v1/users/{name}
+// DELETE /api/v1/users/{name}
+// PUT    /api/v1/users/{name}/password
 type Users struct {
+	Users         user.Database
+	Organizations organization.Database
+	Roles         role.Database
+	Tokens        token.Database
+	Repositories  repository.Database
+}
+
+// Register adds all user routes to the supplied router
+func (h *Users) Register(router *Router) {
+	router.HandleFunc(http.MethodGet, UsersPath, api.PermissionUsersRead, h.list)
+	router.HandleFunc(http.MethodPost, UsersPath, api.PermissionUsersManage, h.create)
+	router.HandleFunc(http.MethodGet, UsersPath+"/{name}", api.PermissionAccountRead, h.get)
+	router.HandleFunc(http.MethodPatch, UsersPath+"/{name}", api.PermissionUsersManage, h.update)
+	router.HandleFunc(http.MethodDelete, UsersPath+"/{name}", api.PermissionUsersManage, h.delete)
+	router.HandleFunc(http.MethodPut, UsersPath+"/{name}/password", api.PermissionAccountWrite, h.changePassword)
+}
+
+// RegisterInternal adds the routes used by the git server when a user logs in with a public key
+//
+// GET    /internal/v1/keys/{fingerprint}
+func (h *Users) RegisterInternal(router *Router) {
+	router.HandleFunc(http.MethodGet, InternalPath+"/keys/{fingerprint}", api.PermissionUsersRead,
+		h.findUsingFingerprint)
+}
+
+func (h *Users) findUsingFingerprint(request *Request) error {
+	u := h.Users.GetUserUsingPublicKey(request.Param("fingerprint"))
+	if u == nil {
+		return &responses.NotFoundError{Message: "no user has the supplied public key"}
+	}
+	bytes, _ := json.Marshal(toApiUser(u, h.Organizations))
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Users) list(request *Request) error {
+	result := api.Users{Users: []api.User{}}
+	for _, u := range h.Users.GetUsers() {
+		result.Users = append(result.Users, *toApiUser(u, h.Organizations))
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Users) create(request *Request) error {
+	var body api.NewUser
+	if err := request.ReadBody(&body); err != nil {
+		return err
+	}
+	if err := validateAccess(body.Repositories); err != nil {
+		return err
+	}
+	if err := h.validateRoles(body.Roles); err != nil {
+		return err
+	}
+	if err := h.authorizeGrants(request, &user.User{}, body.Admin, body.Repositories, body.Roles); err != nil {
+		return err
+	}
+	if body.Repositories == nil {
+		body.Repositories = map[string]api.Access{}
+	}
+	hash, err := user.HashPassword(body.Password)
+	if err != nil {
+		return toUserRequestError(err)
+	}
+
+	u := &user.User{
+		Name:         body.Name,
+		PasswordHash: hash,
+		PublicKeys:   []api.PublicKey{},
+		Admin:        body.Admin,
+		Repositories: body.Repositories,
+		Roles:        body.Roles,
+	}
+	if err = h.Users.AddUser(request.Author(), u); err != nil {
+		return toUserRequestError(err)
+	}
+
+	bytes, _ := json.Marshal(toApiUser(u, h.Organizations))
+	_, _ = request.Created(bytes)
+	return nil
 }
 
-func (h *Users) ServeRoute(request *Request) error {
-	if !request.IsLoggedIn() {
-		return errors.New("not logged in")
+func (h *Users) get(request *Request) error {
+	u, err := h.find(request, request.Param("name"))
+	if err != nil {
+		return err
 	}
+	bytes, _ := json.Marshal(toApiUser(u, h.Organizations))
+	_, _ = request.Ok(bytes)
+	return nil
+}
 
-	var fingerprint = request.Query("fingerprint")
-	if len(fingerprint) == 0 {
-		return errors.New("missing query parameter 'fingerprint'")
+func (h *Users) update(request *Request) error {
+	name := request.Param("name")
+	current, err := h.find(request, name)
+	if err != nil {
+		return err
 	}
 
-	log.Println("Testing ", fingerprint)
+	var body api.UserChanges
+	if err = request.ReadBody(&body); err != nil {
+		return err
+	}
+	if err = validateAccess(body.Repositories); err != nil {
+		return err
+	}
+	if err = h.validateRoles(body.Roles); err != nil {
+		return err
+	}
+	admin, repositories, roles := current.Admin, current.Repositories, current.Roles
+	if body.Admin != nil {
+		admin = *body.Admin
+	}
+	if body.Repositories != nil {
+		repositories = body.Repositories
+	}
+	if body.Roles != nil {
+		roles = body.Roles
+	}
+	if err = h.authorizeGrants(request, current, admin, repositories, roles); err != nil {
+		return err
+	}
 
-	user := api.User{
-		Name:     request.User.Name,
-		Password: "asdf",
-		PublicKeys: []api.PublicKey{
-			{
-				Name:        "OSX",
-				Fingerprint: fingerprint,
-				PublicKey:   "???",
-			},
-		},
+	u, err := h.Users.UpdateUser(request.Author(), name, &body)
+	if err != nil {
+		return toUserRequestError(err)
 	}
-	bytes, _ := json.Marshal(user)
+	bytes, _ := json.Marshal(toApiUser(u, h.Organizations))
 	_, _ = request.Ok(bytes)
 	return nil
 }
 
-func (h *Users) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("Hello World"))
+func (h *Users) changePassword(request *Request) error {
+	u, err := h.find(request, request.Param("name"))
+	if err != nil {
+		return err
+	}
+	if err = authorizeCredentials(request, u); err != nil {
+		return err
+	}
+
+	var body api.PasswordChange
+	if err = request.ReadBody(&body); err != nil {
+		return err
+	}
+
+	// Administrators are allowed to reset other users' passwords, but everyone must know their own password
+	if request.User.Name == u.Name && !u.VerifyPassword(body.CurrentPassword) {
+		return toUserRequestError(user.IncorrectPasswordError)
+	}
+
+	if err = h.Users.SetPassword(request.Author(), u.Name, body.NewPassword); err != nil {
+		if err == user.PasswordTooShortError {
+			return responses.NewFieldError("NewPassword", err.Error())
+		}
+		return toUserRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+func (h *Users) delete(request *Request) error {
+	name := request.Param("name")
+	if _, err := h.find(request, name); err != nil {
+		return err
+	}
+	for _, r := range h.Repositories.GetRepositories() {
+		if repository.Owner(r.Name) == name {
+			return &responses.ConflictError{Message: "the user still owns repositories"}
+		}
+	}
+
+	if err := h.Users.RemoveUser(request.Author(), name); err != nil {
+		return toUserRequestError(err)
+	}
+	if err := h.Tokens.RemoveUserTokens(request.Author(), name); err != nil {
+		return fmt.Errorf("could not revoke tokens for removed user %s: %v", name, err)
+	}
+	if err := h.Organizations.RemoveUser(request.Author(), name); err != nil {
+		return fmt.Errorf("could not remove user %s from organizations: %v", name, err)
+	}
+	request.NoContent()
+	return nil
+}
+
+// find a user
+func (h *Users) find(request *Request, name string) (*user.User, error) {
+	u := h.Users.GetUser(name)
+	if u == nil {
+		return nil, &responses.NotFoundError{Message: "user not found"}
+	}
+	return u, nil
+}
+
+// validateAccess verifies that all repository access levels are known
+func validateAccess(repositories map[string]api.Access) error {
+	for name, access := range repositories {
+		switch access {
+		case api.AccessNone, api.AccessRead, api.AccessWrite, api.AccessAdmin:
+		default:
+			return responses.NewFieldError("Repositories",
+				fmt.Sprintf("unknown access level '%s' for repository '%s'", access, name))
+		}
+		if !repository.IsValidName(name) {
+			return responses.NewFieldError("Repositories",
+				fmt.Sprintf("repository name '%s' is not in the form 'owner/name'", name))
+		}
+	}
+	return nil
+}
+
+// validateRoles verifies that all roles exist. The service role is only assigned to trusted services
+func (h *Users) validateRoles(roles []string) error {
+	for _, name := range roles {
+		if name == api.RoleService {
+			return responses.NewFieldError("Roles", fmt.Sprintf("role '%s' can't be assigned to users", name))
+		}
+		if !h.Roles.Exists(name) {
+			return responses.NewFieldError("Roles", fmt.Sprintf("role '%s' does not exist", name))
+		}
+	}
+	return nil
+}
+
+// authorizeGrants verifies that the caller already holds everything that's granted to, or revoked from, the
+// supplied user, so that users:manage can't be used to gain more permissions than the caller has
+func (h *Users) authorizeGrants(request *Request, current *user.User, admin bool,
+	repositories map[string]api.Access, roles []string) error {
+	permissions := request.Permissions()
+	if admin != current.Admin && !holdsAll(permissions, server.BuiltInRoles[api.RoleSiteAdmin]) {
+		return &responses.ForbiddenError{Message: "only site administrators can grant or revoke the admin flag"}
+	}
+
+	for _, name := range changedRoles(current.Roles, roles) {
+		var granted []api.Permission
+		if builtIn, ok := server.BuiltInRoles[name]; ok {
+			granted = builtIn
+		} else if r := h.Roles.GetRole(name); r != nil {
+			granted = r.Permissions
+		}
+		if !holdsAll(permissions, granted) {
+			return &responses.ForbiddenError{
+				Message: fmt.Sprintf("you can't assign or remove role '%s' without holding its permissions", name)}
+		}
+	}
+
+	for name, access := range changedAccess(current.Repositories, repositories) {
+		if !permissions.GetAccess(name).Allows(access) {
+			return &responses.ForbiddenError{
+				Message: fmt.Sprintf("you can't change access to '%s' without %s access to it", name, access)}
+		}
+	}
+	return nil
+}
+
+// holdsAll checks if all the supplied permissions are granted on the whole server
+func holdsAll(permissions *server.Permissions, granted []api.Permission) bool {
+	for _, permission := range granted {
+		if !permissions.HasOnSite(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// changedRoles returns the roles that are only found in one of the supplied lists
+func changedRoles(before []string, after []string) []string {
+	count := map[string]int{}
+	for _, name := range before {
+		count[name] |= 1
+	}
+	for _, name := range after {
+		count[name] |= 2
+	}
+	var result []string
+	for name, c := range count {
+		if c != 3 {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// changedAccess returns the highest of the old and the new access level for each repository whose access is
+// changed
+func changedAccess(before map[string]api.Access, after map[string]api.Access) map[string]api.Access {
+	result := map[string]api.Access{}
+	for name, access := range after {
+		if old, ok := before[name]; !ok || old != access {
+			result[name] = access
+			if ok && old.Allows(access) {
+				result[name] = old
+			}
+		}
+	}
+	for name, access := range before {
+		if _, ok := after[name]; !ok {
+			result[name] = access
+		}
+	}
+	return result
+}
+
+// toApiUser converts a user into an api representation, including the teams the user is a member of
+func toApiUser(u *user.User, organizations organization.Database) *api.User {
+	result := u.ToApi()
+	result.Teams = organizations.GetTeams(u.Name)
+	return result
+}
+
+// toUserRequestError converts errors from the user database into request errors
+func toUserRequestError(err error) error {
+	switch err {
+	case user.UserNotFoundError:
+		return &responses.NotFoundError{Message: err.Error()}
+	case user.UserAlreadyExistsError, user.NameAlreadyUsedError, user.LastAdminError:
+		return &responses.ConflictError{Message: err.Error()}
+	case user.InvalidNameError:
+		return responses.NewFieldError("Name", err.Error())
+	case user.PasswordTooShortError:
+		return responses.NewFieldError("Password", err.Error())
+	case user.IncorrectPasswordError:
+		return &responses.ForbiddenError{Message: err.Error()}
+	}
+	return err
 }
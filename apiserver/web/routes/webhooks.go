@@ -0,0 +1,175 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
+	"github.com/westcoastcode-se/gitgo/apiserver/webhook"
+	"net/http"
+)
+
+// WebhooksPath is the uri used when managing webhooks registered for the whole server
+const WebhooksPath = "/api/v1/webhooks"
+
+// Webhooks is a route used when managing webhooks. Webhooks registered for the whole server are told about all
+// events and require permission to manage repositories. Webhooks registered for a repository are only told
+// about events in that repository and require admin access to it
+//
+// GET    /api/v1/webhooks
+// POST   /api/v1/webhooks
+// GET    /api/v1/webhooks/{id}
+// DELETE /api/v1/webhooks/{id}
+// GET    /api/v1/webhooks/{id}/deliveries
+// POST   /api/v1/webhooks/{id}/deliveries/{delivery}/redeliver
+// GET    /api/v1/repositories/{owner}/{repository}/webhooks
+// POST   /api/v1/repositories/{owner}/{repository}/webhooks
+// GET    /api/v1/repositories/{owner}/{repository}/webhooks/{id}
+// DELETE /api/v1/repositories/{owner}/{repository}/webhooks/{id}
+// GET    /api/v1/repositories/{owner}/{repository}/webhooks/{id}/deliveries
+// POST   /api/v1/repositories/{owner}/{repository}/webhooks/{id}/deliveries/{delivery}/redeliver
+type Webhooks struct {
+	Repositories repository.Database
+	Webhooks     webhook.Database
+}
+
+// Register adds all webhook routes to the supplied router
+func (h *Webhooks) Register(router *Router) {
+	paths := map[string]api.Permission{
+		WebhooksPath:                 api.PermissionRepositoriesManage,
+		RepositoryPath + "/webhooks": api.PermissionRepositoryAdmin,
+	}
+	for path, permission := range paths {
+		router.HandleFunc(http.MethodGet, path, permission, h.list)
+		router.HandleFunc(http.MethodPost, path, permission, h.create)
+		router.HandleFunc(http.MethodGet, path+"/{id}", permission, h.get)
+		router.HandleFunc(http.MethodDelete, path+"/{id}", permission, h.remove)
+		router.HandleFunc(http.MethodGet, path+"/{id}/deliveries", permission, h.deliveries)
+		router.HandleFunc(http.MethodPost, path+"/{id}/deliveries/{delivery}/redeliver", permission, h.redeliver)
+	}
+}
+
+// authorize returns the repository the webhooks targeted by the request are registered for. The repository is
+// empty for webhooks registered for the whole server
+func (h *Webhooks) authorize(request *Request) (string, error) {
+	name := request.Repository()
+	if len(name) == 0 {
+		return "", nil
+	}
+	if r := h.Repositories.GetRepository(name); r == nil || r.Deleted {
+		return "", &responses.NotFoundError{Message: "repository not found"}
+	}
+	return name, nil
+}
+
+// find the webhook targeted by the request. Webhooks registered for another repository are considered to
+// not exist
+func (h *Webhooks) find(request *Request) (*webhook.Webhook, error) {
+	name, err := h.authorize(request)
+	if err != nil {
+		return nil, err
+	}
+	w := h.Webhooks.GetWebhook(request.Param("id"))
+	if w == nil || w.Repository != name {
+		return nil, &responses.NotFoundError{Message: webhook.WebhookNotFoundError.Error()}
+	}
+	return w, nil
+}
+
+func (h *Webhooks) list(request *Request) error {
+	name, err := h.authorize(request)
+	if err != nil {
+		return err
+	}
+	result := api.Webhooks{Webhooks: []api.Webhook{}}
+	for _, w := range h.Webhooks.GetWebhooks(name) {
+		result.Webhooks = append(result.Webhooks, *w.ToApi())
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Webhooks) create(request *Request) error {
+	name, err := h.authorize(request)
+	if err != nil {
+		return err
+	}
+
+	var body api.NewWebhook
+	if err = request.ReadBody(&body); err != nil {
+		return err
+	}
+
+	w, secret, err := h.Webhooks.AddWebhook(request.Author(), name, body.URL, body.Secret, body.Events)
+	if err != nil {
+		return toWebhookRequestError(err)
+	}
+
+	bytes, _ := json.Marshal(&api.CreatedWebhook{Webhook: *w.ToApi(), Secret: secret})
+	_, _ = request.Created(bytes)
+	return nil
+}
+
+func (h *Webhooks) get(request *Request) error {
+	w, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	bytes, _ := json.Marshal(w.ToApi())
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Webhooks) remove(request *Request) error {
+	w, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	if err = h.Webhooks.RemoveWebhook(request.Author(), w.ID); err != nil {
+		return toWebhookRequestError(err)
+	}
+	request.NoContent()
+	return nil
+}
+
+func (h *Webhooks) deliveries(request *Request) error {
+	w, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	result := api.WebhookDeliveries{Deliveries: []api.WebhookDelivery{}}
+	for _, delivery := range h.Webhooks.GetDeliveries(w.ID) {
+		result.Deliveries = append(result.Deliveries, *delivery.ToApi())
+	}
+	bytes, _ := json.Marshal(result)
+	_, _ = request.Ok(bytes)
+	return nil
+}
+
+func (h *Webhooks) redeliver(request *Request) error {
+	w, err := h.find(request)
+	if err != nil {
+		return err
+	}
+	delivery, err := h.Webhooks.Redeliver(w.ID, request.Param("delivery"))
+	if err != nil {
+		return toWebhookRequestError(err)
+	}
+	bytes, _ := json.Marshal(delivery.ToApi())
+	_, _ = request.Created(bytes)
+	return nil
+}
+
+// toWebhookRequestError converts errors from the webhook database into request errors
+func toWebhookRequestError(err error) error {
+	switch err {
+	case webhook.WebhookNotFoundError, webhook.DeliveryNotFoundError:
+		return &responses.NotFoundError{Message: err.Error()}
+	case webhook.InvalidURLError, webhook.BlockedAddressError:
+		return responses.NewFieldError("URL", err.Error())
+	case webhook.InvalidEventError:
+		return responses.NewFieldError("Events", err.Error())
+	}
+	return err
+}
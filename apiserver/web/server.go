@@ -1,50 +1,216 @@
 package web
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
+	"errors"
 	"fmt"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"github.com/westcoastcode-se/gitgo/apiserver/eventlog"
+	"github.com/westcoastcode-se/gitgo/apiserver/invalidation"
+	"github.com/westcoastcode-se/gitgo/apiserver/organization"
+	"github.com/westcoastcode-se/gitgo/apiserver/pki"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/role"
 	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/token"
 	"github.com/westcoastcode-se/gitgo/apiserver/user"
 	"github.com/westcoastcode-se/gitgo/apiserver/web/responses"
 	"github.com/westcoastcode-se/gitgo/apiserver/web/routes"
-	"io/ioutil"
+	"github.com/westcoastcode-se/gitgo/apiserver/webhook"
 	"log"
 	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type Server struct {
 	Config server.Config
 
+	// Processor raises events reported by other services, such as the git server
+	Processor *event.Processor
+
+	// EventLog contains all events raised by the processor
+	EventLog *eventlog.Log
+
+	// Database stores all data, such as users and repositories
+	Database db.ContentDatabase
+
+	// Users is the database containing all users
+	Users user.Database
+
+	// Organizations is the database containing all organizations and their teams
+	Organizations organization.Database
+
+	// Roles is the database containing all custom roles
+	Roles role.Database
+
+	// Repositories is the database containing all repositories
+	Repositories repository.Database
+
+	// Tokens is the database containing all personal access tokens
+	Tokens token.Database
+
+	// Webhooks is the database containing all webhooks and their deliveries
+	Webhooks webhook.Database
+
+	// Certificates is the certificate authority and the certificates issued by it
+	Certificates pki.Database
+
+	// Invalidations tells clients about changes to data they might have cached
+	Invalidations *invalidation.Broker
+
 	listener net.Listener
 	server   *http.Server
+	tls      *tlsFiles
+	router   *routes.Router
+
+	// internalRouter serves the internal api, which is only used by trusted services
+	internalRouter *routes.Router
 }
 
+// ServeTLS serves requests until the server is shut down
 func (s *Server) ServeTLS() error {
-	if err := s.server.ServeTLS(s.listener, s.Config.CertPath, s.Config.PrivateKey); err != nil {
+	if err := s.server.ServeTLS(s.listener, "", ""); err != nil &&
+		err != http.ErrServerClosed {
 		return err
 	}
 	return nil
 }
 
+// ReloadCertificates reads the api server's certificate and the certificate authority again. New connections use
+// the new certificates, while established connections are kept open
+func (s *Server) ReloadCertificates() error {
+	return s.tls.load()
+}
+
+// WatchCertificates reloads the certificates when their files are changed. It blocks until the supplied channel
+// is closed
+func (s *Server) WatchCertificates(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !s.tls.changed() {
+				continue
+			}
+			log.Println("INFO: Reloading the certificates since they are changed")
+			if err := s.tls.load(); err != nil {
+				log.Printf("WARN: could not reload the certificates: %v\n", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Shutdown stops accepting new requests and waits for the active requests to complete
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
 func (s Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	request := routes.FromHttpRequest(rw, r)
-	var commonName = TryExtractCommonName(r.TLS)
-	request.User = &user.User{
-		Name:       commonName,
-		Password:   "",
-		PublicKeys: nil,
+	u, permissions, authErr := s.authenticate(r)
+	if authErr != nil {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="GitGo"`)
+		request.Error(authErr)
+		return
+	}
+	if u == nil {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="GitGo"`)
+		request.Error(&responses.UnauthorizedError{Message: "not logged in"})
+		return
 	}
-	var usersRoute = &routes.Users{}
+	request.User = u
+	request.Context.SetValue(server.ContextUser, u)
+	request.Context.SetValue(server.ContextPermissions, permissions)
 
-	err := usersRoute.ServeRoute(request)
-	if err != nil {
-		responses.WriteError(r.RequestURI, &responses.NotFoundError{}, rw)
+	// Services are only allowed to use the internal api, and only services are allowed to use it
+	router := s.router
+	if strings.HasPrefix(r.URL.Path, routes.InternalPath+"/") {
+		if !permissions.IsService() {
+			request.Error(&responses.ForbiddenError{Message: "the internal api is only available to services"})
+			return
+		}
+		router = s.internalRouter
+	} else if permissions.IsService() {
+		request.Error(&responses.ForbiddenError{Message: "services are only allowed to use the internal api"})
+		return
+	}
+
+	route, params, allowed := router.Match(r.Method, r.URL.EscapedPath())
+	if route == nil {
+		if len(allowed) > 0 {
+			rw.Header().Set("Allow", strings.Join(allowed, ", "))
+			request.Error(&responses.MethodNotAllowedError{
+				Message: fmt.Sprintf("method %s is not allowed", r.Method),
+			})
+		} else {
+			request.Error(&responses.NotFoundError{Message: "no such route"})
+		}
+		return
+	}
+	request.Params = params
+
+	if err := route.ServeRoute(request); err != nil {
+		// Data is changed by someone else, such as another api server or an administrator, while the request
+		// was served. The client is expected to try again once the server has reloaded the data
+		if errors.Is(err, db.VersionConflictError) {
+			err = &responses.ConflictError{Message: err.Error()}
+		}
+		requestError := responses.ToRequestError(err)
+		if requestError.StatusCode() == http.StatusInternalServerError {
+			log.Printf("WARN: could not serve %s %s (request %s): %v\n", r.Method, r.RequestURI,
+				request.Context.GetRequestUUID(), err)
+		}
+		request.Error(requestError)
 	}
 }
 
-func NewServer(cfg server.Config) (*Server, error) {
+// newRouter creates a router with all routes served by the supplied server
+func newRouter(s *Server) *routes.Router {
+	router := routes.NewRouter()
+	router.Use(s.authorize)
+	(&routes.Users{Users: s.Users, Tokens: s.Tokens, Organizations: s.Organizations,
+		Roles: s.Roles, Repositories: s.Repositories}).Register(router)
+	(&routes.PublicKeys{Users: s.Users}).Register(router)
+	(&routes.Tokens{Users: s.Users, Tokens: s.Tokens, Organizations: s.Organizations}).Register(router)
+	(&routes.Organizations{Organizations: s.Organizations, Users: s.Users,
+		Repositories: s.Repositories}).Register(router)
+	(&routes.Repositories{Repositories: s.Repositories, Users: s.Users, Webhooks: s.Webhooks,
+		Organizations: s.Organizations}).Register(router)
+	(&routes.Roles{Roles: s.Roles, Users: s.Users}).Register(router)
+	(&routes.RepositoryAccess{Users: s.Users, Providers: s.providers()}).Register(router)
+	(&routes.RepositoryProtection{Repositories: s.Repositories, Users: s.Users,
+		Organizations: s.Organizations}).Register(router)
+	(&routes.Webhooks{Repositories: s.Repositories, Webhooks: s.Webhooks}).Register(router)
+	(&routes.Database{Database: s.Database}).Register(router)
+	(&routes.Certificates{Certificates: s.Certificates}).Register(router)
+	(&routes.EventLog{Log: s.EventLog, MaxWait: s.Config.WriteTimeout - time.Second}).Register(router)
+	return router
+}
+
+// newInternalRouter creates a router with all routes used by trusted services, such as the git server
+func newInternalRouter(s *Server) *routes.Router {
+	router := routes.NewRouter()
+	router.Use(s.authorize)
+	(&routes.Users{Users: s.Users, Organizations: s.Organizations}).RegisterInternal(router)
+	(&routes.Tokens{Users: s.Users, Tokens: s.Tokens, Organizations: s.Organizations}).RegisterInternal(router)
+	(&routes.RepositoryAccess{Users: s.Users, Providers: s.providers()}).RegisterInternal(router)
+	(&routes.RepositoryProtection{Repositories: s.Repositories}).RegisterInternal(router)
+	(&routes.Events{Repositories: s.Repositories, Processor: s.Processor}).Register(router)
+	(&routes.Invalidations{Broker: s.Invalidations, MaxWait: s.Config.WriteTimeout - time.Second}).Register(router)
+	return router
+}
+
+func NewServer(cfg server.Config, processor *event.Processor, eventLog *eventlog.Log, database db.ContentDatabase,
+	users user.Database, organizations organization.Database, roles role.Database, repositories repository.Database,
+	tokens token.Database, webhooks webhook.Database, certificates pki.Database,
+	invalidations *invalidation.Broker) (*Server, error) {
 	log.Printf("INFO: Creating web server on %s\n", cfg.Address)
 
 	// Listen for requests
@@ -53,29 +219,41 @@ func NewServer(cfg server.Config) (*Server, error) {
 		return nil, fmt.Errorf("could not listen for requests on %v", err)
 	}
 
-	log.Println("INFO: Reading CA user to verify client-side certificates")
-	cert, err := ioutil.ReadFile(cfg.CAPath)
-	if err != nil {
-		return nil, err
-	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(cert)
-
 	s := &http.Server{
 		Addr:         cfg.Address,
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
 		IdleTimeout:  cfg.IdleTimeout,
-		TLSConfig: &tls.Config{
-			ClientAuth: tls.VerifyClientCertIfGiven,
-			ClientCAs:  caCertPool,
-		},
 	}
 	result := &Server{
-		Config:   cfg,
-		server:   s,
-		listener: l,
+		Config:        cfg,
+		Processor:     processor,
+		EventLog:      eventLog,
+		Database:      database,
+		Users:         users,
+		Organizations: organizations,
+		Roles:         roles,
+		Repositories:  repositories,
+		Tokens:        tokens,
+		Webhooks:      webhooks,
+		Certificates:  certificates,
+		Invalidations: invalidations,
+		server:        s,
+		listener:      l,
+	}
+
+	log.Println("INFO: Reading CA user to verify client-side certificates")
+	result.tls, err = newTLSFiles(cfg.CertPath, cfg.PrivateKey, cfg.CAPath, result.verifyRevocation)
+	if err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	s.TLSConfig = &tls.Config{
+		GetCertificate:     result.tls.getCertificate,
+		GetConfigForClient: result.tls.getConfigForClient,
 	}
+	result.router = newRouter(result)
+	result.internalRouter = newInternalRouter(result)
 	s.Handler = result
 	return result, nil
 }
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/jsondb"
+	"github.com/westcoastcode-se/gitgo/apiserver/server"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"github.com/westcoastcode-se/gitgo/apiserver/web/routes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// newTestServer creates a server with an empty database containing the user "per" with the password "password123"
+func newTestServer(t *testing.T) *Server {
+	dir, err := ioutil.TempDir("", "gitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	users, err := user.New(jsondb.New(dir, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := user.HashPassword("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = users.AddUser("superuser", &user.User{Name: "per", PasswordHash: hash}); err != nil {
+		t.Fatal(err)
+	}
+	return &Server{
+		Config:         server.LoadConfig(),
+		Users:          users,
+		router:         routes.NewRouter(),
+		internalRouter: routes.NewRouter(),
+	}
+}
+
+func TestServeHTTPRoutes(t *testing.T) {
+	s := newTestServer(t)
+	ok := func(request *routes.Request) error {
+		_, err := request.Ok([]byte(`{}`))
+		return err
+	}
+	s.router.HandleFunc(http.MethodGet, "/api/v1/things", api.PermissionNone, ok)
+	s.router.HandleFunc(http.MethodPost, "/api/v1/things", api.PermissionNone, ok)
+	s.router.HandleFunc(http.MethodDelete, "/api/v1/things/{thing}", api.PermissionNone, ok)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		statusCode int
+		allow      string
+	}{
+		{"matching route", http.MethodGet, "/api/v1/things", http.StatusOK, ""},
+		{"head served by get", http.MethodHead, "/api/v1/things", http.StatusOK, ""},
+		{"route with parameter", http.MethodDelete, "/api/v1/things/a%2Fb", http.StatusOK, ""},
+		{"method not allowed", http.MethodPut, "/api/v1/things", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+		{"method not allowed with parameter", http.MethodGet, "/api/v1/things/a", http.StatusMethodNotAllowed,
+			"DELETE"},
+		{"unknown route", http.MethodGet, "/api/v1/other", http.StatusNotFound, ""},
+		{"too many segments", http.MethodDelete, "/api/v1/things/a/b", http.StatusNotFound, ""},
+		{"internal route for users", http.MethodGet, routes.InternalPath + "/things", http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, nil)
+			r.SetBasicAuth("per", "password123")
+			rw := httptest.NewRecorder()
+			s.ServeHTTP(rw, r)
+			if rw.Code != test.statusCode {
+				t.Errorf("expected status code %d but was %d", test.statusCode, rw.Code)
+			}
+			if actual := rw.Header().Get("Allow"); actual != test.allow {
+				t.Errorf("expected Allow to be %q but was %q", test.allow, actual)
+			}
+		})
+	}
+}
+
+func TestServeHTTPAuthentication(t *testing.T) {
+	s := newTestServer(t)
+	s.router.HandleFunc(http.MethodGet, "/api/v1/things", api.PermissionNone, func(request *routes.Request) error {
+		_, err := request.Ok([]byte(`{}`))
+		return err
+	})
+
+	tests := []struct {
+		name       string
+		user       string
+		password   string
+		statusCode int
+	}{
+		{"valid password", "per", "password123", http.StatusOK},
+		{"wrong password", "per", "wrong", http.StatusUnauthorized},
+		{"unknown user", "bob", "password123", http.StatusUnauthorized},
+		{"not logged in", "", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/things", nil)
+			if len(test.user) > 0 {
+				r.SetBasicAuth(test.user, test.password)
+			}
+			rw := httptest.NewRecorder()
+			s.ServeHTTP(rw, r)
+			if rw.Code != test.statusCode {
+				t.Errorf("expected status code %d but was %d", test.statusCode, rw.Code)
+			}
+		})
+	}
+}
@@ -2,8 +2,124 @@ package web
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
 )
 
+// tlsFiles contains the api server's certificate and the certificate authority used to verify client-side
+// certificates. The files are read again when they are changed, so that certificates can be renewed without
+// restarting the server. Connections that are already established keep using the certificates they were
+// created with
+type tlsFiles struct {
+	certPath string
+	keyPath  string
+	caPath   string
+
+	// verifyPeerCertificate is called after a client-side certificate is verified by the certificate authority
+	verifyPeerCertificate func([][]byte, [][]*x509.Certificate) error
+
+	mutex  sync.RWMutex
+	config *tls.Config
+
+	// modTimes contains when the files were changed the last time they were loaded
+	modTimes [3]time.Time
+}
+
+// load reads all files. The current certificates are kept if any of the files can't be read
+func (f *tlsFiles) load() error {
+	modTimes := f.stat()
+	f.mutex.Lock()
+	f.modTimes = modTimes
+	f.mutex.Unlock()
+
+	certificate, err := tls.LoadX509KeyPair(f.certPath, f.keyPath)
+	if err != nil {
+		return fmt.Errorf("could not load %s: %v", f.certPath, err)
+	}
+	ca, err := ioutil.ReadFile(f.caPath)
+	if err != nil {
+		return err
+	}
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("%s does not contain a PEM encoded certificate", f.caPath)
+	}
+
+	config := &tls.Config{
+		Certificates:          []tls.Certificate{certificate},
+		ClientAuth:            tls.VerifyClientCertIfGiven,
+		ClientCAs:             caCertPool,
+		VerifyPeerCertificate: f.verifyPeerCertificate,
+		NextProtos:            []string{"h2", "http/1.1"},
+	}
+	f.mutex.Lock()
+	f.config = config
+	f.mutex.Unlock()
+	return nil
+}
+
+// changed checks if any of the files are changed since they were loaded
+func (f *tlsFiles) changed() bool {
+	modTimes := f.stat()
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return modTimes != f.modTimes
+}
+
+func (f *tlsFiles) stat() [3]time.Time {
+	var result [3]time.Time
+	for i, path := range []string{f.certPath, f.keyPath, f.caPath} {
+		if info, err := os.Stat(path); err == nil {
+			result[i] = info.ModTime()
+		}
+	}
+	return result
+}
+
+// getConfigForClient returns the configuration used for each new connection
+func (f *tlsFiles) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.config, nil
+}
+
+// getCertificate returns the api server's current certificate
+func (f *tlsFiles) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return &f.config.Certificates[0], nil
+}
+
+// newTLSFiles reads the certificates used when serving https
+func newTLSFiles(certPath string, keyPath string, caPath string,
+	verifyPeerCertificate func([][]byte, [][]*x509.Certificate) error) (*tlsFiles, error) {
+	result := &tlsFiles{
+		certPath:              certPath,
+		keyPath:               keyPath,
+		caPath:                caPath,
+		verifyPeerCertificate: verifyPeerCertificate,
+	}
+	if err := result.load(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// verifyRevocation rejects client-side certificates that are found in the certificate revocation list. It's called
+// after the certificate is verified using the certificate authority
+func (s *Server) verifyRevocation(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		if len(chain) > 0 && s.Certificates.IsRevoked(chain[0]) {
+			return fmt.Errorf("certificate %x for %s is revoked", chain[0].SerialNumber, chain[0].Subject.CommonName)
+		}
+	}
+	return nil
+}
+
 // TryExtractCommonName will try to extract the client certificates common name. This is assumed to be the username
 func TryExtractCommonName(connectionState *tls.ConnectionState) string {
 	if connectionState != nil && len(connectionState.VerifiedChains) > 0 && len(connectionState.VerifiedChains[0]) > 0 {
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/jsondb"
+	"github.com/westcoastcode-se/gitgo/apiserver/pki"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newCertificates creates a certificate authority, and a database for the certificates issued by it, in a
+// temporary directory
+func newCertificates(t *testing.T) pki.Database {
+	dir, err := ioutil.TempDir("", "gitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	authority, err := pki.CreateAuthority(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), "GitGo CA",
+		24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificates, err := pki.New(jsondb.New(filepath.Join(dir, "db"), nil), authority,
+		filepath.Join(dir, "ca.crl"), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificates
+}
+
+// issue a certificate of the supplied type with the supplied common name
+func issue(t *testing.T, certificates pki.Database, commonName string, certType api.CertificateType) *x509.Certificate {
+	_, keyPair, err := certificates.Issue("superuser", &api.NewCertificate{
+		CommonName: commonName,
+		Type:       certType,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(keyPair.Certificate)
+	if block == nil {
+		t.Fatal("issued certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifyRevocation(t *testing.T) {
+	certificates := newCertificates(t)
+	s := &Server{Certificates: certificates}
+	valid := issue(t, certificates, "per", api.CertificateTypeClient)
+	revoked := issue(t, certificates, "bob", api.CertificateTypeClient)
+	if err := certificates.Revoke("superuser", revoked.SerialNumber.Text(16)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		chains   [][]*x509.Certificate
+		rejected bool
+	}{
+		{"valid certificate", [][]*x509.Certificate{{valid}}, false},
+		{"revoked certificate", [][]*x509.Certificate{{revoked}}, true},
+		{"revoked certificate in any chain", [][]*x509.Certificate{{valid}, {revoked}}, true},
+		{"no certificate", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := s.verifyRevocation(nil, test.chains)
+			if (err != nil) != test.rejected {
+				t.Errorf("expected the certificate to be rejected: %v, but the error was %v", test.rejected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// AddressPolicy decides which addresses webhooks can be sent to. Loopback, private, link-local and other internal
+// addresses are blocked, so that webhooks can't be used to reach services that are only available to the server,
+// unless they are in one of the allowed networks
+type AddressPolicy struct {
+	allowed []*net.IPNet
+}
+
+// IsAllowed checks if webhooks can be sent to the supplied address
+func (p *AddressPolicy) IsAllowed(ip net.IP) bool {
+	for _, network := range p.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !isInternal(ip)
+}
+
+// isAllowedURL checks the host of the supplied url if it's an ip address. Host names are checked once they are
+// resolved, when the delivery is sent
+func (p *AddressPolicy) isAllowedURL(u *url.URL) bool {
+	ip := net.ParseIP(u.Hostname())
+	return ip == nil || p.IsAllowed(ip)
+}
+
+// control is called by the dialer before connecting, so that the address is checked after the host name is
+// resolved and a host name can't be changed to point to an internal address after the webhook is added
+func (p *AddressPolicy) control(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !p.IsAllowed(ip) {
+		return BlockedAddressError
+	}
+	return nil
+}
+
+func isInternal(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// NewAddressPolicy creates a policy where webhooks can be sent to the supplied networks, in CIDR notation such as
+// "10.0.0.0/8", even though they are internal
+func NewAddressPolicy(allowed []string) (*AddressPolicy, error) {
+	result := &AddressPolicy{}
+	for _, cidr := range allowed {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s: %v", cidr, err)
+		}
+		result.allowed = append(result.allowed, network)
+	}
+	return result, nil
+}
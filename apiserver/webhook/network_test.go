@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestAddressPolicyIsAllowed(t *testing.T) {
+	policy, err := NewAddressPolicy([]string{"10.1.0.0/16", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		ip       string
+		expected bool
+	}{
+		{"public", "93.184.216.34", true},
+		{"public ipv6", "2606:2800:220:1:248:1893:25c8:1946", true},
+		{"loopback", "127.0.0.1", false},
+		{"loopback ipv6", "::1", false},
+		{"private", "192.168.1.10", false},
+		{"private in allowed network", "10.1.2.3", true},
+		{"private outside allowed network", "10.2.2.3", false},
+		{"link-local", "169.254.169.254", false},
+		{"link-local ipv6", "fe80::1", false},
+		{"unique local ipv6 in allowed network", "fd00::1", true},
+		{"unspecified", "0.0.0.0", false},
+		{"multicast", "224.0.0.1", false},
+		{"loopback mapped to ipv6", "::ffff:127.0.0.1", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := policy.IsAllowed(net.ParseIP(test.ip)); actual != test.expected {
+				t.Errorf("expected %v but was %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestAddressPolicyControl(t *testing.T) {
+	policy, err := NewAddressPolicy(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		address  string
+		expected error
+	}{
+		{"public", "93.184.216.34:443", nil},
+		{"loopback", "127.0.0.1:8080", BlockedAddressError},
+		{"link-local ipv6", "[fe80::1]:80", BlockedAddressError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := policy.control("tcp", test.address, nil); !errors.Is(err, test.expected) {
+				t.Errorf("expected %v but was %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestNewAddressPolicy(t *testing.T) {
+	if _, err := NewAddressPolicy([]string{"10.0.0.0"}); err == nil {
+		t.Error("expected a network without a prefix length to be rejected")
+	}
+}
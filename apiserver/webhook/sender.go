@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Sender sends pending deliveries to the webhooks. Deliveries are sent at least once, so a receiver might see
+// the same delivery id more than once if the server is restarted while a delivery is in progress
+type Sender struct {
+	// Webhooks is the database containing all webhooks and deliveries
+	Webhooks Database
+
+	// Interval is how often the database is checked for pending deliveries
+	Interval time.Duration
+
+	client *http.Client
+
+	mutex    sync.Mutex
+	inFlight map[string]bool
+}
+
+// Run sends deliveries as they become due. It never returns
+func (s *Sender) Run() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, delivery := range s.Webhooks.DueDeliveries(now) {
+			if s.begin(delivery.ID) {
+				go s.send(delivery)
+			}
+		}
+	}
+}
+
+// begin marks the supplied delivery as being sent. False is returned if it's already being sent
+func (s *Sender) begin(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.inFlight[id] {
+		return false
+	}
+	s.inFlight[id] = true
+	return true
+}
+
+func (s *Sender) send(delivery *Delivery) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.inFlight, delivery.ID)
+		s.mutex.Unlock()
+	}()
+
+	w := s.Webhooks.GetWebhook(delivery.Webhook)
+	if w == nil {
+		return
+	}
+
+	statusCode, err := s.post(w, delivery)
+	if err != nil {
+		log.Printf("WARN: could not deliver %s to webhook %s: %v\n", delivery.ID, w.ID, err)
+	}
+	result, err := s.Webhooks.RecordAttempt(delivery.ID, statusCode, err)
+	if err != nil {
+		log.Printf("WARN: could not record delivery %s to webhook %s: %v\n", delivery.ID, w.ID, err)
+		return
+	}
+	if result.NextAttemptAt != nil {
+		log.Printf("INFO: delivery %s to webhook %s is retried at %s\n", delivery.ID, w.ID,
+			result.NextAttemptAt.Format(time.RFC3339))
+	}
+}
+
+// post the payload to the webhook. The delivery is successful if the webhook responds with a 2xx status code
+func (s *Sender) post(w *Webhook, delivery *Delivery) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "GitGo-Webhook")
+	request.Header.Set("X-GitGo-Event", string(delivery.Event))
+	request.Header.Set("X-GitGo-Delivery", delivery.ID)
+	request.Header.Set("X-GitGo-Signature-256", w.Sign(delivery.Payload))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// NewSender creates a sender that waits at most the supplied timeout for each webhook to respond. Deliveries are
+// only sent to addresses allowed by the supplied policy
+func NewSender(webhooks Database, timeout time.Duration, addresses *AddressPolicy) *Sender {
+	// Proxies are never used, since the address the proxy connects to can't be checked
+	dialer := &net.Dialer{Timeout: timeout, Control: addresses.control}
+	return &Sender{
+		Webhooks: webhooks,
+		Interval: time.Second,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+			// Redirects are not followed, so that a delivery always ends up at the registered url
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		inFlight: map[string]bool{},
+	}
+}
@@ -0,0 +1,125 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/westcoastcode-se/gitgo/api"
+	"time"
+)
+
+type Webhooks struct {
+	Webhooks []*Webhook
+}
+
+type Webhook struct {
+	ID string
+
+	// Repository is the repository the webhook is registered for. Empty if it's registered for the whole server
+	Repository string
+
+	URL    string
+	Events []api.WebhookEvent
+
+	// Secret is used when signing payloads. It's stored as-is, because it's needed every time a payload is signed
+	Secret string
+
+	CreatedAt time.Time
+}
+
+// Matches checks if the webhook should be told about the supplied payload
+func (w *Webhook) Matches(payload *api.WebhookPayload) bool {
+	if len(w.Repository) > 0 && w.Repository != payload.Repository {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == payload.Event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign creates the signature sent together with the supplied body
+func (w *Webhook) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) ToApi() *api.Webhook {
+	return &api.Webhook{
+		ID:         w.ID,
+		Repository: w.Repository,
+		URL:        w.URL,
+		Events:     w.Events,
+		CreatedAt:  w.CreatedAt,
+	}
+}
+
+type Deliveries struct {
+	Deliveries []*Delivery
+}
+
+type Delivery struct {
+	ID            string
+	Webhook       string
+	Event         api.WebhookEvent
+	Payload       json.RawMessage
+	Status        api.DeliveryStatus
+	Attempts      int
+	StatusCode    int
+	Error         string
+	CreatedAt     time.Time
+	LastAttemptAt *time.Time
+	NextAttemptAt *time.Time
+}
+
+// IsDue checks if the delivery should be sent at the supplied time
+func (d *Delivery) IsDue(now time.Time) bool {
+	return d.Status == api.DeliveryPending && d.NextAttemptAt != nil && !d.NextAttemptAt.After(now)
+}
+
+func (d *Delivery) ToApi() *api.WebhookDelivery {
+	return &api.WebhookDelivery{
+		ID:            d.ID,
+		Webhook:       d.Webhook,
+		Event:         d.Event,
+		Payload:       d.Payload,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		StatusCode:    d.StatusCode,
+		Error:         d.Error,
+		CreatedAt:     d.CreatedAt,
+		LastAttemptAt: d.LastAttemptAt,
+		NextAttemptAt: d.NextAttemptAt,
+	}
+}
+
+// backoff returns how long to wait before a delivery is sent again, after it has failed the supplied number
+// of times
+func backoff(attempts int) time.Duration {
+	delay := RetryDelay
+	for i := 1; i < attempts && delay < MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxRetryDelay {
+		delay = MaxRetryDelay
+	}
+	return delay
+}
+
+// newSecret generates a new random secret
+func newSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,588 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/apiserver/db"
+	"github.com/westcoastcode-se/gitgo/apiserver/event"
+	"github.com/westcoastcode-se/gitgo/apiserver/repository"
+	"github.com/westcoastcode-se/gitgo/apiserver/user"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const DatabasePath = "/webhooks.json"
+
+// DeliveriesPath is where deliveries are stored, so that pending deliveries survive a restart
+const DeliveriesPath = "/webhook_deliveries.json"
+
+const (
+	// MaxAttempts is how many times a delivery is sent before it's considered failed
+	MaxAttempts = 8
+
+	// RetryDelay is how long to wait before the first retry. The delay is doubled for each failed attempt
+	RetryDelay = 30 * time.Second
+
+	// MaxRetryDelay is the longest time to wait between two attempts
+	MaxRetryDelay = time.Hour
+
+	// MaxDeliveries is how many deliveries are kept in the history of each webhook
+	MaxDeliveries = 50
+)
+
+var (
+	WebhookNotFoundError  = errors.New("webhook not found")
+	DeliveryNotFoundError = errors.New("delivery not found")
+	InvalidURLError       = errors.New("url must be an absolute http or https url")
+	InvalidEventError     = errors.New("unknown event")
+	BlockedAddressError   = errors.New("webhooks can't be sent to internal addresses")
+)
+
+type Database interface {
+	// Database reloads itself when the underlying data is changed. Events raised by the rest of the
+	// server are turned into deliveries
+	event.Listener
+
+	// AddWebhook registers a new webhook for a repository, or for the whole server if the repository is empty.
+	// A secret is generated if none is supplied. A ping is queued so that the receiver can verify the secret
+	AddWebhook(author string, repository string, url string, secret string, events []api.WebhookEvent) (*Webhook, string, error)
+
+	// GetWebhooks fetches all webhooks registered for the supplied repository, or for the whole server if the
+	// repository is empty
+	GetWebhooks(repository string) []*Webhook
+
+	// GetWebhook fetches a webhook based on its id
+	GetWebhook(id string) *Webhook
+
+	// RemoveWebhook removes a webhook together with its delivery history
+	RemoveWebhook(author string, id string) error
+
+	// RenameRepository moves all webhooks registered for a repository to its new name. The webhooks are written in
+	// the supplied transaction, and are changed once it's stored
+	RenameRepository(tx db.Transaction, oldName string, newName string) error
+
+	// RemoveRepository removes all webhooks registered for a removed repository. The webhooks are written in the
+	// supplied transaction, and are changed once it's stored
+	RemoveRepository(tx db.Transaction, name string) error
+
+	// GetDeliveries fetches the delivery history for a webhook, newest first
+	GetDeliveries(webhook string) []*Delivery
+
+	// Redeliver queues a new delivery with the same payload as an earlier delivery
+	Redeliver(webhook string, id string) (*Delivery, error)
+
+	// DueDeliveries fetches all deliveries that should be sent at the supplied time
+	DueDeliveries(now time.Time) []*Delivery
+
+	// RecordAttempt saves the result of sending a delivery. The delivery is retried with an exponential
+	// backoff if it failed, until MaxAttempts is reached
+	RecordAttempt(id string, statusCode int, err error) (*Delivery, error)
+}
+
+type DatabaseImpl struct {
+	// Database is a generic json database
+	contentDatabase db.ContentDatabase
+
+	// addresses decides which addresses webhooks can be sent to
+	addresses *AddressPolicy
+
+	webhooks   []*Webhook
+	deliveries []*Delivery
+	mutex      *sync.RWMutex
+
+	// webhooksVersion and deliveriesVersion are the versions of the files when they were last read or written
+	webhooksVersion   string
+	deliveriesVersion string
+}
+
+func (d *DatabaseImpl) AddWebhook(author string, repository string, rawURL string, secret string,
+	events []api.WebhookEvent) (*Webhook, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !isValidURL(u) {
+		return nil, "", InvalidURLError
+	}
+	if !d.addresses.isAllowedURL(u) {
+		return nil, "", BlockedAddressError
+	}
+	for _, e := range events {
+		if !e.IsValid() {
+			return nil, "", InvalidEventError
+		}
+	}
+	if len(secret) == 0 {
+		if secret, err = newSecret(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	w := &Webhook{
+		ID:         uuid.New().String(),
+		Repository: repository,
+		URL:        rawURL,
+		Events:     append([]api.WebhookEvent{}, events...),
+		Secret:     secret,
+		CreatedAt:  time.Now(),
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	webhooks := append(append([]*Webhook{}, d.webhooks...), w)
+	err = d.write(&Webhooks{webhooks}, author,
+		fmt.Sprintf("adding webhook %s for %s", w.ID, describe(repository)))
+	if err != nil {
+		return nil, "", err
+	}
+	d.webhooks = webhooks
+
+	ping := &api.WebhookPayload{Event: api.WebhookEventPing, CreatedAt: w.CreatedAt, Repository: repository}
+	if err = d.queue([]*Webhook{w}, ping); err != nil {
+		log.Printf("WARN: could not queue ping for webhook %s: %v\n", w.ID, err)
+	}
+	return w, secret, nil
+}
+
+func (d *DatabaseImpl) GetWebhooks(repository string) []*Webhook {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var result []*Webhook
+	for _, w := range d.webhooks {
+		if w.Repository == repository {
+			result = append(result, w)
+		}
+	}
+	return result
+}
+
+func (d *DatabaseImpl) GetWebhook(id string) *Webhook {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	for _, w := range d.webhooks {
+		if w.ID == id {
+			return w
+		}
+	}
+	return nil
+}
+
+func (d *DatabaseImpl) RemoveWebhook(author string, id string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i, w := range d.webhooks {
+		if w.ID != id {
+			continue
+		}
+		webhooks := append(append([]*Webhook{}, d.webhooks[:i]...), d.webhooks[i+1:]...)
+		err := d.write(&Webhooks{webhooks}, author, fmt.Sprintf("removing webhook %s", id))
+		if err != nil {
+			return err
+		}
+		d.webhooks = webhooks
+
+		var deliveries []*Delivery
+		for _, delivery := range d.deliveries {
+			if delivery.Webhook != id {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		if err = d.saveDeliveries(deliveries, fmt.Sprintf("removing deliveries for webhook %s", id)); err != nil {
+			log.Printf("WARN: could not remove deliveries for webhook %s: %v\n", id, err)
+		}
+		return nil
+	}
+	return WebhookNotFoundError
+}
+
+func (d *DatabaseImpl) RenameRepository(tx db.Transaction, oldName string, newName string) error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	changed := false
+	webhooks := make([]*Webhook, len(d.webhooks))
+	for i, w := range d.webhooks {
+		webhooks[i] = w
+		if w.Repository == oldName {
+			renamed := *w
+			renamed.Repository = newName
+			webhooks[i] = &renamed
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return tx.WriteVersion(DatabasePath, &Webhooks{webhooks}, d.webhooksVersion, func(version string) {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		d.webhooks = webhooks
+		d.webhooksVersion = version
+	})
+}
+
+func (d *DatabaseImpl) RemoveRepository(tx db.Transaction, name string) error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	webhooks := []*Webhook{}
+	removed := map[string]bool{}
+	for _, w := range d.webhooks {
+		if w.Repository == name {
+			removed[w.ID] = true
+		} else {
+			webhooks = append(webhooks, w)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	return tx.WriteVersion(DatabasePath, &Webhooks{webhooks}, d.webhooksVersion, func(version string) {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		d.webhooks = webhooks
+		d.webhooksVersion = version
+
+		// The deliveries are written the next time a delivery is changed, since the content database can't be
+		// written while the transaction is stored
+		var deliveries []*Delivery
+		for _, delivery := range d.deliveries {
+			if !removed[delivery.Webhook] {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		d.deliveries = deliveries
+	})
+}
+
+func (d *DatabaseImpl) GetDeliveries(webhook string) []*Delivery {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var result []*Delivery
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		if d.deliveries[i].Webhook == webhook {
+			result = append(result, d.deliveries[i])
+		}
+	}
+	return result
+}
+
+func (d *DatabaseImpl) Redeliver(webhook string, id string) (*Delivery, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, original := range d.deliveries {
+		if original.Webhook != webhook || original.ID != id {
+			continue
+		}
+		delivery := newDelivery(webhook, original.Event, original.Payload)
+		err := d.saveDeliveries(append(append([]*Delivery{}, d.deliveries...), delivery),
+			fmt.Sprintf("redelivering %s to webhook %s", id, webhook))
+		if err != nil {
+			return nil, err
+		}
+		return delivery, nil
+	}
+	return nil, DeliveryNotFoundError
+}
+
+func (d *DatabaseImpl) DueDeliveries(now time.Time) []*Delivery {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var result []*Delivery
+	for _, delivery := range d.deliveries {
+		if delivery.IsDue(now) {
+			result = append(result, delivery)
+		}
+	}
+	return result
+}
+
+func (d *DatabaseImpl) RecordAttempt(id string, statusCode int, attemptErr error) (*Delivery, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i, original := range d.deliveries {
+		if original.ID != id {
+			continue
+		}
+
+		// Deliveries are never changed in place, because they might be in use by someone else
+		now := time.Now()
+		delivery := *original
+		delivery.Attempts++
+		delivery.StatusCode = statusCode
+		delivery.LastAttemptAt = &now
+		delivery.NextAttemptAt = nil
+		delivery.Error = ""
+		if attemptErr == nil {
+			delivery.Status = api.DeliverySucceeded
+		} else {
+			delivery.Error = attemptErr.Error()
+			if delivery.Attempts >= MaxAttempts {
+				delivery.Status = api.DeliveryFailed
+			} else {
+				next := now.Add(backoff(delivery.Attempts))
+				delivery.NextAttemptAt = &next
+			}
+		}
+
+		deliveries := append([]*Delivery{}, d.deliveries...)
+		deliveries[i] = &delivery
+		err := d.saveDeliveries(deliveries, fmt.Sprintf("recording attempt %d of delivery %s", delivery.Attempts, id))
+		if err != nil {
+			return nil, err
+		}
+		return &delivery, nil
+	}
+	return nil, DeliveryNotFoundError
+}
+
+func (d *DatabaseImpl) OnEvent(e event.Event) error {
+	if changed, ok := e.(*db.EventDataChanged); ok {
+		switch changed.Path {
+		case DatabasePath:
+			return d.reloadWebhooks()
+		case DeliveriesPath:
+			return d.reloadDeliveries()
+		}
+		return nil
+	}
+
+	payload := toPayload(e)
+	if payload == nil {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var webhooks []*Webhook
+	for _, w := range d.webhooks {
+		if w.Matches(payload) {
+			webhooks = append(webhooks, w)
+		}
+	}
+	return d.queue(webhooks, payload)
+}
+
+// queue a new delivery of the supplied payload for each webhook. The mutex must be locked by the caller
+func (d *DatabaseImpl) queue(webhooks []*Webhook, payload *api.WebhookPayload) error {
+	if len(webhooks) == 0 {
+		return nil
+	}
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	deliveries := append([]*Delivery{}, d.deliveries...)
+	for _, w := range webhooks {
+		deliveries = append(deliveries, newDelivery(w.ID, payload.Event, bytes))
+	}
+	return d.saveDeliveries(deliveries, fmt.Sprintf("queueing %s event for %d webhooks", payload.Event,
+		len(webhooks)))
+}
+
+// saveDeliveries writes the supplied deliveries, after the oldest ones, and the ones sent to webhooks that no longer
+// exist, are removed from the history. The mutex must be locked by the caller
+func (d *DatabaseImpl) saveDeliveries(deliveries []*Delivery, message string) error {
+	deliveries = trim(d.removeOrphans(deliveries))
+	version, err := d.contentDatabase.WriteVersion(DeliveriesPath, &Deliveries{deliveries}, d.deliveriesVersion,
+		db.SystemAuthor, message)
+	if err != nil {
+		return err
+	}
+	d.deliveries = deliveries
+	d.deliveriesVersion = version
+	return nil
+}
+
+// write the supplied webhooks, if nobody else has changed the file since it was read. The mutex must be locked
+// by the caller
+func (d *DatabaseImpl) write(webhooks *Webhooks, author string, message string) error {
+	version, err := d.contentDatabase.WriteVersion(DatabasePath, webhooks, d.webhooksVersion, author, message)
+	if err != nil {
+		return err
+	}
+	d.webhooksVersion = version
+	return nil
+}
+
+func (d *DatabaseImpl) reloadWebhooks() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var webhooks Webhooks
+	version, err := d.contentDatabase.ReadVersion(DatabasePath, &webhooks)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.webhooksVersion = version
+		}
+		return err
+	}
+	d.webhooksVersion = version
+	d.webhooks = webhooks.Webhooks
+	return nil
+}
+
+func (d *DatabaseImpl) reloadDeliveries() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var deliveries Deliveries
+	version, err := d.contentDatabase.ReadVersion(DeliveriesPath, &deliveries)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.deliveriesVersion = version
+		}
+		return err
+	}
+	d.deliveriesVersion = version
+	d.deliveries = deliveries.Deliveries
+	return nil
+}
+
+// toPayload converts an event raised by the server into a webhook payload. Nil is returned if webhooks are
+// not told about the event
+func toPayload(e event.Event) *api.WebhookPayload {
+	payload := &api.WebhookPayload{CreatedAt: time.Now()}
+	switch e := e.(type) {
+	case *repository.EventRepositoryPushed:
+		payload.Event = api.WebhookEventPush
+		payload.Repository = e.Repository
+		payload.User = e.User
+		payload.Protocol = e.Protocol
+		payload.RefUpdates = e.RefUpdates
+	case *repository.EventRepositoryFetched:
+		payload.Event = api.WebhookEventFetch
+		payload.Repository = e.Repository
+		payload.User = e.User
+		payload.Protocol = e.Protocol
+	case *repository.EventRepositoryCreated:
+		payload.Event = api.WebhookEventRepositoryCreated
+		payload.Repository = e.Repository.Name
+	case *repository.EventRepositoryDeleted:
+		payload.Event = api.WebhookEventRepositoryDeleted
+		payload.Repository = e.Repository.Name
+	case *user.EventUserAdded:
+		payload.Event = api.WebhookEventUserAdded
+		payload.User = e.User.Name
+	case *user.EventUserRemoved:
+		payload.Event = api.WebhookEventUserRemoved
+		payload.User = e.User.Name
+	case *user.EventUserChanged:
+		payload.Event = api.WebhookEventUserChanged
+		payload.User = e.User.Name
+	case *user.EventPublicKeyAdded:
+		payload.Event = api.WebhookEventPublicKeyAdded
+		payload.User = e.User.Name
+		payload.Fingerprint = e.Key.Fingerprint
+	case *user.EventPublicKeyRemoved:
+		payload.Event = api.WebhookEventPublicKeyRemoved
+		payload.User = e.User.Name
+		payload.Fingerprint = e.Key.Fingerprint
+	default:
+		return nil
+	}
+	return payload
+}
+
+func newDelivery(webhook string, e api.WebhookEvent, payload json.RawMessage) *Delivery {
+	now := time.Now()
+	return &Delivery{
+		ID:            uuid.New().String(),
+		Webhook:       webhook,
+		Event:         e,
+		Payload:       payload,
+		Status:        api.DeliveryPending,
+		CreatedAt:     now,
+		NextAttemptAt: &now,
+	}
+}
+
+// removeOrphans removes the deliveries sent to webhooks that no longer exist. The mutex must be locked by the caller
+func (d *DatabaseImpl) removeOrphans(deliveries []*Delivery) []*Delivery {
+	webhooks := map[string]bool{}
+	for _, w := range d.webhooks {
+		webhooks[w.ID] = true
+	}
+	var result []*Delivery
+	for _, delivery := range deliveries {
+		if webhooks[delivery.Webhook] {
+			result = append(result, delivery)
+		}
+	}
+	return result
+}
+
+// trim removes the oldest deliveries, so that at most MaxDeliveries are kept for each webhook. Pending
+// deliveries are always kept
+func trim(deliveries []*Delivery) []*Delivery {
+	counts := map[string]int{}
+	keep := make([]bool, len(deliveries))
+	removed := 0
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		delivery := deliveries[i]
+		counts[delivery.Webhook]++
+		keep[i] = counts[delivery.Webhook] <= MaxDeliveries || delivery.Status == api.DeliveryPending
+		if !keep[i] {
+			removed++
+		}
+	}
+	if removed == 0 {
+		return deliveries
+	}
+	result := make([]*Delivery, 0, len(deliveries)-removed)
+	for i, delivery := range deliveries {
+		if keep[i] {
+			result = append(result, delivery)
+		}
+	}
+	return result
+}
+
+func isValidURL(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+}
+
+// describe the owner of a webhook in a commit message
+func describe(repository string) string {
+	if len(repository) == 0 {
+		return "the server"
+	}
+	return "repository " + repository
+}
+
+// New creates a webhook database where the webhooks and their deliveries are stored in the content database.
+// Webhooks can only be added if they are sent to an address allowed by the supplied policy
+func New(database db.ContentDatabase, addresses *AddressPolicy) (Database, error) {
+	result := &DatabaseImpl{
+		contentDatabase:   database,
+		addresses:         addresses,
+		webhooks:          []*Webhook{},
+		deliveries:        []*Delivery{},
+		mutex:             &sync.RWMutex{},
+		webhooksVersion:   db.MissingVersion,
+		deliveriesVersion: db.MissingVersion,
+	}
+
+	if err := db.AddCollection(database, DatabasePath, db.Collection{Field: "Webhooks", Key: "ID"}); err != nil {
+		return nil, err
+	}
+	if err := result.reloadWebhooks(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := result.reloadDeliveries(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return result, nil
+}
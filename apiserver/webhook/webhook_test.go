@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"testing"
+)
+
+func TestWebhookSign(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		body     string
+		expected string
+	}{
+		{"payload", "my secret", `{"Event":"push"}`,
+			"sha256=1665a6da8e2e7cb1a2426820a0bf1d14144738b5f49939340930f436a0e1cc8d"},
+		{"empty", "", "", "sha256=b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+		{"text", "secret", "hello", "sha256=88aab3ede8d3adf94d26ab90d3bafd4a2083070c3bcce9c014ee04a443847c0b"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &Webhook{Secret: test.secret}
+			if actual := w.Sign([]byte(test.body)); actual != test.expected {
+				t.Errorf("expected %s but was %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestWebhookMatches(t *testing.T) {
+	push := &api.WebhookPayload{Event: api.WebhookEventPush, Repository: "acme/website"}
+	tests := []struct {
+		name     string
+		webhook  *Webhook
+		expected bool
+	}{
+		{"all events on the server", &Webhook{}, true},
+		{"all events in the repository", &Webhook{Repository: "acme/website"}, true},
+		{"other repository", &Webhook{Repository: "acme/other"}, false},
+		{"subscribed event", &Webhook{Events: []api.WebhookEvent{api.WebhookEventPush}}, true},
+		{"other event", &Webhook{Events: []api.WebhookEvent{api.WebhookEventRepositoryDeleted}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.webhook.Matches(push); actual != test.expected {
+				t.Errorf("expected %v but was %v", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package apiserver
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"sync"
+	"time"
+)
+
+type userEntry struct {
+	// user is nil if no user has the public key
+	user      *api.User
+	expiresAt time.Time
+}
+
+type accessKey struct {
+	user       string
+	repository string
+}
+
+type accessEntry struct {
+	access    api.Access
+	expiresAt time.Time
+}
+
+// cache keeps track of users and access levels fetched from the api server. Lookups that didn't find anything
+// are cached as well, but for a shorter time.
+//
+// Every invalidation increases the generation. A value fetched from the api server is only put in the cache if
+// the generation hasn't changed since the request was sent, so that an invalidation can't be overwritten by a
+// request that was sent before it
+type cache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mutex      sync.Mutex
+	generation uint64
+	users      map[string]*userEntry
+	access     map[accessKey]*accessEntry
+}
+
+func (c *cache) getGeneration() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generation
+}
+
+// getUser returns the user with the supplied fingerprint. The second value is false if nothing is cached
+func (c *cache) getUser(fingerprint string) (*api.User, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.users[fingerprint]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.user, true
+}
+
+func (c *cache) putUser(generation uint64, fingerprint string, user *api.User) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	ttl := c.ttl
+	if user == nil {
+		ttl = c.negativeTTL
+	}
+	c.users[fingerprint] = &userEntry{user: user, expiresAt: time.Now().Add(ttl)}
+}
+
+// getAccess returns the user's access level to the supplied repository. The second value is false if
+// nothing is cached
+func (c *cache) getAccess(user string, repository string) (api.Access, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.access[accessKey{user, repository}]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return api.AccessNone, false
+	}
+	return entry.access, true
+}
+
+func (c *cache) putAccess(generation uint64, user string, repository string, access api.Access) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	ttl := c.ttl
+	if access == api.AccessNone {
+		ttl = c.negativeTTL
+	}
+	c.access[accessKey{user, repository}] = &accessEntry{access: access, expiresAt: time.Now().Add(ttl)}
+}
+
+// invalidate removes everything the supplied invalidation refers to
+func (c *cache) invalidate(invalidation api.Invalidation) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	switch invalidation.Type {
+	case api.InvalidateUser:
+		for fingerprint, entry := range c.users {
+			if entry.user != nil && entry.user.Name == invalidation.User {
+				delete(c.users, fingerprint)
+			}
+		}
+		for key := range c.access {
+			if key.user == invalidation.User {
+				delete(c.access, key)
+			}
+		}
+	case api.InvalidatePublicKey:
+		delete(c.users, invalidation.Fingerprint)
+	case api.InvalidateRepository:
+		for key := range c.access {
+			if key.repository == invalidation.Repository {
+				delete(c.access, key)
+			}
+		}
+	default:
+		c.clear()
+	}
+}
+
+// invalidateAll removes everything from the cache
+func (c *cache) invalidateAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	c.clear()
+}
+
+func (c *cache) clear() {
+	c.users = map[string]*userEntry{}
+	c.access = map[accessKey]*accessEntry{}
+}
+
+func newCache(ttl time.Duration, negativeTTL time.Duration) *cache {
+	return &cache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		users:       map[string]*userEntry{},
+		access:      map[accessKey]*accessEntry{},
+	}
+}
@@ -0,0 +1,105 @@
+package apiserver
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"testing"
+	"time"
+)
+
+// newFilledCache returns a cache with the users "per" and "bob", their access levels to two repositories and a
+// public key that no user has
+func newFilledCache() *cache {
+	c := newCache(time.Minute, time.Minute)
+	generation := c.getGeneration()
+	c.putUser(generation, "SHA256:per", &api.User{Name: "per"})
+	c.putUser(generation, "SHA256:bob", &api.User{Name: "bob"})
+	c.putUser(generation, "SHA256:unknown", nil)
+	c.putAccess(generation, "per", "per/website", api.AccessWrite)
+	c.putAccess(generation, "per", "bob/tools", api.AccessRead)
+	c.putAccess(generation, "bob", "per/website", api.AccessNone)
+	return c
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		invalidation api.Invalidation
+		users        []string
+		access       []accessKey
+	}{
+		{"user", api.Invalidation{Type: api.InvalidateUser, User: "per"},
+			[]string{"SHA256:bob", "SHA256:unknown"},
+			[]accessKey{{"bob", "per/website"}}},
+		{"public key", api.Invalidation{Type: api.InvalidatePublicKey, Fingerprint: "SHA256:unknown"},
+			[]string{"SHA256:per", "SHA256:bob"},
+			[]accessKey{{"per", "per/website"}, {"per", "bob/tools"}, {"bob", "per/website"}}},
+		{"repository", api.Invalidation{Type: api.InvalidateRepository, Repository: "per/website"},
+			[]string{"SHA256:per", "SHA256:bob", "SHA256:unknown"},
+			[]accessKey{{"per", "bob/tools"}}},
+		{"all", api.Invalidation{Type: api.InvalidateAll}, nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFilledCache()
+			c.invalidate(test.invalidation)
+			if len(c.users) != len(test.users) {
+				t.Errorf("expected %d cached users but was %d", len(test.users), len(c.users))
+			}
+			for _, fingerprint := range test.users {
+				if _, ok := c.getUser(fingerprint); !ok {
+					t.Errorf("expected %s to still be cached", fingerprint)
+				}
+			}
+			if len(c.access) != len(test.access) {
+				t.Errorf("expected %d cached access levels but was %d", len(test.access), len(c.access))
+			}
+			for _, key := range test.access {
+				if _, ok := c.getAccess(key.user, key.repository); !ok {
+					t.Errorf("expected the access of %s to %s to still be cached", key.user, key.repository)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheInvalidateAll(t *testing.T) {
+	c := newFilledCache()
+	c.invalidateAll()
+	if len(c.users) != 0 || len(c.access) != 0 {
+		t.Errorf("expected the cache to be empty but was %d users and %d access levels", len(c.users), len(c.access))
+	}
+}
+
+func TestCachePutAfterInvalidation(t *testing.T) {
+	c := newCache(time.Minute, time.Minute)
+	generation := c.getGeneration()
+	c.invalidate(api.Invalidation{Type: api.InvalidateUser, User: "per"})
+
+	// A value fetched before the invalidation must not end up in the cache
+	c.putUser(generation, "SHA256:per", &api.User{Name: "per"})
+	c.putAccess(generation, "per", "per/website", api.AccessWrite)
+	if _, ok := c.getUser("SHA256:per"); ok {
+		t.Error("expected the user to not be cached")
+	}
+	if _, ok := c.getAccess("per", "per/website"); ok {
+		t.Error("expected the access level to not be cached")
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	c := newCache(time.Minute, -time.Second)
+	generation := c.getGeneration()
+	c.putUser(generation, "SHA256:per", &api.User{Name: "per"})
+	c.putUser(generation, "SHA256:unknown", nil)
+	c.putAccess(generation, "bob", "per/website", api.AccessNone)
+
+	if _, ok := c.getUser("SHA256:per"); !ok {
+		t.Error("expected the user to be cached")
+	}
+	if _, ok := c.getUser("SHA256:unknown"); ok {
+		t.Error("expected the missing user to have expired")
+	}
+	if _, ok := c.getAccess("bob", "per/website"); ok {
+		t.Error("expected the missing access level to have expired")
+	}
+}
@@ -1,44 +1,282 @@
 package apiserver
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/westcoastcode-se/gitgo/api"
-	"html"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
-// Client is a type which is used when calling the API server
+// InternalPath is the uri of the api server's internal api, which is only available to trusted services
+const InternalPath = "/internal/v1"
+
+// DefaultMaxRetries is how many times a failed request is retried if the failure is temporary
+const DefaultMaxRetries = 3
+
+// DefaultRetryDelay is how long the client waits before the first retry. The delay is doubled for each retry
+const DefaultRetryDelay = 100 * time.Millisecond
+
+// Client is a type which is used when calling the API server.
+//
+// All requests fail closed: if the api server can't be reached, even after retrying, then the error is returned
+// to the caller and nothing is allowed. The git server rejects logins and git commands until the api server
+// is available again
 type Client struct {
-	Address    string
+	Address string
+
+	// certPath, keyPath and caPath are the files the http client is created from. The http client is created
+	// again when the files are reloaded
+	certPath           string
+	keyPath            string
+	caPath             string
+	insecureSkipVerify bool
+
+	mutex      sync.RWMutex
 	httpClient *http.Client
+
+	// MaxRetries is how many times a request is retried if it fails because of a temporary problem, such as
+	// the api server being unavailable
+	MaxRetries int
+
+	// RetryDelay is how long to wait before the first retry. The delay is doubled for each retry
+	RetryDelay time.Duration
+
+	// cache is nil if caching is not enabled
+	cache *cache
+
+	// inSync is 1 if the client is receiving invalidations from the api server, otherwise 0
+	inSync int32
+}
+
+// RequestUUIDProvider is implemented by contexts that has a unique identifier for the current request. The
+// identifier is sent to the api server so that requests can be traced across servers
+type RequestUUIDProvider interface {
+	GetRequestUUID() string
+}
+
+// FindUserUsingPublicKey fetches a user that has the supplied public key registered. An error matching
+// NotFoundError is returned if no user has the key
+func (c *Client) FindUserUsingPublicKey(ctx context.Context, fingerprint string) (*api.User, error) {
+	cache := c.activeCache()
+	var generation uint64
+	if cache != nil {
+		if user, ok := cache.getUser(fingerprint); ok {
+			if user == nil {
+				return nil, &Error{StatusCode: http.StatusNotFound, Response: api.Error{Code: api.ErrorCodeNotFound}}
+			}
+			return user, nil
+		}
+		generation = cache.getGeneration()
+	}
+
+	user := &api.User{}
+	if err := c.get(ctx, InternalPath+"/keys/"+url.PathEscape(fingerprint), user); err != nil {
+		if cache != nil && errors.Is(err, NotFoundError) {
+			cache.putUser(generation, fingerprint, nil)
+		}
+		return nil, err
+	}
+	if cache != nil {
+		cache.putUser(generation, fingerprint, user)
+	}
+	return user, nil
 }
 
-// FindUserUsingPublicKey fetches a user that has the supplied public key registered
-func (c *Client) FindUserUsingPublicKey(fingerprint string) (*api.User, error) {
-	// Get user that has the supplied user fingerprint
-	resp, err := c.httpClient.Get(c.Address + "/api/v1/users?fingerprint=" + html.EscapeString(fingerprint))
+// GetRepositoryAccess fetches the access level the supplied user has to a specific repository
+func (c *Client) GetRepositoryAccess(ctx context.Context, user string, repository string) (api.Access, error) {
+	cache := c.activeCache()
+	var generation uint64
+	if cache != nil {
+		if access, ok := cache.getAccess(user, repository); ok {
+			return access, nil
+		}
+		generation = cache.getGeneration()
+	}
+
+	access := &api.RepositoryAccess{}
+	err := c.get(ctx, InternalPath+"/repositories/"+escapeRepository(repository)+"/access?user="+
+		url.QueryEscape(user), access)
 	if err != nil {
+		if errors.Is(err, NotFoundError) {
+			if cache != nil {
+				cache.putAccess(generation, user, repository, api.AccessNone)
+			}
+			return api.AccessNone, nil
+		}
+		return api.AccessNone, err
+	}
+	if cache != nil {
+		cache.putAccess(generation, user, repository, access.Access)
+	}
+	return access.Access, nil
+}
+
+// GetRepositoryProtection fetches the rules applied to every push to the supplied repository. Protection is
+// never cached, so that changes are applied to the next push
+func (c *Client) GetRepositoryProtection(ctx context.Context, repository string) (*api.RepositoryProtection, error) {
+	result := &api.RepositoryProtection{}
+	if err := c.get(ctx, InternalPath+"/repositories/"+escapeRepository(repository)+"/protection", result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReportEvent tells the api server that something happened to a repository. The event might be reported more
+// than once if the api server can't be reached
+func (c *Client) ReportEvent(ctx context.Context, event *api.GitEvent) error {
+	return c.post(ctx, InternalPath+"/events", event, nil)
+}
+
+// VerifyToken verifies that the supplied personal access token belongs to the supplied user. An error matching
+// NotFoundError is returned if the token is not valid. Tokens are never cached
+func (c *Client) VerifyToken(ctx context.Context, user string, secret string) (*api.VerifiedToken, error) {
+	result := &api.VerifiedToken{}
+	body := &api.TokenVerification{User: user, Secret: secret}
+	if err := c.post(ctx, InternalPath+"/tokens/verify", body, result); err != nil {
 		return nil, err
 	}
+	return result, nil
+}
+
+// get sends a GET request to the api server and parses the json response into the supplied value. Temporary
+// failures are retried with an exponential backoff
+func (c *Client) get(ctx context.Context, uri string, result interface{}) error {
+	return c.send(ctx, http.MethodGet, uri, nil, result)
+}
+
+// post sends the supplied body as json to the api server and parses the json response into the supplied value.
+// Only requests that are safe to send more than once are allowed to use this, since temporary failures
+// are retried
+func (c *Client) post(ctx context.Context, uri string, body interface{}, result interface{}) error {
+	return c.send(ctx, http.MethodPost, uri, body, result)
+}
+
+func (c *Client) send(ctx context.Context, method string, uri string, body interface{}, result interface{}) error {
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := c.doSend(ctx, method, uri, body, result)
+		if err == nil || !IsTransient(err) || attempt >= c.MaxRetries {
+			return err
+		}
+		log.Printf("WARN: request to api server failed, retrying in %v: %v\n", delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &TransportError{Err: ctx.Err()}
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) doSend(ctx context.Context, method string, uri string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.Address+uri, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if provider, ok := ctx.(RequestUUIDProvider); ok {
+		req.Header.Set("X-Request-Id", provider.GetRequestUUID())
+	}
+
+	c.mutex.RLock()
+	httpClient := c.httpClient
+	c.mutex.RUnlock()
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return &TransportError{Err: err}
+	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return decodeError(resp)
+	}
+	if result == nil {
+		return nil
+	}
+
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return &TransportError{Err: err}
+	}
+	if err = json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("could not parse response from api server: %v", err)
 	}
+	return nil
+}
 
-	user := &api.User{}
-	err = json.Unmarshal(data, user)
-	return user, err
+// escapeRepository escapes each part of a repository name, so that the '/' between the owner and the name is kept
+func escapeRepository(repository string) string {
+	parts := strings.Split(repository, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Reload reads the client-side certificate and the certificate authority again, so that a renewed certificate
+// is used without restarting the git server. Requests in progress are completed using the old certificate
+func (c *Client) Reload() error {
+	httpClient, err := newHTTPClient(c.certPath, c.keyPath, c.caPath, c.insecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	old := c.httpClient
+	c.httpClient = httpClient
+	c.mutex.Unlock()
+	old.CloseIdleConnections()
+	return nil
 }
 
 // NewClient creates a new https TLS client used when communicating with the API server
 func NewClient(address string, certPath string, keyPath string, caPath string,
 	insecureSkipVerify bool) (*Client, error) {
+	httpClient, err := newHTTPClient(certPath, keyPath, caPath, insecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		Address:            address,
+		MaxRetries:         DefaultMaxRetries,
+		RetryDelay:         DefaultRetryDelay,
+		certPath:           certPath,
+		keyPath:            keyPath,
+		caPath:             caPath,
+		insecureSkipVerify: insecureSkipVerify,
+		httpClient:         httpClient,
+	}
+	return client, nil
+}
+
+// newHTTPClient creates a http client that authenticates using the supplied client-side certificate
+func newHTTPClient(certPath string, keyPath string, caPath string, insecureSkipVerify bool) (*http.Client, error) {
 	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
 	if err != nil {
 		return nil, err
@@ -72,12 +310,8 @@ func NewClient(address string, certPath string, keyPath string, caPath string,
 		MaxIdleConns:    20,
 		IdleConnTimeout: 5 * time.Minute,
 	}
-	client := &Client{
-		Address: address,
-		httpClient: &http.Client{
-			Transport: transport,
-			Timeout:   5000 * time.Millisecond,
-		},
-	}
-	return client, nil
+	return &http.Client{
+		Transport: transport,
+		Timeout:   5000 * time.Millisecond,
+	}, nil
 }
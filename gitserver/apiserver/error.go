@@ -0,0 +1,105 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"io/ioutil"
+	"net/http"
+)
+
+var (
+	// NotFoundError is matched by errors returned when the api server doesn't know about the requested resource
+	NotFoundError = errors.New("not found by the api server")
+
+	// UnauthorizedError is matched by errors returned when the api server doesn't accept the git server's credentials
+	UnauthorizedError = errors.New("not authorized by the api server")
+
+	// ServerError is matched by errors returned when the api server failed to process a request
+	ServerError = errors.New("api server failed to process the request")
+)
+
+// Error is returned when the api server responds with an error. Use errors.Is with NotFoundError,
+// UnauthorizedError or ServerError to figure out what kind of error it is
+type Error struct {
+	// Response is the error sent by the api server
+	Response api.Error
+
+	// StatusCode is the http status code sent by the api server
+	StatusCode int
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("api server responded with %d %s", e.StatusCode, e.Response.Reason)
+	if len(e.Response.Code) > 0 {
+		message += " (" + e.Response.Code + ")"
+	}
+	if len(e.Response.Message) > 0 {
+		message += ": " + e.Response.Message
+	}
+	for _, field := range e.Response.Fields {
+		message += fmt.Sprintf(", %s: %s", field.Field, field.Message)
+	}
+	if len(e.Response.RequestUUID) > 0 {
+		message += " [request " + e.Response.RequestUUID + "]"
+	}
+	return message
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case NotFoundError:
+		return e.StatusCode == http.StatusNotFound
+	case UnauthorizedError:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ServerError:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// TransportError is returned when the git server can't communicate with the api server at all, for example
+// if the api server is down
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("could not communicate with the api server: %v", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// IsTransient checks if the supplied error is temporary, which means that the request can be retried
+func IsTransient(err error) bool {
+	var transportError *TransportError
+	if errors.As(err, &transportError) {
+		return true
+	}
+	var apiError *Error
+	if errors.As(err, &apiError) {
+		switch apiError.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+// decodeError reads the error sent by the api server. The status text is used as the reason if the
+// response body is not a valid error
+func decodeError(resp *http.Response) *Error {
+	result := &Error{StatusCode: resp.StatusCode}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil || json.Unmarshal(data, &result.Response) != nil {
+		result.Response = api.Error{}
+	}
+	if len(result.Response.Reason) == 0 {
+		result.Response.Reason = http.StatusText(resp.StatusCode)
+	}
+	return result
+}
@@ -0,0 +1,90 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// invalidationWait is how long the api server is asked to wait for invalidations. It must be shorter than both
+// the http client's timeout and the api server's write timeout
+const invalidationWait = 3 * time.Second
+
+// minInvalidationRetryDelay and maxInvalidationRetryDelay are the shortest and longest times to wait before
+// reconnecting to the api server
+const (
+	minInvalidationRetryDelay = 100 * time.Millisecond
+	maxInvalidationRetryDelay = 30 * time.Second
+)
+
+// EnableCache makes the client cache users and access levels. The cache is only used while WatchInvalidations
+// is in sync with the api server, so that revoked keys and permissions stop working within seconds
+func (c *Client) EnableCache(ttl time.Duration, negativeTTL time.Duration) {
+	c.cache = newCache(ttl, negativeTTL)
+}
+
+// WatchInvalidations waits for invalidations from the api server and removes changed data from the cache. It
+// blocks until the supplied context is done. If the api server can't be reached, then the cache is emptied
+// and not used until the client is in sync again
+func (c *Client) WatchInvalidations(ctx context.Context) {
+	if c.cache == nil {
+		return
+	}
+
+	var epoch string
+	var since uint64
+	delay := retryDelay(c.RetryDelay)
+	for ctx.Err() == nil {
+		var result api.Invalidations
+		uri := fmt.Sprintf(InternalPath+"/invalidations?epoch=%s&since=%d&wait=%d", url.QueryEscape(epoch), since,
+			int(invalidationWait.Seconds()))
+		if err := c.doSend(ctx, http.MethodGet, uri, nil, &result); err != nil {
+			if atomic.SwapInt32(&c.inSync, 0) == 1 {
+				log.Printf("WARN: lost contact with the api server, the cache is disabled: %v\n", err)
+			}
+			c.cache.invalidateAll()
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+			if delay *= 2; delay > maxInvalidationRetryDelay {
+				delay = maxInvalidationRetryDelay
+			}
+			continue
+		}
+		delay = retryDelay(c.RetryDelay)
+
+		for _, invalidation := range result.Invalidations {
+			c.cache.invalidate(invalidation)
+		}
+		epoch, since = result.Epoch, result.Sequence
+		if atomic.SwapInt32(&c.inSync, 1) == 0 {
+			log.Println("INFO: in sync with the api server, the cache is enabled")
+		}
+	}
+}
+
+// retryDelay returns the time to wait before the first reconnect, which is never shorter than
+// minInvalidationRetryDelay so that an unreachable api server isn't called in a tight loop
+func retryDelay(delay time.Duration) time.Duration {
+	if delay < minInvalidationRetryDelay {
+		return minInvalidationRetryDelay
+	}
+	return delay
+}
+
+// activeCache returns the cache if it's enabled and in sync with the api server, otherwise nil
+func (c *Client) activeCache() *cache {
+	if c.cache == nil || atomic.LoadInt32(&c.inSync) == 0 {
+		return nil
+	}
+	return c.cache
+}
@@ -0,0 +1,26 @@
+package apiserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		expected time.Duration
+	}{
+		{"not configured", 0, minInvalidationRetryDelay},
+		{"negative", -time.Second, minInvalidationRetryDelay},
+		{"too short", time.Millisecond, minInvalidationRetryDelay},
+		{"configured", 2 * time.Second, 2 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := retryDelay(test.delay); actual != test.expected {
+				t.Errorf("expected %v but was %v", test.expected, actual)
+			}
+		})
+	}
+}
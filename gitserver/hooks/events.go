@@ -0,0 +1,33 @@
+package hooks
+
+import (
+	"context"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/gitserver/apiserver"
+	"log"
+)
+
+// EventHook reports accepted pushes to the api server
+type EventHook struct {
+	// Client is used when reporting the push
+	Client *apiserver.Client
+}
+
+func (h *EventHook) OnHook(ctx context.Context, stage Stage, push *Push) error {
+	if stage != PostReceive {
+		return nil
+	}
+
+	event := &api.GitEvent{
+		Type:       api.GitEventPush,
+		Repository: push.Repository,
+		User:       push.User.Name,
+		Protocol:   push.Protocol,
+		RefUpdates: push.RefUpdates,
+	}
+	// The push is already accepted, so the user is not told if the event can't be reported
+	if err := h.Client.ReportEvent(ctx, event); err != nil {
+		log.Printf("WARN: could not report push to %s by %s: %v\n", push.Repository, push.User.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package hooks
+
+import (
+	"context"
+	"github.com/westcoastcode-se/gitgo/api"
+	"log"
+)
+
+// Stage is the point in a push where a hook is executed
+type Stage string
+
+const (
+	// PreReceive is executed once before any reference is updated. Rejecting it rejects the entire push
+	PreReceive Stage = "pre-receive"
+
+	// Update is executed once for each reference before it's updated. Rejecting it only rejects that reference
+	Update Stage = "update"
+
+	// PostReceive is executed once after all references are updated. It can't reject anything
+	PostReceive Stage = "post-receive"
+)
+
+// Push is a push that's received by the git server
+type Push struct {
+	// User is the user that pushes
+	User *api.User
+
+	// Repository is the name of the repository that's pushed to
+	Repository string
+
+	// Protocol is the protocol used by the client, "ssh" or "http"
+	Protocol string
+
+	// RefUpdates contains the references that are changed. Only the reference being updated is part of
+	// the push during the Update stage
+	RefUpdates []api.RefUpdate
+
+	// Environment must be used when executing git commands on the repository, so that objects that are
+	// received by the push, but not yet accepted, are found
+	Environment []string
+}
+
+// Hook is implemented by everything that wants to know about, or validate, pushes
+type Hook interface {
+	// OnHook is called for each stage of a push. Returning an error during the PreReceive or Update stage
+	// rejects the push, and the error message is shown on the client's stderr
+	OnHook(ctx context.Context, stage Stage, push *Push) error
+}
+
+// Pipeline executes hooks in the order they are added
+type Pipeline struct {
+	hooks []Hook
+}
+
+// Add a hook to the end of the pipeline. Hooks must be added before the git server accepts clients
+func (p *Pipeline) Add(hook Hook) {
+	p.hooks = append(p.hooks, hook)
+}
+
+// Execute all hooks for the supplied stage. The first error stops the pipeline, except for the PostReceive
+// stage where all hooks are executed since the push can't be rejected anymore
+func (p *Pipeline) Execute(ctx context.Context, stage Stage, push *Push) error {
+	var result error
+	for _, hook := range p.hooks {
+		if err := hook.OnHook(ctx, stage, push); err != nil {
+			if stage != PostReceive {
+				return err
+			}
+			log.Printf("WARN: %s hook failed for %s: %v\n", stage, push.Repository, err)
+			if result == nil {
+				result = err
+			}
+		}
+	}
+	return result
+}
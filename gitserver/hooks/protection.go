@@ -0,0 +1,149 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/gitserver/apiserver"
+	"log"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	branchPrefix = "refs/heads/"
+	tagPrefix    = "refs/tags/"
+)
+
+// ProtectionHook enforces the branch and tag protection configured for a repository in the api server. Each
+// reference is verified during the Update stage, so that only the references breaking the rules are rejected
+type ProtectionHook struct {
+	// Client is used when fetching the protection rules
+	Client *apiserver.Client
+
+	// GitBinDir points to where git binaries are located
+	GitBinDir string
+
+	// RepositoryPath points to where repositories are located
+	RepositoryPath string
+}
+
+func (h *ProtectionHook) OnHook(ctx context.Context, stage Stage, push *Push) error {
+	if stage != Update {
+		return nil
+	}
+
+	protection, err := h.Client.GetRepositoryProtection(ctx, push.Repository)
+	if err != nil {
+		if errors.Is(err, apiserver.NotFoundError) {
+			return nil
+		}
+		log.Printf("WARN: could not get protection for %s: %v\n", push.Repository, err)
+		return errors.New("could not verify branch protection, please try again later")
+	}
+
+	for _, update := range push.RefUpdates {
+		if err = h.verify(protection, push, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify that the supplied reference update follows the repository's protection rules
+func (h *ProtectionHook) verify(protection *api.RepositoryProtection, push *Push, update api.RefUpdate) error {
+	if strings.HasPrefix(update.Ref, tagPrefix) {
+		tag := strings.TrimPrefix(update.Ref, tagPrefix)
+		if protection.ImmutableTags && !isZeroObject(update.OldObject) {
+			return fmt.Errorf("tag '%s' can't be moved or deleted because tags are immutable in '%s'", tag,
+				push.Repository)
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(update.Ref, branchPrefix) {
+		return nil
+	}
+	branch := strings.TrimPrefix(update.Ref, branchPrefix)
+	for _, rule := range protection.Branches {
+		if matched, _ := path.Match(rule.Pattern, branch); !matched {
+			continue
+		}
+
+		if !isAllowed(rule, push) {
+			return fmt.Errorf("you are not allowed to push to the protected branch '%s'", branch)
+		}
+		if isZeroObject(update.NewObject) {
+			if !rule.AllowDeletions {
+				return fmt.Errorf("branch '%s' is protected and can't be deleted", branch)
+			}
+			continue
+		}
+		if !rule.AllowForcePushes && !isZeroObject(update.OldObject) {
+			fastForward, err := h.isFastForward(push, update)
+			if err != nil {
+				log.Printf("WARN: could not compare %s with %s in %s: %v\n", update.OldObject, update.NewObject,
+					push.Repository, err)
+				return fmt.Errorf("could not verify the push to the protected branch '%s'", branch)
+			}
+			if !fastForward {
+				return fmt.Errorf("branch '%s' is protected and can't be force-pushed", branch)
+			}
+		}
+	}
+	return nil
+}
+
+// isAllowed checks if the user pushing is allowed to push to a branch matching the supplied rule. Everyone is
+// allowed if the rule doesn't restrict who's allowed to push
+func isAllowed(rule api.BranchProtection, push *Push) bool {
+	if len(rule.Users) == 0 && len(rule.Teams) == 0 {
+		return true
+	}
+	if contains(rule.Users, push.User.Name) {
+		return true
+	}
+
+	// Teams are named by the organization owning the repository
+	owner := strings.SplitN(push.Repository, "/", 2)[0]
+	for _, team := range rule.Teams {
+		if contains(push.User.Teams, owner+"/"+team) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFastForward checks if the old object is an ancestor of the new object
+func (h *ProtectionHook) isFastForward(push *Push, update api.RefUpdate) (bool, error) {
+	cmd := exec.Command(filepath.Join(h.GitBinDir, "git"), "merge-base", "--is-ancestor", update.OldObject,
+		update.NewObject)
+	cmd.Dir = filepath.Join(h.RepositoryPath, push.Repository)
+	cmd.Env = push.Environment
+	err := cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) && exitError.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, err
+}
+
+// isZeroObject checks if the supplied object name means that the reference doesn't exist
+func isZeroObject(object string) bool {
+	return strings.Trim(object, "0") == ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package hooks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Run is executed by the hook scripts installed by the server. It sends the reference updates to the git server
+// that executed receive-pack and returns the exit code that the hook should exit with
+func Run(stage Stage, args []string, stdin io.Reader, stderr io.Writer) int {
+	address, token := os.Getenv(AddressEnv), os.Getenv(TokenEnv)
+	if len(address) == 0 || len(token) == 0 {
+		_, _ = fmt.Fprintln(stderr, "ERROR: hook must be executed by the git server")
+		return 1
+	}
+
+	request := &hookRequest{Token: token, RefUpdates: []api.RefUpdate{}}
+	for _, key := range environment {
+		if value, ok := os.LookupEnv(key); ok {
+			request.Environment = append(request.Environment, key+"="+value)
+		}
+	}
+	switch stage {
+	case Update:
+		if len(args) != 3 {
+			_, _ = fmt.Fprintln(stderr, "ERROR: update hook expects a reference, an old and a new object")
+			return 1
+		}
+		request.RefUpdates = append(request.RefUpdates, api.RefUpdate{Ref: args[0], OldObject: args[1],
+			NewObject: args[2]})
+	case PreReceive, PostReceive:
+		// Each line contains "<old-value> SP <new-value> SP <ref-name>"
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			parts := strings.Fields(scanner.Text())
+			if len(parts) != 3 {
+				continue
+			}
+			request.RefUpdates = append(request.RefUpdates, api.RefUpdate{Ref: parts[2], OldObject: parts[0],
+				NewObject: parts[1]})
+		}
+	default:
+		_, _ = fmt.Fprintf(stderr, "ERROR: unknown hook %s\n", stage)
+		return 1
+	}
+
+	body, _ := json.Marshal(request)
+	resp, err := http.Post("http://"+address+"/"+string(stage), "application/json", bytes.NewReader(body))
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "ERROR: could not reach the git server: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	var response hookResponse
+	data, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		err = json.Unmarshal(data, &response)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "ERROR: invalid response from the git server: %v\n", err)
+		return 1
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = fmt.Fprintf(stderr, "ERROR: %s\n", response.Message)
+		return 1
+	}
+	if len(response.Message) > 0 {
+		_, _ = fmt.Fprintln(stderr, response.Message)
+	}
+	return 0
+}
@@ -0,0 +1,228 @@
+package hooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// AddressEnv is the environment variable containing the address to the hook server
+	AddressEnv = "GITGO_HOOK_ADDRESS"
+
+	// TokenEnv is the environment variable containing the token that identifies the push
+	TokenEnv = "GITGO_HOOK_TOKEN"
+)
+
+// environment contains the environment variables, set by git when executing a hook, that are forwarded to the
+// hooks in the pipeline
+var environment = []string{"GIT_OBJECT_DIRECTORY", "GIT_ALTERNATE_OBJECT_DIRECTORIES", "GIT_QUARANTINE_PATH"}
+
+// Stages contains all stages that hook scripts are installed for
+var Stages = []Stage{PreReceive, Update, PostReceive}
+
+// hookRequest is sent by a hook script to the hook server
+type hookRequest struct {
+	Token       string
+	RefUpdates  []api.RefUpdate
+	Environment []string
+}
+
+// hookResponse is sent back to the hook script. The message is shown on the client's stderr
+type hookResponse struct {
+	Message string
+}
+
+// session is a receive-pack command that's waiting for hooks to be executed
+type session struct {
+	ctx        context.Context
+	user       *api.User
+	repository string
+	protocol   string
+}
+
+// Server receives calls from the hook scripts that git executes while receiving a push, and executes the hooks
+// in the pipeline. It only listens on the loopback interface and every push is identified by a random token,
+// so that the scripts can't be used from the outside
+type Server struct {
+	// Pipeline is executed for each hook
+	Pipeline *Pipeline
+
+	// HooksPath is the directory where the hook scripts are installed
+	HooksPath string
+
+	listener net.Listener
+	server   *http.Server
+
+	mutex    sync.Mutex
+	sessions map[string]*session
+}
+
+// Begin registers a new push made by the supplied user. The returned environment variables must be added to the
+// receive-pack command, and the returned function must be called when the command is complete
+func (s *Server) Begin(ctx context.Context, user *api.User, repository string, protocol string) ([]string, func(),
+	error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, nil, fmt.Errorf("could not generate hook token: %v", err)
+	}
+	token := hex.EncodeToString(bytes)
+
+	s.mutex.Lock()
+	s.sessions[token] = &session{ctx: ctx, user: user, repository: repository, protocol: protocol}
+	s.mutex.Unlock()
+
+	env := []string{
+		AddressEnv + "=" + s.listener.Addr().String(),
+		TokenEnv + "=" + token,
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=core.hooksPath",
+		"GIT_CONFIG_VALUE_0=" + s.HooksPath,
+	}
+	end := func() {
+		s.mutex.Lock()
+		delete(s.sessions, token)
+		s.mutex.Unlock()
+	}
+	return env, end, nil
+}
+
+// Serve calls from the hook scripts. It blocks until the server is closed
+func (s *Server) Serve() error {
+	err := s.server.Serve(s.listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	stage := Stage(strings.TrimPrefix(r.URL.Path, "/"))
+	if r.Method != http.MethodPost || !isStage(stage) {
+		writeResponse(rw, http.StatusNotFound, "unknown hook")
+		return
+	}
+
+	var request hookRequest
+	data, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(data, &request)
+	}
+	if err != nil {
+		writeResponse(rw, http.StatusBadRequest, "invalid hook request")
+		return
+	}
+
+	s.mutex.Lock()
+	current, ok := s.sessions[request.Token]
+	s.mutex.Unlock()
+	if !ok {
+		writeResponse(rw, http.StatusForbidden, "push is not known by the git server")
+		return
+	}
+
+	push := &Push{
+		User:       current.user,
+		Repository: current.repository,
+		Protocol:   current.protocol,
+		RefUpdates: request.RefUpdates,
+	}
+	for _, value := range request.Environment {
+		if isForwarded(value) {
+			push.Environment = append(push.Environment, value)
+		}
+	}
+	if err = s.Pipeline.Execute(current.ctx, stage, push); err != nil {
+		log.Printf("INFO: %s hook rejected push from %s to %s: %v\n", stage, current.user.Name,
+			current.repository, err)
+		writeResponse(rw, http.StatusForbidden, err.Error())
+		return
+	}
+	writeResponse(rw, http.StatusOK, "")
+}
+
+func writeResponse(rw http.ResponseWriter, statusCode int, message string) {
+	bytes, _ := json.Marshal(&hookResponse{Message: message})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
+	_, _ = rw.Write(bytes)
+}
+
+// isForwarded checks if the supplied "KEY=VALUE" environment variable is allowed to be forwarded to the hooks
+func isForwarded(value string) bool {
+	for _, key := range environment {
+		if strings.HasPrefix(value, key+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func isStage(stage Stage) bool {
+	for _, s := range Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// install writes a hook script for each stage. The scripts executes the supplied executable, which is expected
+// to call Run
+func install(hooksPath string, executable string) error {
+	if err := os.MkdirAll(hooksPath, 0755); err != nil {
+		return err
+	}
+	for _, stage := range Stages {
+		script := fmt.Sprintf("#!/bin/sh\nexec %s hook %s \"$@\"\n", quote(filepath.ToSlash(executable)), stage)
+		if err := ioutil.WriteFile(filepath.Join(hooksPath, string(stage)), []byte(script), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quote the supplied string, so that it's a single word when it's used in a shell script
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// NewServer installs the hook scripts into the supplied directory and starts listening for calls from them
+func NewServer(hooksPath string, pipeline *Pipeline) (*Server, error) {
+	hooksPath, err := filepath.Abs(hooksPath)
+	if err != nil {
+		return nil, err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("could not find the git server executable: %v", err)
+	}
+	if err = install(hooksPath, executable); err != nil {
+		return nil, fmt.Errorf("could not install hooks into %s: %v", hooksPath, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("could not listen for hooks: %v", err)
+	}
+
+	s := &Server{
+		Pipeline:  pipeline,
+		HooksPath: hooksPath,
+		listener:  listener,
+		sessions:  map[string]*session{},
+	}
+	s.server = &http.Server{Handler: s}
+	return s, nil
+}
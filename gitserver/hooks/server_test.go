@@ -0,0 +1,36 @@
+package hooks
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{"plain path", "/usr/bin/gitserver", `'/usr/bin/gitserver'`},
+		{"spaces", "/opt/git server/gitserver", `'/opt/git server/gitserver'`},
+		{"single quote", "/home/o'brien/gitserver", `'/home/o'\''brien/gitserver'`},
+		{"shell characters", "/tmp/$(id)/`id`", "'/tmp/$(id)/`id`'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := quote(test.value)
+			if actual != test.expected {
+				t.Fatalf("expected %s but was %s", test.expected, actual)
+			}
+
+			// The shell must see the quoted value as a single, unchanged, word
+			output, err := exec.Command("sh", "-c", "printf %s "+actual).Output()
+			if err != nil {
+				t.Skipf("could not execute sh: %v", err)
+			}
+			if string(output) != test.value {
+				t.Errorf("expected the shell to see %s but was %s", test.value, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package hooks
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MinimumGitVersion is the oldest git version that reads configuration from GIT_CONFIG_COUNT, which is used to
+// tell receive-pack where the hook scripts are installed
+var MinimumGitVersion = [2]int{2, 31}
+
+// CheckGitVersion verifies that the git binary in the supplied directory is new enough to execute the hooks.
+// Older versions ignore the hook scripts, which means that pushes would be accepted without being verified
+func CheckGitVersion(gitBinDir string) error {
+	output, err := exec.Command(filepath.Join(gitBinDir, "git"), "--version").Output()
+	if err != nil {
+		return fmt.Errorf("could not find the git version: %v", err)
+	}
+	version, err := parseGitVersion(string(output))
+	if err != nil {
+		return err
+	}
+	if version[0] < MinimumGitVersion[0] ||
+		(version[0] == MinimumGitVersion[0] && version[1] < MinimumGitVersion[1]) {
+		return fmt.Errorf("git %d.%d or later is required but %s is installed", MinimumGitVersion[0],
+			MinimumGitVersion[1], strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// parseGitVersion returns the major and minor version from the output of git --version, such as
+// "git version 2.31.1.windows.1"
+func parseGitVersion(output string) ([2]int, error) {
+	var result [2]int
+	fields := strings.Fields(output)
+	if len(fields) < 3 || fields[0] != "git" || fields[1] != "version" {
+		return result, fmt.Errorf("unknown git version %q", strings.TrimSpace(output))
+	}
+	parts := strings.Split(fields[2], ".")
+	if len(parts) < 2 {
+		return result, fmt.Errorf("unknown git version %q", fields[2])
+	}
+	for i := range result {
+		number, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return result, fmt.Errorf("unknown git version %q", fields[2])
+		}
+		result[i] = number
+	}
+	return result, nil
+}
@@ -0,0 +1,31 @@
+package hooks
+
+import "testing"
+
+func TestParseGitVersion(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected [2]int
+		valid    bool
+	}{
+		{"git version 2.39.5\n", [2]int{2, 39}, true},
+		{"git version 2.31.1.windows.1\n", [2]int{2, 31}, true},
+		{"git version 2.30.1 (Apple Git-130)\n", [2]int{2, 30}, true},
+		{"git version 3.0\n", [2]int{3, 0}, true},
+		{"git version 2\n", [2]int{}, false},
+		{"git version two.31\n", [2]int{}, false},
+		{"hub version 2.14.2\n", [2]int{}, false},
+		{"", [2]int{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.output, func(t *testing.T) {
+			actual, err := parseGitVersion(test.output)
+			if (err == nil) != test.valid {
+				t.Fatalf("expected valid to be %v but the error was %v", test.valid, err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected %v but was %v", test.expected, actual)
+			}
+		})
+	}
+}
@@ -1,11 +1,20 @@
 package main
 
 import (
+	"github.com/westcoastcode-se/gitgo/gitserver/hooks"
 	"github.com/westcoastcode-se/gitgo/gitserver/server"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	// The git server is also executed by the hook scripts that git runs when a push is received
+	if len(os.Args) > 2 && os.Args[1] == "hook" {
+		os.Exit(hooks.Run(hooks.Stage(os.Args[2]), os.Args[3:], os.Stdin, os.Stderr))
+	}
+
 	log.Println("INFO: Starting git server")
 
 	cfg := server.LoadConfig()
@@ -14,7 +23,23 @@ func main() {
 		log.Fatalf("ERROR: Could not create a new git server: %v", err)
 	}
 
+	stop := make(chan struct{})
+	go s.WatchKeys(cfg.KeyWatchInterval, stop)
+
+	// Keys and certificates are renewed without restarting the server by sending SIGHUP
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		for range signals {
+			log.Println("INFO: Reloading keys and certificates")
+			if err := s.Reload(); err != nil {
+				log.Printf("WARN: Could not reload keys: %v\n", err)
+			}
+		}
+	}()
+
 	err = s.AcceptClients()
+	close(stop)
 	if err != nil {
 		log.Fatalf("ERRR: Could not start git server. %v", err)
 	}
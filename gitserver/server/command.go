@@ -1,7 +1,11 @@
 package server
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/gitserver/hooks"
 	"regexp"
 	"strings"
 )
@@ -18,6 +22,25 @@ type Command struct {
 	OriginalCommand string
 }
 
+// RequiredAccess returns the access level a user must have to the repository to be allowed to
+// execute this command
+func (c *Command) RequiredAccess() api.Access {
+	if c.Command == "git-receive-pack" {
+		return api.AccessWrite
+	}
+	return api.AccessRead
+}
+
+// beginHooks registers the command with the hook server if it receives a push. The returned environment
+// variables must be added to the command and the returned function must be called when the command is complete
+func (c *Command) beginHooks(hookServer *hooks.Server, ctx context.Context, user *api.User,
+	protocol string) ([]string, func(), error) {
+	if c.Command != "git-receive-pack" {
+		return nil, func() {}, nil
+	}
+	return hookServer.Begin(ctx, user, c.Repository, protocol)
+}
+
 func getRepository(s string) (string, bool) {
 	idx := strings.Index(s, "'")
 	if idx == -1 {
@@ -28,20 +51,50 @@ func getRepository(s string) (string, bool) {
 	if idx == -1 {
 		return "", false
 	}
-	repository = repository[0:idx]
-	if repository[0] == '/' {
-		repository = repository[1:]
-	}
-	return repository, true
+	return strings.TrimPrefix(repository[0:idx], "/"), true
 }
 
-var validRepositoryPathRegex = regexp.MustCompile(`^[a-zA-Z\\-_0-9\\.]+$`)
+// validRepositoryPathRegex matches repository paths in the "owner/name" format. Neither part may start with a dash,
+// so that a path is never mistaken for an option by git
+var validRepositoryPathRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_\-.]*/[a-zA-Z0-9_][a-zA-Z0-9_\-.]*$`)
 
 func testRepositoryPath(s string) bool {
 	return validRepositoryPathRegex.MatchString(s) &&
 		strings.Index(s, "..") == -1
 }
 
+// isGitCommand checks if the supplied command is a git command that clients are allowed to execute
+func isGitCommand(command string) bool {
+	switch command {
+	case "git-upload-pack", "git-upload-archive", "git-receive-pack":
+		return true
+	}
+	return false
+}
+
+// NewCommand creates a command that executes the supplied git command on a repository. The same rules are
+// applied as when parsing a command sent over ssh
+func NewCommand(command string, repository string) (*Command, error) {
+	originalCommand := fmt.Sprintf("%s '%s'", command, repository)
+	if !isGitCommand(command) {
+		return &Command{
+			OriginalCommand: originalCommand,
+		}, UnsupportedCommand
+	}
+
+	if !testRepositoryPath(repository) {
+		return &Command{
+			OriginalCommand: originalCommand,
+		}, InvalidRepositoryPath
+	}
+
+	return &Command{
+		Command:         command,
+		Repository:      repository,
+		OriginalCommand: originalCommand,
+	}, nil
+}
+
 func Parse(s string) (*Command, error) {
 	parts := SplitOnSpace(s)
 	if len(parts) < 2 {
@@ -50,11 +103,7 @@ func Parse(s string) (*Command, error) {
 		}, InvalidGitCommand
 	}
 
-	switch parts[0] {
-	case "git-upload-pack":
-	case "git-upload-archive":
-	case "git-receive-pack":
-	default:
+	if !isGitCommand(parts[0]) {
 		return &Command{
 			OriginalCommand: s,
 		}, UnsupportedCommand
@@ -67,15 +116,7 @@ func Parse(s string) (*Command, error) {
 		}, InvalidGitCommand
 	}
 
-	if !testRepositoryPath(repository) {
-		return &Command{
-			OriginalCommand: s,
-		}, InvalidRepositoryPath
-	}
-
-	return &Command{
-		Command:         parts[0],
-		Repository:      repository,
-		OriginalCommand: s,
-	}, nil
+	command, err := NewCommand(parts[0], repository)
+	command.OriginalCommand = s
+	return command, err
 }
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    string
+		command    string
+		repository string
+		err        error
+	}{
+		{"upload pack", "git-upload-pack 'acme/website'", "git-upload-pack", "acme/website", nil},
+		{"receive pack", "git-receive-pack 'acme/website'", "git-receive-pack", "acme/website", nil},
+		{"upload archive", "git-upload-archive 'acme/website'", "git-upload-archive", "acme/website", nil},
+		{"leading slash", "git-upload-pack '/acme/website'", "git-upload-pack", "acme/website", nil},
+		{"dots in name", "git-upload-pack 'acme/web.site'", "git-upload-pack", "acme/web.site", nil},
+		{"no repository", "git-upload-pack", "", "", InvalidGitCommand},
+		{"unquoted repository", "git-upload-pack acme/website", "", "", InvalidGitCommand},
+		{"unterminated quote", "git-upload-pack 'acme/website", "", "", InvalidGitCommand},
+		{"empty repository", "git-upload-pack ''", "", "", InvalidRepositoryPath},
+		{"other command", "rm 'acme/website'", "", "", UnsupportedCommand},
+		{"shell command", "sh -c 'git-upload-pack acme/website'", "", "", UnsupportedCommand},
+		{"missing owner", "git-upload-pack 'website'", "", "", InvalidRepositoryPath},
+		{"nested path", "git-upload-pack 'acme/website/other'", "", "", InvalidRepositoryPath},
+		{"parent directory", "git-upload-pack 'acme/..'", "", "", InvalidRepositoryPath},
+		{"double dots in name", "git-upload-pack 'acme/web..site'", "", "", InvalidRepositoryPath},
+		{"hidden owner", "git-upload-pack '.acme/website'", "", "", InvalidRepositoryPath},
+		{"option as owner", "git-upload-pack '--help/website'", "", "", InvalidRepositoryPath},
+		{"option as name", "git-upload-pack 'acme/-website'", "", "", InvalidRepositoryPath},
+		{"dash in name", "git-upload-pack 'acme/web-site'", "git-upload-pack", "acme/web-site", nil},
+		{"absolute path", "git-upload-pack '//etc/passwd'", "", "", InvalidRepositoryPath},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command, err := Parse(test.payload)
+			if err != test.err {
+				t.Fatalf("expected %v but was %v", test.err, err)
+			}
+			if command.OriginalCommand != test.payload {
+				t.Errorf("expected original command %q but was %q", test.payload, command.OriginalCommand)
+			}
+			if command.Command != test.command || command.Repository != test.repository {
+				t.Errorf("expected %s %s but was %s %s", test.command, test.repository, command.Command,
+					command.Repository)
+			}
+		})
+	}
+}
+
+func TestCommandRequiredAccess(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected api.Access
+	}{
+		{"git-upload-pack", api.AccessRead},
+		{"git-upload-archive", api.AccessRead},
+		{"git-receive-pack", api.AccessWrite},
+	}
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			command, err := NewCommand(test.command, "acme/website")
+			if err != nil {
+				t.Fatalf("could not create command: %v", err)
+			}
+			if actual := command.RequiredAccess(); actual != test.expected {
+				t.Errorf("expected %s but was %s", test.expected, actual)
+			}
+		})
+	}
+}
@@ -21,9 +21,40 @@ type Config struct {
 	// a client
 	SSHKeyPath string
 
+	// KeyWatchInterval is how often the ssh host key and the certificates are checked for changes. They are
+	// also read again when the git server receives SIGHUP
+	KeyWatchInterval time.Duration
+
+	// HooksPath is the directory where the git server installs the hook scripts that git executes when a push
+	// is received
+	HooksPath string
+
+	// HTTPAddress is the address where git's Smart HTTP protocol is served. Disabled if empty
+	HTTPAddress string
+
+	// HTTPCertPath is the path to a PEM encoded certificate used when serving https
+	HTTPCertPath string
+
+	// HTTPKeyPath is the path to a PEM encoded private key used when serving https
+	HTTPKeyPath string
+
 	// APIServerAddress is the address to the api server
 	APIServerAddress string
 
+	// APIServerMaxRetries is how many times a request to the api server is retried if it fails because
+	// of a temporary problem
+	APIServerMaxRetries int
+
+	// APIServerRetryDelay is how long to wait before the first retry. The delay is doubled for each retry
+	APIServerRetryDelay time.Duration
+
+	// APIServerCacheTTL is how long users and access levels fetched from the api server are cached. Caching
+	// is disabled if zero
+	APIServerCacheTTL time.Duration
+
+	// APIServerNegativeCacheTTL is how long lookups that didn't find anything are cached
+	APIServerNegativeCacheTTL time.Duration
+
 	// The part to where a PEM encoded certificate file is located
 	ClientCertPath string
 
@@ -40,18 +71,27 @@ type Config struct {
 
 func LoadConfig() *Config {
 	cfg := &Config{
-		Address:            DefaultAddress,
-		ReadTimeout:        5000 * time.Millisecond,
-		WriteTimeout:       5000 * time.Millisecond,
-		IdleTimeout:        5000 * time.Millisecond,
-		GitBinDir:          "C:\\Program Files\\Git\\mingw64\\bin",
-		RepositoriesPath:   DefaultRepositoriesPath,
-		SSHKeyPath:         "data/gitserver.key",
-		APIServerAddress:   "https://localhost:9998",
-		ClientCertPath:     "data/apiserver_client.crt",
-		ClientKeyPath:      "data/apiserver_client.key",
-		ClientCAPath:       "data/ca.crt",
-		InsecureSkipVerify: true,
+		Address:                   DefaultAddress,
+		ReadTimeout:               5000 * time.Millisecond,
+		WriteTimeout:              5000 * time.Millisecond,
+		IdleTimeout:               5000 * time.Millisecond,
+		GitBinDir:                 "C:\\Program Files\\Git\\mingw64\\bin",
+		RepositoriesPath:          DefaultRepositoriesPath,
+		SSHKeyPath:                "data/gitserver.key",
+		KeyWatchInterval:          10 * time.Second,
+		HooksPath:                 "data/hooks",
+		HTTPAddress:               "",
+		HTTPCertPath:              "data/gitserver_https.crt",
+		HTTPKeyPath:               "data/gitserver_https.key",
+		APIServerAddress:          "https://localhost:9998",
+		APIServerMaxRetries:       3,
+		APIServerRetryDelay:       100 * time.Millisecond,
+		APIServerCacheTTL:         5 * time.Minute,
+		APIServerNegativeCacheTTL: 30 * time.Second,
+		ClientCertPath:            "data/apiserver_client.crt",
+		ClientKeyPath:             "data/apiserver_client.key",
+		ClientCAPath:              "data/ca.crt",
+		InsecureSkipVerify:        true,
 	}
 	return cfg
 }
@@ -17,7 +17,12 @@ var (
 )
 
 func NewContext(uuid string) (*Context, context.CancelFunc) {
-	ctx, cancel := context.WithCancel(context.Background())
+	return NewRequestContext(context.Background(), uuid)
+}
+
+// NewRequestContext creates a new context that is cancelled when the supplied parent is cancelled
+func NewRequestContext(parent context.Context, uuid string) (*Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
 	ctx = context.WithValue(ctx, ContextRequestUUID, uuid)
 
 	return &Context{
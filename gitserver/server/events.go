@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/gitserver/apiserver"
+	"log"
+)
+
+const (
+	// ProtocolSSH is reported for commands executed over ssh
+	ProtocolSSH = "ssh"
+
+	// ProtocolHTTP is reported for commands executed using the Smart HTTP protocol
+	ProtocolHTTP = "http"
+)
+
+// reportFetch tells the api server that the supplied user has fetched from a repository. It's done in the
+// background, so that the client doesn't have to wait for it
+func reportFetch(client *apiserver.Client, requestUUID string, user *api.User, command *Command, protocol string) {
+	go func() {
+		ctx, cancel := NewContext(requestUUID)
+		defer cancel()
+
+		event := &api.GitEvent{
+			Type:       api.GitEventFetch,
+			Repository: command.Repository,
+			User:       user.Name,
+			Protocol:   protocol,
+		}
+		if err := client.ReportEvent(ctx, event); err != nil {
+			log.Printf("WARN: could not report fetch from %s by %s: %v\n", command.Repository, user.Name, err)
+		}
+	}()
+}
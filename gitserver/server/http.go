@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/gitserver/apiserver"
+	"github.com/westcoastcode-se/gitgo/gitserver/hooks"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// HTTPHandler serves repositories using git's Smart HTTP protocol, for clients that can't reach the ssh port.
+// Users authenticate using HTTP Basic, with their user name and a personal access token as password
+//
+// GET  /{owner}/{repository}/info/refs?service={service}
+// POST /{owner}/{repository}/git-upload-pack
+// POST /{owner}/{repository}/git-receive-pack
+type HTTPHandler struct {
+	// apiServerClient can be used to talk to an api server
+	apiServerClient *apiserver.Client
+
+	// hookServer executes hooks when a push is received
+	hookServer *hooks.Server
+
+	// gitBinDir points to where git binaries are located
+	gitBinDir string
+
+	// RepositoryPath points to where repositories are located
+	repositoryPath string
+}
+
+func (h *HTTPHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	requestUUID, err := uuid.NewUUID()
+	if err != nil {
+		log.Printf("WARN: failed to process new http request: %v", err)
+		writeHTTPError(rw, http.StatusInternalServerError, "could not process the request")
+		return
+	}
+	ctx, cancel := NewRequestContext(r.Context(), requestUUID.String())
+	defer cancel()
+
+	log.Printf("INFO: incoming http request %s %s from %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+
+	// The repository name is the first two parts of the path, since repositories are named "owner/name"
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) != 3 {
+		writeHTTPError(rw, http.StatusNotFound, "not found")
+		return
+	}
+	repository, action := parts[0]+"/"+parts[1], parts[2]
+
+	switch {
+	case action == "info/refs" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.serveInfoRefs(ctx, rw, r, repository)
+	case (action == "git-upload-pack" || action == "git-receive-pack") && r.Method == http.MethodPost:
+		h.serveService(ctx, rw, r, repository, action)
+	default:
+		writeHTTPError(rw, http.StatusNotFound, "not found")
+	}
+}
+
+// serveInfoRefs advertises the references in a repository. This is the first request sent by a client
+func (h *HTTPHandler) serveInfoRefs(ctx *Context, rw http.ResponseWriter, r *http.Request, repository string) {
+	service := r.URL.Query().Get("service")
+	if service != "git-upload-pack" && service != "git-receive-pack" {
+		writeHTTPError(rw, http.StatusForbidden, "only the smart http protocol is supported")
+		return
+	}
+
+	command, user, ok := h.authorize(ctx, rw, r, service, repository)
+	if !ok {
+		return
+	}
+
+	cmd, stdout, stderr, err := h.startCommand(ctx, r, command, nil, nil, "--stateless-rpc", "--advertise-refs")
+	if err != nil {
+		log.Printf("WARN: could not start git command: %v", err)
+		writeHTTPError(rw, http.StatusInternalServerError, "could not process the request")
+		return
+	}
+	defer stdout.Close()
+
+	rw.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+
+	// Clients using protocol v2 don't expect the service announcement
+	if !strings.Contains(r.Header.Get("Git-Protocol"), "version=2") {
+		_, _ = io.WriteString(rw, packetLine(fmt.Sprintf("# service=%s\n", service)))
+		_, _ = io.WriteString(rw, "0000")
+	}
+	h.finishCommand(rw, cmd, stdout, stderr)
+
+	// Every clone and fetch starts by asking for the references, even if more than one request is needed to
+	// complete it
+	if command.RequiredAccess() == api.AccessRead {
+		reportFetch(h.apiServerClient, ctx.GetRequestUUID(), user, command, ProtocolHTTP)
+	}
+}
+
+// serveService executes a git command using the request body as stdin and the response as stdout
+func (h *HTTPHandler) serveService(ctx *Context, rw http.ResponseWriter, r *http.Request, repository string,
+	service string) {
+	command, user, ok := h.authorize(ctx, rw, r, service, repository)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != fmt.Sprintf("application/x-%s-request", service) {
+		writeHTTPError(rw, http.StatusUnsupportedMediaType, "unsupported content type")
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeHTTPError(rw, http.StatusBadRequest, "request body is not gzip encoded")
+			return
+		}
+		defer reader.Close()
+		body = reader
+	}
+
+	hookEnv, endHooks, err := command.beginHooks(h.hookServer, ctx, user, ProtocolHTTP)
+	if err != nil {
+		log.Printf("WARN: could not register push: %v", err)
+		writeHTTPError(rw, http.StatusInternalServerError, "could not process the push, please try again later")
+		return
+	}
+	defer endHooks()
+
+	// The git commands read the entire request before they write anything, so it's safe to let them read
+	// the request body while the response is written
+	cmd, stdout, stderr, err := h.startCommand(ctx, r, command, hookEnv, body, "--stateless-rpc")
+	if err != nil {
+		log.Printf("WARN: could not start git command: %v", err)
+		writeHTTPError(rw, http.StatusInternalServerError, "could not process the request")
+		return
+	}
+	defer stdout.Close()
+
+	rw.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	h.finishCommand(rw, cmd, stdout, stderr)
+}
+
+// authorize verifies that the request is allowed to execute the supplied service on a repository and returns
+// the user making the request. The same rules are applied as for commands sent over ssh, but the access is also
+// limited by the token's scopes. An error is written to the response if the request is not allowed
+func (h *HTTPHandler) authorize(ctx *Context, rw http.ResponseWriter, r *http.Request, service string,
+	repository string) (*Command, *api.User, bool) {
+	command, err := NewCommand(service, repository)
+	if err != nil {
+		log.Printf("WARN: ignoring %q because it's not a valid git command", command.OriginalCommand)
+		writeHTTPError(rw, http.StatusBadRequest, "invalid git command")
+		return nil, nil, false
+	}
+
+	name, secret, ok := r.BasicAuth()
+	if !ok {
+		requestCredentials(rw, "authentication required")
+		return nil, nil, false
+	}
+	token, err := h.apiServerClient.VerifyToken(ctx, name, secret)
+	if err != nil {
+		if errors.Is(err, apiserver.NotFoundError) {
+			requestCredentials(rw, "invalid user name or access token")
+			return nil, nil, false
+		}
+		log.Printf("WARN: could not verify token for %s, denying request: %v\n", name, err)
+		writeHTTPError(rw, http.StatusServiceUnavailable, "could not verify your credentials, please try again later")
+		return nil, nil, false
+	}
+
+	access, err := h.apiServerClient.GetRepositoryAccess(ctx, token.User.Name, command.Repository)
+	if err != nil {
+		log.Printf("WARN: could not get access for %s to %s: %v\n", token.User.Name, command.Repository, err)
+		writeHTTPError(rw, http.StatusServiceUnavailable,
+			"could not verify access to the repository, please try again later")
+		return nil, nil, false
+	}
+	if allowed := api.MaxRepositoryAccess(token.Scopes); !allowed.Allows(access) {
+		access = allowed
+	}
+
+	required := command.RequiredAccess()
+	if !access.Allows(required) {
+		log.Printf("WARN: user %s is missing %s access to %s\n", token.User.Name, required, command.Repository)
+		if access.Allows(api.AccessRead) {
+			writeHTTPError(rw, http.StatusForbidden, fmt.Sprintf("you are not allowed to push to '%s'",
+				command.Repository))
+		} else {
+			writeHTTPError(rw, http.StatusNotFound, fmt.Sprintf(
+				"repository '%s' does not exist or you do not have access to it", command.Repository))
+		}
+		return nil, nil, false
+	}
+
+	if !RepositoryExists(filepath.Join(h.repositoryPath, command.Repository)) {
+		writeHTTPError(rw, http.StatusNotFound, fmt.Sprintf(
+			"repository '%s' does not exist or you do not have access to it", command.Repository))
+		return nil, nil, false
+	}
+	return command, &token.User, true
+}
+
+// startCommand starts the supplied git command. The caller is responsible for calling finishCommand
+func (h *HTTPHandler) startCommand(ctx context.Context, r *http.Request, command *Command, env []string,
+	stdin io.Reader, args ...string) (*exec.Cmd, io.ReadCloser, *bytes.Buffer, error) {
+	commandFilename := filepath.Join(h.gitBinDir, command.Command)
+	cmd := exec.CommandContext(ctx, commandFilename, append(args, command.Repository)...)
+	cmd.Dir = h.repositoryPath
+	cmd.Env = []string{}
+	if protocol := r.Header.Get("Git-Protocol"); len(protocol) > 0 {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+protocol)
+	}
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = stdin
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("could not open stdout pipe: %v", err)
+	}
+	if err = cmd.Start(); err != nil {
+		_ = stdout.Close()
+		return nil, nil, nil, err
+	}
+	return cmd, stdout, stderr, nil
+}
+
+// finishCommand copies everything the command writes to stdout into the response, and then waits for the
+// command to complete
+func (h *HTTPHandler) finishCommand(rw http.ResponseWriter, cmd *exec.Cmd, stdout io.Reader, stderr *bytes.Buffer) {
+	if _, err := io.Copy(rw, stdout); err != nil {
+		log.Printf("WARN: failed to write stdout to response. %s", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		log.Printf("WARN: command failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+}
+
+// requestCredentials tells the client that the request must be authenticated
+func requestCredentials(rw http.ResponseWriter, message string) {
+	rw.Header().Set("WWW-Authenticate", `Basic realm="GitGo", charset="UTF-8"`)
+	writeHTTPError(rw, http.StatusUnauthorized, message)
+}
+
+// writeHTTPError writes an error message that git shows to the user
+func writeHTTPError(rw http.ResponseWriter, statusCode int, message string) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.WriteHeader(statusCode)
+	_, _ = fmt.Fprintf(rw, "ERROR: %s\n", message)
+}
+
+// packetLine encodes the supplied data using git's pkt-line format
+func packetLine(data string) string {
+	return fmt.Sprintf("%04x%s", len(data)+4, data)
+}
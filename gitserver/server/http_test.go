@@ -0,0 +1,148 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/westcoastcode-se/gitgo/api"
+	"github.com/westcoastcode-se/gitgo/gitserver/apiserver"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestAPIServer starts an api server that knows about the tokens "read", "write" and "none", all owned by
+// "per", and a client that talks to it. The token "broken" makes the api server fail. The user "per" has write
+// access to the repository "per/website"
+func newTestAPIServer(t *testing.T) *apiserver.Client {
+	tokens := map[string]*api.VerifiedToken{
+		"read":  {User: api.User{Name: "per"}, Scopes: []api.Scope{api.ScopeRepoRead}},
+		"write": {User: api.User{Name: "per"}, Scopes: []api.Scope{api.ScopeRepoWrite}},
+		"none":  {User: api.User{Name: "per"}, Scopes: []api.Scope{api.ScopeUserWrite}},
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case apiserver.InternalPath + "/tokens/verify":
+			var verification api.TokenVerification
+			_ = json.NewDecoder(r.Body).Decode(&verification)
+			if verification.Secret == "broken" {
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			token, ok := tokens[verification.Secret]
+			if !ok || verification.User != token.User.Name {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(rw).Encode(token)
+		case apiserver.InternalPath + "/repositories/per/website/access":
+			access := api.AccessNone
+			if r.URL.Query().Get("user") == "per" {
+				access = api.AccessWrite
+			}
+			_ = json.NewEncoder(rw).Encode(&api.RepositoryAccess{Access: access})
+		case apiserver.InternalPath + "/events":
+			rw.WriteHeader(http.StatusNoContent)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	// The client trusts, and authenticates using, the certificate of the test server
+	dir := tempDir(t)
+	key, err := x509.MarshalPKCS8PrivateKey(srv.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	writePEM(t, certPath, "CERTIFICATE", srv.Certificate().Raw)
+	writePEM(t, keyPath, "PRIVATE KEY", key)
+
+	client, err := apiserver.NewClient(srv.URL, certPath, keyPath, certPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.MaxRetries = 0
+	return client
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+func writePEM(t *testing.T, filename string, blockType string, data []byte) {
+	err := ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPHandlerAuthorize(t *testing.T) {
+	gitExecPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Skipf("could not find git: %v", err)
+	}
+	repositoryPath := tempDir(t)
+	err = exec.Command("git", "init", "--bare", "-q", filepath.Join(repositoryPath, "per", "website")).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &HTTPHandler{
+		apiServerClient: newTestAPIServer(t),
+		gitBinDir:       strings.TrimSpace(string(gitExecPath)),
+		repositoryPath:  repositoryPath,
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		user       string
+		token      string
+		statusCode int
+	}{
+		{"clone", "/per/website/info/refs?service=git-upload-pack", "per", "read", http.StatusOK},
+		{"push", "/per/website/info/refs?service=git-receive-pack", "per", "write", http.StatusOK},
+		{"not logged in", "/per/website/info/refs?service=git-upload-pack", "", "", http.StatusUnauthorized},
+		{"invalid token", "/per/website/info/refs?service=git-upload-pack", "per", "wrong", http.StatusUnauthorized},
+		{"token of another user", "/per/website/info/refs?service=git-upload-pack", "bob", "read",
+			http.StatusUnauthorized},
+		{"api server failure", "/per/website/info/refs?service=git-upload-pack", "per", "broken",
+			http.StatusServiceUnavailable},
+		{"push with read scope", "/per/website/info/refs?service=git-receive-pack", "per", "read",
+			http.StatusForbidden},
+		{"clone without repository scope", "/per/website/info/refs?service=git-upload-pack", "per", "none",
+			http.StatusNotFound},
+		{"clone without access", "/bob/tools/info/refs?service=git-upload-pack", "per", "read", http.StatusNotFound},
+		{"dumb protocol", "/per/website/info/refs", "per", "read", http.StatusForbidden},
+		{"invalid repository", "/-per/website/info/refs?service=git-upload-pack", "per", "read",
+			http.StatusBadRequest},
+		{"unknown path", "/per/website/objects/info/packs", "per", "read", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if len(test.user) > 0 {
+				r.SetBasicAuth(test.user, test.token)
+			}
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, r)
+			if rw.Code != test.statusCode {
+				t.Errorf("expected status code %d but was %d: %s", test.statusCode, rw.Code, rw.Body.String())
+			}
+			if rw.Code == http.StatusUnauthorized && len(rw.Header().Get("WWW-Authenticate")) == 0 {
+				t.Error("expected the client to be asked for credentials")
+			}
+		})
+	}
+}
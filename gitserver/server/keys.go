@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// Reload reads the ssh host key, the https certificate and the api server client's certificate again. New
+// connections use the new keys, while established connections and git commands in progress are not affected.
+// The current keys are kept if the new ones can't be loaded
+func (a *Server) Reload() error {
+	var result error
+	if hostKey, err := loadHostKey(a.config.SSHKeyPath); err != nil {
+		result = err
+	} else {
+		a.mutex.Lock()
+		a.hostKey = hostKey
+		a.mutex.Unlock()
+	}
+
+	if a.httpServer != nil {
+		if certificate, err := loadHTTPCertificate(a.config.HTTPCertPath, a.config.HTTPKeyPath); err != nil {
+			result = err
+		} else {
+			a.mutex.Lock()
+			a.httpCertificate = certificate
+			a.mutex.Unlock()
+		}
+	}
+
+	if err := a.apiServerClient.Reload(); err != nil {
+		result = fmt.Errorf("could not load api server client certificate: %v", err)
+	}
+	return result
+}
+
+// WatchKeys reloads the keys when any of the files are changed. It blocks until the supplied channel is closed
+func (a *Server) WatchKeys(interval time.Duration, stop <-chan struct{}) {
+	paths := []string{a.config.SSHKeyPath, a.config.ClientCertPath, a.config.ClientKeyPath, a.config.ClientCAPath}
+	if a.httpServer != nil {
+		paths = append(paths, a.config.HTTPCertPath, a.config.HTTPKeyPath)
+	}
+	modTimes := stat(paths)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// The keys are only loaded once for each change, even if they can't be loaded, since a key that is
+			// half-written will be changed again when it's completed
+			current := stat(paths)
+			if equal(current, modTimes) {
+				continue
+			}
+			modTimes = current
+			log.Println("INFO: reloading keys and certificates since they are changed")
+			if err := a.Reload(); err != nil {
+				log.Printf("WARN: could not reload keys: %v\n", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (a *Server) getHostKey() ssh.Signer {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.hostKey
+}
+
+func (a *Server) getHTTPCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.httpCertificate, nil
+}
+
+// loadHostKey reads the private key used by the ssh server
+func loadHostKey(path string) (ssh.Signer, error) {
+	privateBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read private key: %v", err)
+	}
+
+	hostKey, err := ssh.ParsePrivateKey(privateBytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %v", err)
+	}
+	return hostKey, nil
+}
+
+// loadHTTPCertificate reads the certificate used when serving git over https
+func loadHTTPCertificate(certPath string, keyPath string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not load https certificate: %v", err)
+	}
+	return &cert, nil
+}
+
+// stat returns when each of the supplied files was last changed. The time is zero if a file is missing
+func stat(paths []string) []time.Time {
+	result := make([]time.Time, len(paths))
+	for i, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			result[i] = info.ModTime()
+		}
+	}
+	return result
+}
+
+func equal(a []time.Time, b []time.Time) bool {
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
@@ -1,13 +1,17 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/westcoastcode-se/gitgo/gitserver/apiserver"
+	"github.com/westcoastcode-se/gitgo/gitserver/hooks"
 	"golang.org/x/crypto/ssh"
-	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
+	"sync"
 )
 
 // Version represents the SSH server version
@@ -17,11 +21,26 @@ const Version = "SSH-2.0-GitGo-1.0.0"
 type Server struct {
 	config   *Config
 	listener net.Listener
-	hostKey  ssh.Signer
+
+	// hostKey and httpCertificate are replaced when the keys are reloaded. Connections that are already
+	// established keep using the keys they were created with
+	mutex           sync.RWMutex
+	hostKey         ssh.Signer
+	httpCertificate *tls.Certificate
 
 	// apiServerClient is a client that we can use when calling the api server, for example, when
 	// checking if a specific fingerprint is allowed to read and write to a specific repository
 	apiServerClient *apiserver.Client
+
+	// Hooks is executed when a push is received. Hooks must be added before clients are accepted
+	Hooks *hooks.Pipeline
+
+	// hookServer receives calls from the hook scripts that git executes
+	hookServer *hooks.Server
+
+	// httpListener and httpServer serves git's Smart HTTP protocol. Both are nil if it's disabled
+	httpListener net.Listener
+	httpServer   *http.Server
 }
 
 func (a *Server) HandleConnection(conn net.Conn) {
@@ -37,8 +56,9 @@ func (a *Server) HandleConnection(conn net.Conn) {
 		context:         context,
 		cancel:          cancel,
 		connection:      conn,
-		hostKey:         a.hostKey,
+		hostKey:         a.getHostKey(),
 		apiServerClient: a.apiServerClient,
+		hookServer:      a.hookServer,
 		gitBinDir:       a.config.GitBinDir,
 		repositoryPath:  a.config.RepositoriesPath,
 		environmentVars: []string{},
@@ -47,6 +67,24 @@ func (a *Server) HandleConnection(conn net.Conn) {
 }
 
 func (a *Server) AcceptClients() error {
+	// Keep the api server client's cache in sync with the api server
+	go a.apiServerClient.WatchInvalidations(context.Background())
+
+	go func() {
+		if err := a.hookServer.Serve(); err != nil {
+			log.Printf("ERROR: could not receive calls from hooks: %v\n", err)
+		}
+	}()
+
+	if a.httpServer != nil {
+		go func() {
+			log.Printf("INFO: serving git over https on %s\n", a.config.HTTPAddress)
+			if err := a.httpServer.Serve(a.httpListener); err != nil && err != http.ErrServerClosed {
+				log.Printf("ERROR: could not serve git over https: %v\n", err)
+			}
+		}()
+	}
+
 	for {
 		conn, err := a.listener.Accept()
 		if err != nil {
@@ -67,15 +105,30 @@ func NewServer(cfg *Config) (*Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not create api server client: %v", err)
 	}
+	apiServerClient.MaxRetries = cfg.APIServerMaxRetries
+	apiServerClient.RetryDelay = cfg.APIServerRetryDelay
+	if cfg.APIServerCacheTTL > 0 {
+		apiServerClient.EnableCache(cfg.APIServerCacheTTL, cfg.APIServerNegativeCacheTTL)
+	}
 
-	privateBytes, err := ioutil.ReadFile(cfg.SSHKeyPath)
+	hostKey, err := loadHostKey(cfg.SSHKeyPath)
 	if err != nil {
-		return nil, fmt.Errorf("could not read private key: %v", err)
+		return nil, err
 	}
 
-	hostKey, err := ssh.ParsePrivateKey(privateBytes)
+	pipeline := &hooks.Pipeline{}
+	pipeline.Add(&hooks.ProtectionHook{
+		Client:         apiServerClient,
+		GitBinDir:      cfg.GitBinDir,
+		RepositoryPath: cfg.RepositoriesPath,
+	})
+	pipeline.Add(&hooks.EventHook{Client: apiServerClient})
+	if err = hooks.CheckGitVersion(cfg.GitBinDir); err != nil {
+		return nil, err
+	}
+	hookServer, err := hooks.NewServer(cfg.HooksPath, pipeline)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse private key: %v", err)
+		return nil, fmt.Errorf("could not create hook server: %v", err)
 	}
 
 	listener, err := net.Listen("tcp", cfg.Address)
@@ -88,6 +141,34 @@ func NewServer(cfg *Config) (*Server, error) {
 		listener:        listener,
 		hostKey:         hostKey,
 		apiServerClient: apiServerClient,
+		Hooks:           pipeline,
+		hookServer:      hookServer,
+	}
+
+	if len(cfg.HTTPAddress) > 0 {
+		s.httpCertificate, err = loadHTTPCertificate(cfg.HTTPCertPath, cfg.HTTPKeyPath)
+		if err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+		httpListener, err := net.Listen("tcp", cfg.HTTPAddress)
+		if err != nil {
+			_ = listener.Close()
+			return nil, fmt.Errorf("could not listen on address %s: %v", cfg.HTTPAddress, err)
+		}
+		s.httpListener = tls.NewListener(httpListener, &tls.Config{GetCertificate: s.getHTTPCertificate})
+
+		// Clones and pushes can take a long time, so only the headers must be read within the read timeout
+		s.httpServer = &http.Server{
+			Handler: &HTTPHandler{
+				apiServerClient: apiServerClient,
+				hookServer:      hookServer,
+				gitBinDir:       cfg.GitBinDir,
+				repositoryPath:  cfg.RepositoriesPath,
+			},
+			ReadHeaderTimeout: cfg.ReadTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		}
 	}
 	return s, nil
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/westcoastcode-se/gitgo/api"
 	"github.com/westcoastcode-se/gitgo/gitserver/apiserver"
+	"github.com/westcoastcode-se/gitgo/gitserver/hooks"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"log"
@@ -17,6 +18,9 @@ import (
 
 var PublicKeyNotFoundError = errors.New("could not find user matching the supplied publicKey")
 
+// fingerprintExtension is the permission extension containing the fingerprint of the key used to authenticate
+const fingerprintExtension = "pubkey-fp"
+
 // Session is an active SSH session
 type Session struct {
 	context *Context
@@ -31,6 +35,9 @@ type Session struct {
 	// apiServerClient can be used to talk to an api server
 	apiServerClient *apiserver.Client
 
+	// hookServer executes hooks when a push is received
+	hookServer *hooks.Server
+
 	// User an authorized user if set, nil if no user is found
 	User *api.User
 
@@ -50,11 +57,19 @@ func (s *Session) Close() {
 }
 
 func (s *Session) HandleConnection() {
-	// Prepare configuration for this connection
+	// Clients may offer several keys before one of them is used to authenticate, so the user is only known once
+	// the handshake is completed. Users are resolved for every key that's offered and kept by fingerprint
+	users := map[string]*api.User{}
 	sshConfig := ssh.ServerConfig{
 		Config: ssh.Config{},
 		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-			return s.publicKeyCallback(conn, key)
+			user, err := s.publicKeyCallback(conn, key)
+			if err != nil {
+				return nil, err
+			}
+			fingerprint := ssh.FingerprintSHA256(key)
+			users[fingerprint] = user
+			return &ssh.Permissions{Extensions: map[string]string{fingerprintExtension: fingerprint}}, nil
 		},
 		ServerVersion: Version,
 	}
@@ -68,25 +83,35 @@ func (s *Session) HandleConnection() {
 	}
 	log.Printf("INFO: new ssh connection from %s(%s)", sConn.RemoteAddr(), sConn.ClientVersion())
 
+	// The permissions belong to the key that the client authenticated with
+	s.User = users[sConn.Permissions.Extensions[fingerprintExtension]]
+	if s.User == nil {
+		log.Printf("WARN: could not find the user that authenticated from %s\n", sConn.RemoteAddr())
+		_ = sConn.Close()
+		return
+	}
+
 	// It's important to "service" requests, otherwise the connection will hang.
 	// We only care about requests received over a session channel on this git server
 	go ssh.DiscardRequests(reqs)
 	go s.processNewChannels(newChannels)
 }
 
-func (s *Session) publicKeyCallback(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+// publicKeyCallback resolves the user that owns the supplied public key
+func (s *Session) publicKeyCallback(_ ssh.ConnMetadata, key ssh.PublicKey) (*api.User, error) {
 	fingerprint := ssh.FingerprintSHA256(key)
 
-	// Resolve the user using the public key
-	var err error
-	s.User, err = s.apiServerClient.FindUserUsingPublicKey(fingerprint)
+	// Resolve the user using the public key. The user might be cached, but the cache is invalidated
+	// as soon as a key is removed from the api server. The login is denied if the api server can't be reached
+	user, err := s.apiServerClient.FindUserUsingPublicKey(s.context, fingerprint)
 	if err != nil {
+		if errors.Is(err, apiserver.NotFoundError) {
+			return nil, PublicKeyNotFoundError
+		}
+		log.Printf("WARN: could not resolve user for %s, denying login: %v\n", fingerprint, err)
 		return nil, err
 	}
-	if s.User == nil {
-		return nil, PublicKeyNotFoundError
-	}
-	return &ssh.Permissions{}, nil
+	return user, nil
 }
 
 func (s *Session) processNewChannels(newChannels <-chan ssh.NewChannel) {
@@ -156,17 +181,45 @@ func (s *Session) processExecRequest(ch ssh.Channel, req *ssh.Request) error {
 	command, err := Parse(payload)
 	if err != nil {
 		log.Printf("WARN: ignoring %q because it's not a valid git command", payload)
+		s.rejectExecRequest(ch, req, "invalid git command")
 		return err
 	}
 
+	// Verify that the user is allowed to access the repository before doing anything else. Users without read
+	// access are told that the repository doesn't exist, so that we don't leak which repositories exist
+	access, err := s.apiServerClient.GetRepositoryAccess(s.context, s.User.Name, command.Repository)
+	if err != nil {
+		s.rejectExecRequest(ch, req, "could not verify access to the repository, please try again later")
+		return fmt.Errorf("could not get access for %s to %s: %v", s.User.Name, command.Repository, err)
+	}
+	required := command.RequiredAccess()
+	if !access.Allows(required) {
+		if access.Allows(api.AccessRead) {
+			s.rejectExecRequest(ch, req, fmt.Sprintf("you are not allowed to push to '%s'", command.Repository))
+		} else {
+			s.rejectExecRequest(ch, req, fmt.Sprintf("repository '%s' does not exist or you do not have access to it",
+				command.Repository))
+		}
+		return fmt.Errorf("user %s is missing %s access to %s", s.User.Name, required, command.Repository)
+	}
+
 	if !RepositoryExists(filepath.Join(s.repositoryPath, command.Repository)) {
+		s.rejectExecRequest(ch, req, fmt.Sprintf("repository '%s' does not exist or you do not have access to it",
+			command.Repository))
 		return fmt.Errorf("could not find repository %s", command.Repository)
 	}
 
+	hookEnv, endHooks, err := command.beginHooks(s.hookServer, s.context, s.User, ProtocolSSH)
+	if err != nil {
+		s.rejectExecRequest(ch, req, "could not process the push, please try again later")
+		return err
+	}
+	defer endHooks()
+
 	commandFilename := filepath.Join(s.gitBinDir, command.Command)
 	cmd := exec.CommandContext(s.context, commandFilename, command.Repository)
 	cmd.Dir = s.repositoryPath
-	cmd.Env = s.environmentVars
+	cmd.Env = append(append([]string{}, s.environmentVars...), hookEnv...)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -236,9 +289,25 @@ func (s *Session) processExecRequest(ch ssh.Channel, req *ssh.Request) error {
 	}
 
 	_, _ = ch.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
+	if required == api.AccessRead {
+		reportFetch(s.apiServerClient, s.context.GetRequestUUID(), s.User, command, ProtocolSSH)
+	}
 	return nil
 }
 
+// rejectExecRequest tells the client that the exec request is denied. The message is written to the client's
+// stderr, and the command is terminated with a non-zero exit status
+func (s *Session) rejectExecRequest(ch ssh.Channel, req *ssh.Request, message string) {
+	_ = req.Reply(true, nil)
+	_, _ = fmt.Fprintf(ch.Stderr(), "ERROR: %s\n", message)
+	_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(&exitStatus{Status: 1}))
+}
+
+// exitStatus is the payload sent in an "exit-status" request
+type exitStatus struct {
+	Status uint32
+}
+
 // Contains environment variables that are allowed by the server
 var allowedEnvironmentVariables = []string{"GIT_PROTOCOL"}
 
@@ -249,5 +318,5 @@ func isEnvironmentAllowed(key string) bool {
 			return true
 		}
 	}
-	return true
+	return false
 }